@@ -0,0 +1,279 @@
+/*
+Copyright © 2023 Florian Obersteiner <f.obersteiner@posteo.de>
+
+License: see LICENSE in the root directory of the repo.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/FObersteiner/gosyncit/lib/agent"
+	"github.com/FObersteiner/gosyncit/lib/compare"
+	"github.com/FObersteiner/gosyncit/lib/copy"
+	"github.com/FObersteiner/gosyncit/lib/fileset"
+	"github.com/FObersteiner/gosyncit/lib/libsftp"
+	"github.com/FObersteiner/gosyncit/lib/manifest"
+	"github.com/FObersteiner/gosyncit/lib/space"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent 'root'",
+	Short: "speak the native gosyncit protocol on stdin and stdout, for directory 'root'",
+	Long: `The agent is started on the remote host by 'sftpmirror --agent' via SSH; there is no need
+to run it manually. It scans and hashes the files in 'root' locally and applies the changes
+it receives in batches, which saves the round trips SFTP needs per file.`,
+	SilenceUsage: true,
+	Args:         cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		return agent.Serve(args[0], viper.GetBool("dryrun"), os.Stdin, os.Stdout)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+
+	agentCmd.Flags().BoolVarP(&dryRun, "dryrun", "n", false, "write nothing; for dry runs of 'sftpmirror --agent'")
+	err := viper.BindPFlag("dryrun", agentCmd.Flags().Lookup("dryrun"))
+	if err != nil {
+		log.Fatal("error binding viper to 'dryrun' flag:", err)
+	}
+}
+
+// ------------------------------------------------------------------------------------
+
+var (
+	useAgent     bool   // option for sftpmirror
+	agentCommand string // gosyncit executable on the remote host
+)
+
+// agentUnsupported returns the first option that is set but cannot be used with the agent, or ""
+func agentUnsupported() string {
+	switch {
+	case backupDir != "":
+		return "--backup-dir"
+	case useTrash:
+		return "--trash"
+	case detectRenames:
+		return "--detect-renames"
+	case hardLinks:
+		return "--hard-links"
+	case sftpPreserve.Atime:
+		return "--atimes"
+	}
+	return ""
+}
+
+// dialAgent starts the agent for 'remote' on the server of the pool if the agent option is set.
+// Returns nil (and no error) to use SFTP instead, e.g. if gosyncit is not installed on the server.
+func dialAgent(pool *libsftp.Pool, remote string, dry bool) (*agent.Client, error) {
+	if !useAgent {
+		return nil, nil
+	}
+	if opt := agentUnsupported(); opt != "" {
+		fmt.Printf("warning: %s cannot be used with --agent; using SFTP\n", opt)
+		return nil, nil
+	}
+	c, err := agent.Dial(pool.Conn(), agentCommand, remote, dry)
+	if errors.Is(err, agent.ErrNoAgent) {
+		fmt.Printf("warning: %v; using SFTP\n", err)
+		return nil, nil
+	}
+	return c, err
+}
+
+// agentLocalToRemote mirrors directory 'local' to the root of agent 'c', like sftpLocalToRemote
+func agentLocalToRemote(c *agent.Client, local string, dry, ignorehidden, clean bool) error {
+	var nItems, nBytes uint
+	t0 := time.Now()
+	verifyFailed = nil
+	c.Perms = sftpPreserve.Perms
+	verboseprintf("gosyncit agent serves '%s'\n", c.Root)
+
+	filesetLocal, err := fileset.New(local)
+	if err != nil {
+		verboseprint("local file set creation error:", err)
+		return err
+	}
+	if err := filesetLocal.Populate(); err != nil {
+		verboseprint("local fileset population got error", err)
+		return err
+	}
+	filesetRemote, err := c.Scan()
+	if err != nil {
+		verboseprint("remote fileset population got error", err)
+		return err
+	}
+
+	// the agent sets modification times like a local copy, so only its file system matters
+	cmpOpts := compare.DefaultOptions()
+	if c.Resolution > cmpOpts.Granularity {
+		verboseprintf("remote has a timestamp resolution of %v, using it as modify window\n", c.Resolution)
+		cmpOpts.Window = c.Resolution
+	}
+
+	planned := filesetLocal.Filter(keepName(ignorehidden))
+	need := space.Needed(planned, filesetRemote, cmpOpts.BasicUnequal, false)
+	free := func() (uint64, error) {
+		if c.Free == 0 {
+			return 0, errors.New("not reported by the agent")
+		}
+		return c.Free, nil
+	}
+	if err := checkSpace(need, filesetRemote.Basepath, free, dry); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(planned.Paths))
+	for name := range planned.Paths {
+		names = append(names, name)
+	}
+	sort.Strings(names) // directories before their content
+
+	// with the checksum option, files of equal size are compared by hash, all in one request
+	var remoteHashes map[string]string
+	if checksum {
+		var same []string
+		for _, name := range names {
+			srcInfo, dstInfo := planned.Paths[name], filesetRemote.Paths[name]
+			if srcInfo.Mode().IsRegular() && dstInfo != nil && dstInfo.Mode().IsRegular() && srcInfo.Size() == dstInfo.Size() {
+				same = append(same, name)
+			}
+		}
+		hashes, err := c.Hash(same)
+		if err != nil {
+			return err
+		}
+		remoteHashes = make(map[string]string, len(same))
+		for i, name := range same {
+			remoteHashes[name] = hashes[i]
+		}
+	}
+	unequal := func(name string, srcInfo, dstInfo os.FileInfo) (bool, error) {
+		h, ok := remoteHashes[name]
+		if !ok {
+			return cmpOpts.BasicUnequal(srcInfo, dstInfo), nil
+		}
+		l, err := manifest.HashFile(filepath.Join(local, name))
+		return l != h, err
+	}
+
+	// step 1: copy everything from local to remote if src newer (or size different)
+	for _, name := range names {
+		srcInfo := planned.Paths[name]
+		srcPath := filepath.Join(local, name)
+		nItems++
+		nBytes += uint(srcInfo.Size())
+
+		if srcInfo.IsDir() {
+			if dstInfo, ok := filesetRemote.Paths[name]; !ok || !dstInfo.IsDir() {
+				verboseprintf("create dir '%s'\n", name)
+				if !dry {
+					if err := c.Mkdir(name); err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		}
+		if !srcInfo.Mode().IsRegular() {
+			verboseprintf("skip non-regular file '%s'\n", srcPath)
+			continue
+		}
+
+		upload := c.Upload
+		if dstInfo, ok := filesetRemote.Paths[name]; !ok {
+			fmt.Printf("copy file '%s'\n", srcPath)
+		} else {
+			differs, err := unequal(name, srcInfo, dstInfo)
+			if err != nil {
+				return err
+			}
+			if !differs {
+				verboseprintf("skip file '%s'\n", srcPath)
+				continue
+			}
+			fmt.Printf("overwrite file '%s'\n", srcPath)
+			if useDelta {
+				upload = c.UploadDelta
+			}
+		}
+		if dry {
+			continue
+		}
+		err := verified(srcPath, dry,
+			func() error {
+				n, err := upload(srcPath, name)
+				if err == nil && verifyCopies {
+					err = c.Flush()
+				}
+				verboseprintf("%v sent for '%s'\n", copy.ByteCount(uint(n)), srcPath)
+				return err
+			},
+			func() (bool, error) {
+				h, err := c.Hash([]string{name})
+				if err != nil {
+					return false, err
+				}
+				l, err := manifest.HashFile(srcPath)
+				return l == h[0], err
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if err := c.Flush(); err != nil {
+		return err
+	}
+
+	// step 2: clean everything from remote that is not in local
+	if clean {
+		var deletions []string
+		for name := range filesetRemote.Paths {
+			if ignorehidden && (strings.HasPrefix(name, ".") || strings.Contains(name, "/.")) {
+				verboseprintf("skip hidden '%s'\n", name)
+				continue
+			}
+			if !filesetLocal.Contains(name) {
+				deletions = append(deletions, name)
+			}
+		}
+		// a directory must be empty to be removed, so handle its content first
+		sort.Sort(sort.Reverse(sort.StringSlice(deletions)))
+
+		if err := checkDeletions(deletions, len(filesetLocal.Paths), len(filesetRemote.Paths), dry); err != nil {
+			return err
+		}
+		for _, name := range deletions {
+			fmt.Printf("file/dir '%v' does not exist in src, delete\n", name)
+			if !dry {
+				if err := c.Remove(name); err != nil {
+					verboseprint("deletion failed,", err)
+				}
+			}
+		}
+		if err := c.Flush(); err != nil {
+			verboseprint("deletion failed,", err)
+		}
+	}
+
+	dt := time.Since(t0)
+	verboseprintf("~~~ SFTP MIRROR done ~~~\n%v items (%v) in %v\n~~~\n",
+		nItems,
+		copy.ByteCount(nBytes),
+		dt,
+	)
+
+	return verifySummary()
+}
@@ -0,0 +1,54 @@
+/*
+Copyright © 2023 Florian Obersteiner <f.obersteiner@posteo.de>
+
+License: see LICENSE in the root directory of the repo.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/FObersteiner/gosyncit/lib/hashcache"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "manage the persistent hash cache",
+	Long: `The hash cache stores hashes of local files, keyed by device, inode, size, mtime and
+ctime, so that 'snapshot --checksum --hash-cache' and 'diff --checksum --hash-cache' do not
+hash unchanged files again. 'scrub --hash-cache' re-hashes files to check them against the cache.`,
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:          "prune",
+	Short:        "remove entries of files that were deleted or changed",
+	SilenceUsage: true,
+	Args:         cobra.NoArgs,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		path, err := hashcache.DefaultPath()
+		if err != nil {
+			return err
+		}
+		return CachePrune(path)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+}
+
+// ------------------------------------------------------------------------------------
+
+// CachePrune removes stale entries from the hash cache in file 'path'
+func CachePrune(path string) error {
+	c, err := hashcache.Open(path)
+	if err != nil {
+		return err
+	}
+	n := c.Prune()
+	fmt.Printf("removed %v entries from '%s', %v left\n", n, path, c.Len())
+	return c.Save()
+}
@@ -0,0 +1,334 @@
+/*
+Copyright © 2023 Florian Obersteiner <f.obersteiner@posteo.de>
+
+License: see LICENSE in the root directory of the repo.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/FObersteiner/gosyncit/lib/compare"
+	"github.com/FObersteiner/gosyncit/lib/fileset"
+	"github.com/FObersteiner/gosyncit/lib/libsftp"
+	"github.com/FObersteiner/gosyncit/lib/pathlib"
+)
+
+var diffCmd = &cobra.Command{
+	Use:     "diff 'A' 'B'",
+	Aliases: []string{"check"},
+	Short:   "compare directory 'A' with directory 'B' without changing anything",
+	Long: `Report what only exists in A or in B, which files differ (by size, mtime or content),
+and how many entries are identical.
+A and B can be local directories or directories on an SFTP server, given as [user@]host:path.
+The exit status is 0 if A and B are identical, 1 if they differ and 2 if an error occurred.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if err := cobra.ExactArgs(2)(cmd, args); err != nil {
+			return &exitError{code: 2, err: err}
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sshFlags = cmd.Flags()
+		ignorehidden := viper.GetBool("skiphidden")
+		deep := viper.GetBool("checksum")
+		setGlobalVerbose := viper.GetBool("verbose")
+		verbose = setGlobalVerbose
+
+		if err := openHashCache(); err != nil {
+			return &exitError{code: 2, err: err}
+		}
+		equal, err := Diff(args[0], args[1], deep, ignorehidden)
+		closeHashCache()
+		if err != nil {
+			return &exitError{code: 2, err: err}
+		}
+		if !equal {
+			return &exitError{code: 1}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().SortFlags = false
+
+	diffCmd.Flags().BoolVarP(&checksum, "checksum", "c", false, "compare the content of files with equal size")
+	err := viper.BindPFlag("checksum", diffCmd.Flags().Lookup("checksum"))
+	if err != nil {
+		log.Fatal("error binding viper to 'checksum' flag:", err)
+	}
+
+	diffCmd.Flags().BoolVar(&useHashCache, "hash-cache", false, "keep hashes of local files in a persistent cache, so unchanged files are not hashed again")
+	err = viper.BindPFlag("hash-cache", diffCmd.Flags().Lookup("hash-cache"))
+	if err != nil {
+		log.Fatal("error binding viper to 'hash-cache' flag:", err)
+	}
+
+	diffCmd.Flags().BoolVar(&hashCacheXattr, "xattr", false, "with --hash-cache, also store hashes in extended attributes of the files")
+	err = viper.BindPFlag("xattr", diffCmd.Flags().Lookup("xattr"))
+	if err != nil {
+		log.Fatal("error binding viper to 'xattr' flag:", err)
+	}
+
+	diffCmd.Flags().BoolVarP(&skipHidden, "skiphidden", "s", false, "skip hidden files")
+	err = viper.BindPFlag("skiphidden", diffCmd.Flags().Lookup("skiphidden"))
+	if err != nil {
+		log.Fatal("error binding viper to 'skiphidden' flag:", err)
+	}
+
+	diffCmd.Flags().IntVarP(&port, "port", "p", 22, "ssh port number")
+	err = viper.BindPFlag("port", diffCmd.Flags().Lookup("port"))
+	if err != nil {
+		log.Fatal("error binding viper to 'port' flag:", err)
+	}
+
+	diffCmd.Flags().DurationVar(&modifyWindow, "modify-window", 0, "treat modification times as equal if they differ by no more than this")
+	err = viper.BindPFlag("modify-window", diffCmd.Flags().Lookup("modify-window"))
+	if err != nil {
+		log.Fatal("error binding viper to 'modify-window' flag:", err)
+	}
+
+	diffCmd.Flags().BoolVar(&ignoreDSTShift, "ignore-dst-shift", false, "also treat modification times as equal if they differ by exactly one hour (FAT and DST changes)")
+	err = viper.BindPFlag("ignore-dst-shift", diffCmd.Flags().Lookup("ignore-dst-shift"))
+	if err != nil {
+		log.Fatal("error binding viper to 'ignore-dst-shift' flag:", err)
+	}
+
+	addSSHFlags(diffCmd.Flags())
+
+	diffCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output to the command line")
+	err = viper.BindPFlag("verbose", diffCmd.Flags().Lookup("verbose"))
+	if err != nil {
+		log.Fatal("error binding viper to 'verbose' flag:", err)
+	}
+}
+
+// ------------------------------------------------------------------------------------
+
+// Diff compares directories 'a' and 'b' and prints the differences.
+// If deep is true, the content of files with equal size is compared as well.
+// Returns true if both are identical.
+func Diff(a, b string, deep, skipHidden bool) (bool, error) {
+	epA, err := openEndpoint(a, deep)
+	if err != nil {
+		return false, err
+	}
+	defer epA.close()
+
+	epB, err := openEndpoint(b, deep)
+	if err != nil {
+		return false, err
+	}
+	defer epB.close()
+
+	setA, setB := epA.fs, epB.fs
+	if skipHidden {
+		notHidden := func(name string) bool {
+			return !(strings.HasPrefix(name, ".") || strings.Contains(name, "/."))
+		}
+		setA, setB = setA.Filter(notHidden), setB.Filter(notHidden)
+	}
+
+	opts := compare.DefaultOptions()
+	opts.Window = viper.GetDuration("modify-window")
+	opts.DST = viper.GetBool("ignore-dst-shift")
+	// SFTP only transfers whole seconds
+	if epA.remote || epB.remote {
+		opts.Granularity = time.Second
+	}
+
+	var content func(name string) (bool, error)
+	switch {
+	case deep && hashCache != nil && !epA.remote && !epB.remote:
+		content = func(name string) (bool, error) {
+			return compare.HashEqual(filepath.Join(setA.Basepath, name), filepath.Join(setB.Basepath, name), hashCache)
+		}
+	case deep && (epA.remoteHash() || epB.remoteHash()):
+		wantHashes(epA.hasher, setA, setB)
+		wantHashes(epB.hasher, setB, setA)
+		content = func(name string) (bool, error) { return hashEqual(epA, epB, name) }
+	case deep:
+		content = func(name string) (bool, error) {
+			fa, err := epA.open(name)
+			if err != nil {
+				return false, err
+			}
+			defer fa.Close()
+			fb, err := epB.open(name)
+			if err != nil {
+				return false, err
+			}
+			defer fb.Close()
+			return compare.ReaderEqual(fa, fb)
+		}
+	}
+
+	r, err := fileset.Diff(setA, setB, opts.MtimeEqual, content)
+	if err != nil {
+		return false, err
+	}
+
+	for _, name := range r.OnlyA {
+		fmt.Printf("only in A: '%s'\n", name)
+	}
+	for _, name := range r.OnlyB {
+		fmt.Printf("only in B: '%s'\n", name)
+	}
+	for _, d := range r.Differ {
+		fmt.Printf("differ (%s): '%s'\n", d.Reason, d.Path)
+	}
+	fmt.Printf("%v identical, %v only in A, %v only in B, %v differ\n",
+		r.Identical, len(r.OnlyA), len(r.OnlyB), len(r.Differ))
+
+	return r.Equal(), nil
+}
+
+// endpoint is a directory on the local file system or on an SFTP server
+type endpoint struct {
+	fs     *fileset.Fileset
+	remote bool
+	// open a file by its path relative to the directory
+	open  func(name string) (io.ReadCloser, error)
+	close func() error
+	// hashes remote files, if content is compared
+	hasher *libsftp.Hasher
+}
+
+// remoteHash returns true if the files of a remote endpoint are hashed on the server
+func (ep *endpoint) remoteHash() bool {
+	return ep.hasher != nil && ep.hasher.Exec() != ""
+}
+
+// hashEqual compares file 'name' of two endpoints by hash, using the checksum program on the
+// server of at least one of them. If the other one is remote as well, it must hash on the server
+// with the same algorithm; otherwise, the content is compared by reading both files.
+func hashEqual(a, b *endpoint, name string) (bool, error) {
+	if !a.remoteHash() {
+		a, b = b, a
+	}
+	if b.remote && !(b.remoteHash() && b.hasher.Algo() == a.hasher.Algo()) {
+		fa, err := a.open(name)
+		if err != nil {
+			return false, err
+		}
+		defer fa.Close()
+		fb, err := b.open(name)
+		if err != nil {
+			return false, err
+		}
+		defer fb.Close()
+		return compare.ReaderEqual(fa, fb)
+	}
+
+	ha, err := a.hasher.Hash(path.Join(a.fs.Basepath, name))
+	if err != nil {
+		return false, err
+	}
+	var hb string
+	if b.remote {
+		hb, err = b.hasher.Hash(path.Join(b.fs.Basepath, name))
+	} else {
+		hb, err = a.hasher.LocalHash(filepath.Join(b.fs.Basepath, name))
+	}
+	return ha == hb, err
+}
+
+// openEndpoint populates the fileset of a local directory or of a remote directory given as
+// [user@]host:path. The remote user defaults to the current user, the port to the port option.
+// If deep is set, a remote endpoint gets a hasher, see libsftp.NewHasher.
+func openEndpoint(s string, deep bool) (*endpoint, error) {
+	usr, host, path, ok := libsftp.ParseURL(s)
+	if !ok {
+		dir, err := pathlib.CheckDirPath(s)
+		if err != nil {
+			return nil, err
+		}
+		set, err := fileset.New(dir)
+		if err != nil {
+			return nil, err
+		}
+		if err := set.Populate(); err != nil {
+			return nil, err
+		}
+		return &endpoint{
+			fs:    set,
+			open:  func(name string) (io.ReadCloser, error) { return os.Open(filepath.Join(set.Basepath, name)) },
+			close: func() error { return nil },
+		}, nil
+	}
+
+	if usr == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		usr = u.Username
+	}
+	creds := libsftp.Credentials{
+		Usr:       usr,
+		Host:      host,
+		AgentSock: "SSH_AUTH_SOCK",
+		Port:      port,
+		SSH:       sshOptions(host),
+	}
+	sshcon, err := libsftp.GetSSHconn(creds)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := sftp.NewClient(sshcon)
+	if err != nil {
+		sshcon.Close()
+		return nil, err
+	}
+	verboseprintf("SFTP connection established; %s\n", &creds)
+
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	set := &fileset.Fileset{
+		Basepath: path,
+		Paths:    make(map[string]fs.FileInfo),
+	}
+	if err := set.SftpPopulate(sc); err != nil {
+		sc.Close()
+		sshcon.Close()
+		return nil, err
+	}
+	var hasher *libsftp.Hasher
+	if deep {
+		hasher = libsftp.NewHasher(sc, sshcon)
+		if hasher.Exec() != "" {
+			verboseprintf("hash files on '%s' with '%s'\n", host, hasher.Exec())
+		} else {
+			verboseprintf("cannot hash files on '%s', read them instead\n", host)
+		}
+	}
+	return &endpoint{
+		fs:     set,
+		remote: true,
+		hasher: hasher,
+		open:   func(name string) (io.ReadCloser, error) { return sc.Open(filepath.Join(set.Basepath, name)) },
+		close: func() error {
+			sc.Close()
+			return sshcon.Close()
+		},
+	}, nil
+}
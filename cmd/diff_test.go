@@ -0,0 +1,74 @@
+package cmd_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FObersteiner/gosyncit/cmd"
+)
+
+func TestDiff(t *testing.T) {
+	if _, err := cmd.Diff("A", "B", false, false); err == nil {
+		t.Fail()
+		t.Log("diff must fail with invalid input")
+	}
+
+	dirA, err := os.MkdirTemp("", "dirA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirA)
+
+	dirB, err := os.MkdirTemp("", "dirB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirB)
+
+	mtime := time.Date(2006, time.March, 1, 3, 4, 5, 0, time.UTC)
+	write := func(path, content string) {
+		_ = os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(dirA, "sub", "file"), "content")
+	write(filepath.Join(dirB, "sub", "file"), "content")
+	write(filepath.Join(dirA, ".hidden"), "content")
+
+	equal, err := cmd.Diff(dirA, dirB, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if equal {
+		t.Log("hidden file only in A, expected a difference")
+		t.Fail()
+	}
+
+	equal, err = cmd.Diff(dirA, dirB, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal {
+		t.Log("hidden file skipped, expected no difference")
+		t.Fail()
+	}
+
+	// same size and mtime; only found by content
+	write(filepath.Join(dirB, "sub", "file"), "CONTENT")
+	for _, deep := range []bool{false, true} {
+		equal, err = cmd.Diff(dirA, dirB, deep, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if equal == deep {
+			t.Logf("content changed, checksum: %v, have equal: %v", deep, equal)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package cmd
+
+// exported for tests in package cmd_test
+
+var (
+	Verified      = verified
+	VerifySummary = verifySummary
+)
+
+// SetVerifyCopies sets the verify option and clears the failed verifications.
+func SetVerifyCopies(v bool) {
+	verifyCopies = v
+	verifyFailed = nil
+}
+
+// VerifyFailed returns the files recorded as failed verifications.
+func VerifyFailed() []string {
+	return verifyFailed
+}
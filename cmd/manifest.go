@@ -0,0 +1,218 @@
+/*
+Copyright © 2023 Florian Obersteiner <f.obersteiner@posteo.de>
+
+License: see LICENSE in the root directory of the repo.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/FObersteiner/gosyncit/lib/fileset"
+	"github.com/FObersteiner/gosyncit/lib/manifest"
+	"github.com/FObersteiner/gosyncit/lib/pathlib"
+)
+
+var manifestCmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "create or verify checksum manifests of a directory",
+	Long: `A manifest lists the files of a directory with size, mtime and SHA-256 hash.
+Formats are sha256sum-compatible (hash and path only), JSON and CSV.`,
+}
+
+var manifestCreateCmd = &cobra.Command{
+	Use:          "create 'dir'",
+	Short:        "create a manifest of directory 'dir'",
+	SilenceUsage: true,
+	Args:         cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		return ManifestCreate(args[0], viper.GetString("output"), viper.GetString("format"))
+	},
+}
+
+var manifestVerifyCmd = &cobra.Command{
+	Use:          "verify 'dir' 'manifest'",
+	Short:        "report files in directory 'dir' that are missing, extra or corrupted compared to 'manifest'",
+	SilenceUsage: true,
+	Args:         cobra.ExactArgs(2),
+	RunE: func(_ *cobra.Command, args []string) error {
+		ok, err := ManifestVerify(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("directory does not match manifest")
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(manifestCmd)
+	manifestCmd.AddCommand(manifestCreateCmd, manifestVerifyCmd)
+
+	manifestCreateCmd.Flags().SortFlags = false
+	manifestCreateCmd.Flags().StringVarP(&manifestOutput, "output", "o", "", "write the manifest to this file instead of stdout")
+	err := viper.BindPFlag("output", manifestCreateCmd.Flags().Lookup("output"))
+	if err != nil {
+		log.Fatal("error binding viper to 'output' flag:", err)
+	}
+
+	manifestCreateCmd.Flags().StringVar(&manifestFormat, "format", "", "sha256sum, json or csv (default: from the extension of the output file, else sha256sum)")
+	err = viper.BindPFlag("format", manifestCreateCmd.Flags().Lookup("format"))
+	if err != nil {
+		log.Fatal("error binding viper to 'format' flag:", err)
+	}
+}
+
+// ------------------------------------------------------------------------------------
+
+// ManifestCreate writes a manifest of directory 'dir' to file 'output', or to stdout if output is empty.
+// If format is empty, it is derived from the name of the output file.
+func ManifestCreate(dir, output, format string) error {
+	dir, err := pathlib.CheckDirPath(dir)
+	if err != nil {
+		return err
+	}
+	set, err := fileset.New(dir)
+	if err != nil {
+		return err
+	}
+	if err := set.Populate(); err != nil {
+		return err
+	}
+	if format == "" {
+		format = manifest.FormatFromName(output)
+	}
+
+	entries, err := manifest.Create(set, nil, skipFile(set.Basepath, output))
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		return manifest.Write(os.Stdout, entries, format)
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := manifest.Write(f, entries, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ManifestVerify checks directory 'dir' against manifest file 'mf' and prints missing, extra and corrupted files.
+// Returns true if the directory matches the manifest.
+func ManifestVerify(dir, mf string) (bool, error) {
+	dir, err := pathlib.CheckDirPath(dir)
+	if err != nil {
+		return false, err
+	}
+	entries, err := manifest.ReadFile(mf)
+	if err != nil {
+		return false, err
+	}
+	set, err := fileset.New(dir)
+	if err != nil {
+		return false, err
+	}
+	if err := set.Populate(); err != nil {
+		return false, err
+	}
+
+	r, err := manifest.Verify(set, entries, skipFile(set.Basepath, mf))
+	if err != nil {
+		return false, err
+	}
+	for _, name := range r.Missing {
+		fmt.Printf("missing: '%s'\n", name)
+	}
+	for _, name := range r.Extra {
+		fmt.Printf("extra: '%s'\n", name)
+	}
+	for _, name := range r.Corrupted {
+		fmt.Printf("corrupted: '%s'\n", name)
+	}
+	fmt.Printf("%v ok, %v missing, %v extra, %v corrupted\n", r.Ok, len(r.Missing), len(r.Extra), len(r.Corrupted))
+	return r.Equal(), nil
+}
+
+// skipFile returns a function that reports whether a path relative to 'basepath' is file 'name',
+// so that a manifest does not list itself.
+func skipFile(basepath, name string) func(string) bool {
+	if name == "" {
+		return nil
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return nil
+	}
+	rel, ok := strings.CutPrefix(abs, basepath)
+	if !ok {
+		return nil // not within basepath
+	}
+	return func(p string) bool { return p == rel }
+}
+
+// manifestPath returns the path of the manifest file given by the manifest option,
+// relative to directory 'dst' unless absolute.
+func manifestPath(dst string) string {
+	if manifestName == "" || filepath.IsAbs(manifestName) {
+		return manifestName
+	}
+	return filepath.Join(dst, manifestName)
+}
+
+// isManifest returns a function that reports whether a path relative to 'dst' is the manifest
+// file given by the manifest option, or nil if the option is not set.
+func isManifest(dst string) func(name string) bool {
+	if !strings.HasSuffix(dst, string(os.PathSeparator)) {
+		dst += string(os.PathSeparator)
+	}
+	return skipFile(dst, manifestPath(dst))
+}
+
+// writeManifest updates the manifest file given by the manifest option in directory 'dst'
+// after a mirror or sync run. Hashes of files that did not change are taken from the previous manifest.
+// Files for which 'skip' returns true are left out; skip may be nil.
+func writeManifest(dst string, skip func(name string) bool, dry bool) error {
+	set, err := fileset.New(dst)
+	if err != nil {
+		if dry {
+			return nil // dst not created in a dry run
+		}
+		return err
+	}
+	name := manifestPath(set.Basepath)
+	fmt.Printf("update manifest '%s'\n", name)
+	if dry {
+		return nil
+	}
+	if err := set.Populate(); err != nil {
+		return err
+	}
+
+	prev, err := manifest.ReadFile(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		verboseprint("previous manifest not readable, hash all files:", err)
+	}
+	self := isManifest(set.Basepath)
+	entries, err := manifest.Create(set, prev, func(p string) bool {
+		return (self != nil && self(p)) || (skip != nil && skip(p))
+	})
+	if err != nil {
+		return err
+	}
+	return manifest.WriteFile(name, entries)
+}
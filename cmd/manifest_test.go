@@ -0,0 +1,58 @@
+package cmd_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/FObersteiner/gosyncit/cmd"
+)
+
+func TestManifest(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a", "sub/b"} {
+		fname := filepath.Join(dir, name)
+		_ = os.MkdirAll(filepath.Dir(fname), 0755)
+		if err := os.WriteFile(fname, []byte("content "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"MANIFEST.sha256", "MANIFEST.json", "MANIFEST.csv"} {
+		mf := filepath.Join(dir, name)
+		if err := cmd.ManifestCreate(dir, mf, ""); err != nil {
+			t.Fatal(err)
+		}
+		// the manifest itself is not an extra file
+		ok, err := cmd.ManifestVerify(dir, mf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Logf("%v: verify returned %v", name, ok)
+			t.Fail()
+		}
+		_ = os.Remove(mf)
+	}
+
+	mf := filepath.Join(dir, "MANIFEST.json")
+	if err := cmd.ManifestCreate(dir, mf, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a"), []byte("content A"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ok, err := cmd.ManifestVerify(dir, mf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Log("verify must fail after file was changed")
+		t.Fail()
+	}
+}
@@ -12,16 +12,21 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/FObersteiner/gosyncit/lib/backup"
 	"github.com/FObersteiner/gosyncit/lib/compare"
 	"github.com/FObersteiner/gosyncit/lib/copy"
 	"github.com/FObersteiner/gosyncit/lib/fileset"
 	"github.com/FObersteiner/gosyncit/lib/pathlib"
+	"github.com/FObersteiner/gosyncit/lib/safety"
+	"github.com/FObersteiner/gosyncit/lib/space"
+	"github.com/FObersteiner/gosyncit/lib/trash"
 )
 
 var mirrorCmd = &cobra.Command{
@@ -50,6 +55,24 @@ By default, anything that exists in the destination but not in the source will b
 		ignorehidden := viper.GetBool("skiphidden")
 		setGlobalVerbose := viper.GetBool("verbose")
 		verbose = setGlobalVerbose
+		ignoreSpace = viper.GetBool("ignore-space")
+		verifyCopies = viper.GetBool("verify")
+		if err := setCopyOptions(); err != nil {
+			return err
+		}
+		useDelta = viper.GetBool("delta")
+		hardLinks = viper.GetBool("hard-links")
+		detectRenames = viper.GetBool("detect-renames")
+		verifyRenames = viper.GetBool("verify-renames")
+		backupDir = viper.GetString("backup-dir")
+		backupSuffix = viper.GetString("suffix")
+		maxDelete = viper.GetInt("max-delete")
+		maxDeletePercent = viper.GetFloat64("max-delete-percent")
+		allowEmptySrc = viper.GetBool("allow-empty-src")
+		useTrash = viper.GetBool("trash")
+		trashMaxAge = viper.GetDuration("trash-max-age")
+		trashDir = viper.GetString("trash-dir")
+		manifestName = viper.GetString("manifest")
 
 		return Mirror(src, dst, dry, clean, ignorehidden)
 	},
@@ -78,6 +101,120 @@ func init() {
 		log.Fatal("error binding viper to 'skiphidden' flag:", err)
 	}
 
+	mirrorCmd.Flags().BoolVar(&useDelta, "delta", false, "only transfer the changed blocks of files that exist in dst")
+	err = viper.BindPFlag("delta", mirrorCmd.Flags().Lookup("delta"))
+	if err != nil {
+		log.Fatal("error binding viper to 'delta' flag:", err)
+	}
+
+	mirrorCmd.Flags().BoolVarP(&hardLinks, "hard-links", "H", false, "preserve hard links between files in src")
+	err = viper.BindPFlag("hard-links", mirrorCmd.Flags().Lookup("hard-links"))
+	if err != nil {
+		log.Fatal("error binding viper to 'hard-links' flag:", err)
+	}
+
+	mirrorCmd.Flags().BoolVar(&detectRenames, "detect-renames", false, "move files and dirs that were moved in src, instead of copy and delete")
+	err = viper.BindPFlag("detect-renames", mirrorCmd.Flags().Lookup("detect-renames"))
+	if err != nil {
+		log.Fatal("error binding viper to 'detect-renames' flag:", err)
+	}
+
+	mirrorCmd.Flags().BoolVar(&verifyRenames, "verify-renames", false, "compare file content before treating a file as moved")
+	err = viper.BindPFlag("verify-renames", mirrorCmd.Flags().Lookup("verify-renames"))
+	if err != nil {
+		log.Fatal("error binding viper to 'verify-renames' flag:", err)
+	}
+
+	mirrorCmd.Flags().IntVar(&maxDelete, "max-delete", 0, "abort if more than n files / dirs would be deleted (0: no limit)")
+	err = viper.BindPFlag("max-delete", mirrorCmd.Flags().Lookup("max-delete"))
+	if err != nil {
+		log.Fatal("error binding viper to 'max-delete' flag:", err)
+	}
+
+	mirrorCmd.Flags().Float64Var(&maxDeletePercent, "max-delete-percent", 0, "abort if more than this percentage of dst would be deleted (0: no limit)")
+	err = viper.BindPFlag("max-delete-percent", mirrorCmd.Flags().Lookup("max-delete-percent"))
+	if err != nil {
+		log.Fatal("error binding viper to 'max-delete-percent' flag:", err)
+	}
+
+	mirrorCmd.Flags().BoolVar(&allowEmptySrc, "allow-empty-src", false, "allow deleting the content of dst if src is empty")
+	err = viper.BindPFlag("allow-empty-src", mirrorCmd.Flags().Lookup("allow-empty-src"))
+	if err != nil {
+		log.Fatal("error binding viper to 'allow-empty-src' flag:", err)
+	}
+
+	mirrorCmd.Flags().BoolVar(&useTrash, "trash", false, "move deleted files to the trash instead of removing them")
+	err = viper.BindPFlag("trash", mirrorCmd.Flags().Lookup("trash"))
+	if err != nil {
+		log.Fatal("error binding viper to 'trash' flag:", err)
+	}
+
+	mirrorCmd.Flags().StringVar(&trashDir, "trash-dir", "", "trash directory, relative to dst if not absolute (default: XDG trash of the file system of dst)")
+	err = viper.BindPFlag("trash-dir", mirrorCmd.Flags().Lookup("trash-dir"))
+	if err != nil {
+		log.Fatal("error binding viper to 'trash-dir' flag:", err)
+	}
+
+	mirrorCmd.Flags().DurationVar(&trashMaxAge, "trash-max-age", 30*24*time.Hour, "remove items from the trash after this time (0: keep forever)")
+	err = viper.BindPFlag("trash-max-age", mirrorCmd.Flags().Lookup("trash-max-age"))
+	if err != nil {
+		log.Fatal("error binding viper to 'trash-max-age' flag:", err)
+	}
+
+	mirrorCmd.Flags().StringVar(&backupDir, "backup-dir", "", "move overwritten or deleted files to a timestamped tree in this directory (relative to dst)")
+	err = viper.BindPFlag("backup-dir", mirrorCmd.Flags().Lookup("backup-dir"))
+	if err != nil {
+		log.Fatal("error binding viper to 'backup-dir' flag:", err)
+	}
+
+	mirrorCmd.Flags().StringVar(&backupSuffix, "suffix", "", "suffix appended to files in the backup directory")
+	err = viper.BindPFlag("suffix", mirrorCmd.Flags().Lookup("suffix"))
+	if err != nil {
+		log.Fatal("error binding viper to 'suffix' flag:", err)
+	}
+
+	mirrorCmd.Flags().BoolVar(&sparse, "sparse", false, "turn blocks of zeros into holes in dst files")
+	err = viper.BindPFlag("sparse", mirrorCmd.Flags().Lookup("sparse"))
+	if err != nil {
+		log.Fatal("error binding viper to 'sparse' flag:", err)
+	}
+
+	mirrorCmd.Flags().StringVar(&reflink, "reflink", "never", "clone files instead of copying data, if the file system supports it: auto, always or never")
+	err = viper.BindPFlag("reflink", mirrorCmd.Flags().Lookup("reflink"))
+	if err != nil {
+		log.Fatal("error binding viper to 'reflink' flag:", err)
+	}
+
+	mirrorCmd.Flags().StringVar(&manifestName, "manifest", "", "update a checksum manifest with this name in dst after mirroring (.json, .csv or sha256sum format)")
+	err = viper.BindPFlag("manifest", mirrorCmd.Flags().Lookup("manifest"))
+	if err != nil {
+		log.Fatal("error binding viper to 'manifest' flag:", err)
+	}
+
+	mirrorCmd.Flags().BoolVar(&verifyCopies, "verify", false, "read back copied files and compare them to the source")
+	err = viper.BindPFlag("verify", mirrorCmd.Flags().Lookup("verify"))
+	if err != nil {
+		log.Fatal("error binding viper to 'verify' flag:", err)
+	}
+
+	mirrorCmd.Flags().DurationVar(&modifyWindow, "modify-window", 0, "treat modification times as equal if they differ by no more than this (0: detect from dst)")
+	err = viper.BindPFlag("modify-window", mirrorCmd.Flags().Lookup("modify-window"))
+	if err != nil {
+		log.Fatal("error binding viper to 'modify-window' flag:", err)
+	}
+
+	mirrorCmd.Flags().BoolVar(&ignoreDSTShift, "ignore-dst-shift", false, "also treat modification times as equal if they differ by exactly one hour (FAT and DST changes)")
+	err = viper.BindPFlag("ignore-dst-shift", mirrorCmd.Flags().Lookup("ignore-dst-shift"))
+	if err != nil {
+		log.Fatal("error binding viper to 'ignore-dst-shift' flag:", err)
+	}
+
+	mirrorCmd.Flags().BoolVar(&ignoreSpace, "ignore-space", false, "copy even if the free space on the destination seems insufficient")
+	err = viper.BindPFlag("ignore-space", mirrorCmd.Flags().Lookup("ignore-space"))
+	if err != nil {
+		log.Fatal("error binding viper to 'ignore-space' flag:", err)
+	}
+
 	mirrorCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output to the command line")
 	err = viper.BindPFlag("verbose", mirrorCmd.Flags().Lookup("verbose"))
 	if err != nil {
@@ -88,6 +225,9 @@ func init() {
 // ------------------------------------------------------------------------------------
 
 // Mirror mirrors directory 'src' to directory 'dst'.
+// If the package-level backupDir is set, files are moved there before they are overwritten or deleted.
+// If useTrash is set, deleted files are moved to the trash instead.
+// If hardLinks is set, files that are hard-linked in src are hard-linked in dst as well.
 func Mirror(src, dst string, dry, clean, skipHidden bool) error {
 	fmt.Println("~~~ MIRROR ~~~")
 	fmt.Printf("'%s' --> '%s'\n\n", src, dst)
@@ -96,6 +236,7 @@ func Mirror(src, dst string, dry, clean, skipHidden bool) error {
 
 	var nItems, nBytes uint
 	t0 := time.Now()
+	verifyFailed = nil
 
 	src, dst, err := pathlib.CheckSrcDst(src, dst)
 	if err != nil {
@@ -132,6 +273,77 @@ func Mirror(src, dst string, dry, clean, skipHidden bool) error {
 		}
 	}
 
+	cmpOpts := compareOptions(dry, filesetDst.Basepath)
+
+	var bk *backup.Backup
+	if backupDir != "" {
+		bk = backup.New(backupDir, backupSuffix, dst)
+		verboseprintf("backup of overwritten or deleted files to '%s'\n", bk.Dir)
+	}
+
+	var tr *trash.Trash
+	if useTrash && clean {
+		if trashDir != "" {
+			dir := trashDir
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(filesetDst.Basepath, dir)
+			}
+			tr = trash.Local(dir)
+		} else if tr, err = trash.For(filesetDst.Basepath); err != nil {
+			return err
+		}
+		verboseprintf("move deleted files to trash '%s'\n", tr.Dir)
+		expireTrash(tr, dry)
+	}
+
+	// the manifest is not in src, but must not be removed from dst
+	mf := isManifest(filesetDst.Basepath)
+
+	// step 0: move what was moved or renamed in src, instead of copying it again
+	if detectRenames && clean {
+		var verify func(srcPath, dstPath string) bool
+		if verifyRenames {
+			verify = func(srcPath, dstPath string) bool {
+				equal, err := compare.DeepEqual(srcPath, dstPath)
+				return err == nil && equal
+			}
+		}
+		renames, err := findRenames(src, filesetDst.Filter(func(name string) bool {
+			full := filepath.Join(filesetDst.Basepath, name)
+			return !bk.Contains(full) && !tr.Contains(full) && !(mf != nil && mf(name))
+		}), skipHidden, cmpOpts, verify)
+		if err != nil {
+			return err
+		}
+		for _, r := range renames {
+			fmt.Printf("move '%s' to '%s'\n", r.From, r.To)
+			if !dry {
+				to := filepath.Join(filesetDst.Basepath, r.To)
+				if err := copy.CreateDir(filepath.Dir(to), dry); err != nil {
+					return err
+				}
+				if err := os.Rename(filepath.Join(filesetDst.Basepath, r.From), to); err != nil {
+					return err
+				}
+			}
+			filesetDst.Move(r.From, r.To)
+		}
+	}
+
+	// src is scanned before copying, to check the free space on dst and to detect hard links
+	verboseprint("analyzing source...")
+	if err := filesetSrc.Populate(); err != nil {
+		return err
+	}
+	planned := filesetSrc.Filter(keepName(skipHidden))
+	if hardLinks {
+		planned.Links = filesetSrc.Links
+	}
+	need := space.Needed(planned, &filesetDst, cmpOpts.BasicUnequal, bk != nil)
+	if err := checkSpace(need, filesetDst.Basepath, func() (uint64, error) { return space.Free(filesetDst.Basepath) }, dry); err != nil {
+		return err
+	}
+
 	basepath := strings.TrimSuffix(filesetSrc.Basepath, string(os.PathSeparator))
 
 	// step 1: copy everything from source to dst if src newer
@@ -178,7 +390,29 @@ func Mirror(src, dst string, dry, clean, skipHidden bool) error {
 				return nil
 			}
 
-			// B) item is file.
+			// B) item is a hard link to a file that was handled before.
+			//   link to that file in dst, unless it already is.
+			if first, ok := filesetSrc.Links[childPath]; ok && hardLinks {
+				dstFirst := filepath.Join(dst, first)
+				if dstInfo, ok := filesetDst.Paths[childPath]; ok {
+					if firstInfo, err := os.Stat(dstFirst); err == nil && os.SameFile(dstInfo, firstInfo) {
+						verboseprintf("skip hard link '%s'\n", srcPath)
+						return nil
+					}
+					if err := bk.Save(dstPath, dry); err != nil {
+						return err
+					}
+				}
+				fmt.Printf("hard link '%s' to '%s'\n", srcPath, first)
+				err := copy.LinkFile(dstFirst, dstPath, dry)
+				if err == nil {
+					return nil
+				}
+				verboseprint("hard link failed, copy instead:", err)
+				return copyFile(srcPath, dstPath, srcInfo, dry)
+			}
+
+			// C) item is file.
 			//   exists in dst?
 			//     no  --> write.
 			//     yes --> overwrite?
@@ -188,13 +422,32 @@ func Mirror(src, dst string, dry, clean, skipHidden bool) error {
 			//         no  --> skip.
 			if !filesetDst.Contains(childPath) {
 				fmt.Printf("copy file '%s'\n", srcPath)
-				return copy.CopyFile(srcPath, dstPath, srcInfo, dry)
+				return copyFile(srcPath, dstPath, srcInfo, dry)
 			}
 
-			dstInfo, _ := os.Stat(filepath.Join(filesetDst.Basepath, childPath))
-			if compare.BasicUnequal(srcInfo, dstInfo) {
+			dstInfo := filesetDst.Paths[childPath]
+			if cmpOpts.BasicUnequal(srcInfo, dstInfo) {
 				fmt.Printf("overwrite file '%s'\n", srcPath)
-				return copy.CopyFile(srcPath, dstPath, srcInfo, dry)
+				if useDelta {
+					// the old file is the base of the delta copy, keep it
+					if err := bk.Copy(dstPath, dry); err != nil {
+						return err
+					}
+					return verified(srcPath, dry,
+						func() error { return copy.CopyFileDelta(srcPath, dstPath, srcInfo, dry) },
+						func() (bool, error) { return compare.DeepEqual(srcPath, dstPath) },
+					)
+				}
+				if err := bk.Save(dstPath, dry); err != nil {
+					return err
+				}
+				if hardLinks && !dry {
+					// dst might be hard-linked to another file; don't write through the link
+					if err := os.Remove(dstPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+						return err
+					}
+				}
+				return copyFile(srcPath, dstPath, srcInfo, dry)
 			} else {
 				verboseprintf("skip file '%s'\n", srcPath)
 			}
@@ -212,34 +465,112 @@ func Mirror(src, dst string, dry, clean, skipHidden bool) error {
 	// step 2: clean everything from dst that is not in src
 	if clean {
 		// for file in filesetDst: file exists in filesetSrc ? --> Delete if not.
-		for name, dstInfo := range filesetDst.Paths {
+		// Sorted, so that a directory is handled before its content.
+		var deletions []string
+		for name := range filesetDst.Paths {
+			if bk.Contains(filepath.Join(filesetDst.Basepath, name)) || tr.Contains(filepath.Join(filesetDst.Basepath, name)) {
+				continue
+			}
+
+			if mf != nil && mf(name) {
+				continue
+			}
 
 			if skipHidden && (strings.HasPrefix(name, ".") || strings.Contains(name, "/.")) {
 				verboseprintf("skip hidden '%s'\n", name)
-				return nil
+				continue
 			}
 
 			if strings.HasSuffix(name, "humbs.db") {
 				verboseprint("skip Windows Thumbs.db")
-				return nil
+				continue
 			}
 
 			if !filesetSrc.Contains(name) {
-				fmt.Printf("file / dir '%v' does not exist in src, delete\n", name)
-				err := copy.DeleteFileOrDir(filepath.Join(filesetDst.Basepath, name), dstInfo, dry)
-				if err != nil {
-					// this can sometimes give an error if the parent directory was deleted before...
-					verboseprint("deletion failed,", err)
+				deletions = append(deletions, name)
+			}
+		}
+		sort.Strings(deletions)
+
+		if err := checkDeletions(deletions, len(filesetSrc.Paths), len(filesetDst.Paths), dry); err != nil {
+			return err
+		}
+
+		for _, name := range deletions {
+			fmt.Printf("file / dir '%v' does not exist in src, delete\n", name)
+			if tr != nil {
+				if err := tr.Put(filepath.Join(filesetDst.Basepath, name), dry); err != nil {
+					return err
 				}
+				continue
+			}
+			if err := bk.Save(filepath.Join(filesetDst.Basepath, name), dry); err != nil {
+				return err
+			}
+			err := copy.DeleteFileOrDir(filepath.Join(filesetDst.Basepath, name), filesetDst.Paths[name], dry)
+			if err != nil {
+				// this can sometimes give an error if the parent directory was deleted before...
+				verboseprint("deletion failed,", err)
 			}
 		}
 	}
 
+	if manifestName != "" {
+		err := writeManifest(dst, func(name string) bool {
+			return bk.Contains(filepath.Join(filesetDst.Basepath, name))
+		}, dry)
+		if err != nil {
+			return err
+		}
+	}
+
 	dt := time.Since(t0)
 	verboseprintf("~~~ MIRROR done ~~~\n%v items (%v) in %v\n~~~\n",
 		nItems,
 		copy.ByteCount(nBytes),
 		dt,
 	)
+	return verifySummary()
+}
+
+// findRenames populates a fileset of 'src' and returns the entries of filesetDst that were
+// moved or renamed in src (see fileset.DetectRenames). Files are considered equal if size and
+// mtime match, according to opts; if verify is not nil, it must also confirm equal content.
+func findRenames(src string, filesetDst *fileset.Fileset, skipHidden bool, opts compare.Options, verify func(srcPath, dstPath string) bool) ([]fileset.Rename, error) {
+	filesetSrc, err := fileset.New(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := filesetSrc.Populate(); err != nil {
+		return nil, err
+	}
+	keep := keepName(skipHidden)
+	sameFile := func(srcInfo, dstInfo os.FileInfo) bool {
+		return srcInfo.Size() == dstInfo.Size() && opts.MtimeEqual(srcInfo.ModTime(), dstInfo.ModTime())
+	}
+	return fileset.DetectRenames(filesetSrc.Filter(keep), filesetDst.Filter(keep), sameFile, verify), nil
+}
+
+// checkDeletions applies the deletion safety limits (see package-level maxDelete etc.)
+// to the planned 'deletions', and asks for confirmation if stdin is a terminal.
+func checkDeletions(deletions []string, nSrc, nDst int, dry bool) error {
+	limits := safety.Limits{
+		MaxDelete:        maxDelete,
+		MaxDeletePercent: maxDeletePercent,
+		AllowEmptySrc:    allowEmptySrc,
+	}
+	if err := limits.Check(len(deletions), nSrc, nDst); err != nil {
+		return fmt.Errorf("abort before deleting anything: %v", err)
+	}
+	if dry || len(deletions) == 0 || !safety.IsTerminal(os.Stdin) {
+		return nil
+	}
+	ok, err := safety.Confirm(os.Stdin, os.Stdout, deletions)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return safety.ErrAborted
+	}
 	return nil
 }
@@ -93,3 +93,27 @@ func TestMirror(t *testing.T) {
 		t.Logf("clean hidden: want %v entries in dst, have %v", want, have)
 	}
 }
+
+func TestMirrorNoHardLinks(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "a"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(src, "a"), filepath.Join(src, "b")); err != nil {
+		t.Skip("hard links not supported:", err)
+	}
+
+	// without --hard-links, linked files are copied independently
+	if err := cmd.Mirror(src, dst, false, false, false); err != nil {
+		t.Fatal(err)
+	}
+	infoA, errA := os.Stat(filepath.Join(dst, "a"))
+	infoB, errB := os.Stat(filepath.Join(dst, "b"))
+	if errA != nil || errB != nil {
+		t.Fatalf("dst files missing: %v, %v", errA, errB)
+	}
+	if os.SameFile(infoA, infoB) {
+		t.Log("dst files must not be hard-linked without --hard-links")
+		t.Fail()
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright © 2023 Florian Obersteiner <f.obersteiner@posteo.de>
+
+License: see LICENSE in the root directory of the repo.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/FObersteiner/gosyncit/lib/libsftp"
+	"github.com/FObersteiner/gosyncit/lib/snapshot"
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune 'dir' ['remote-url' 'username']",
+	Short: "remove old snapshots from directory 'dir' according to retention rules",
+	Long: `Remove timestamped snapshot directories (see 'snapshot' command) that are not covered
+by any of the retention rules. The newest snapshot and the target of the 'latest' link
+are never removed.
+If 'remote-url' and 'username' are given, 'dir' is a directory on the SFTP server.
+Retention rules can also be set per job in the config file (keep-last, keep-daily, ...).`,
+	SilenceUsage: true,
+	Args:         cobra.RangeArgs(0, 3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sshFlags = cmd.Flags()
+		dir := viper.GetString("dst")
+		url := viper.GetString("remote-url")
+		usr := viper.GetString("username")
+		switch len(args) {
+		case 1:
+			dir = args[0]
+		case 3:
+			dir, url, usr = args[0], args[1], args[2]
+		case 2:
+			return errors.New("missing required argument 'username'")
+		}
+		if dir == "" {
+			return errors.New("missing required argument 'dir'")
+		}
+
+		r := snapshot.Retention{
+			Last:    viper.GetInt("keep-last"),
+			Daily:   viper.GetInt("keep-daily"),
+			Weekly:  viper.GetInt("keep-weekly"),
+			Monthly: viper.GetInt("keep-monthly"),
+		}
+		if r.IsZero() {
+			return errors.New("no retention rule given; refusing to remove all but the newest snapshot")
+		}
+
+		dry := viper.GetBool("dryrun")
+		setGlobalVerbose := viper.GetBool("verbose")
+		verbose = setGlobalVerbose
+
+		if url == "" {
+			return Prune(dir, r, dry)
+		}
+		creds := libsftp.Credentials{
+			Usr:       usr,
+			Host:      url,
+			AgentSock: "SSH_AUTH_SOCK",
+			Port:      viper.GetInt("port"),
+			SSH:       sshOptions(url),
+		}
+		return SftpPrune(dir, creds, r, dry)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+
+	pruneCmd.Flags().SortFlags = false
+
+	pruneCmd.Flags().IntVar(&keepLast, "keep-last", 0, "keep the last n snapshots")
+	err := viper.BindPFlag("keep-last", pruneCmd.Flags().Lookup("keep-last"))
+	if err != nil {
+		log.Fatal("error binding viper to 'keep-last' flag:", err)
+	}
+
+	pruneCmd.Flags().IntVar(&keepDaily, "keep-daily", 0, "keep one snapshot per day for n days")
+	err = viper.BindPFlag("keep-daily", pruneCmd.Flags().Lookup("keep-daily"))
+	if err != nil {
+		log.Fatal("error binding viper to 'keep-daily' flag:", err)
+	}
+
+	pruneCmd.Flags().IntVar(&keepWeekly, "keep-weekly", 0, "keep one snapshot per week for n weeks")
+	err = viper.BindPFlag("keep-weekly", pruneCmd.Flags().Lookup("keep-weekly"))
+	if err != nil {
+		log.Fatal("error binding viper to 'keep-weekly' flag:", err)
+	}
+
+	pruneCmd.Flags().IntVar(&keepMonthly, "keep-monthly", 0, "keep one snapshot per month for n months")
+	err = viper.BindPFlag("keep-monthly", pruneCmd.Flags().Lookup("keep-monthly"))
+	if err != nil {
+		log.Fatal("error binding viper to 'keep-monthly' flag:", err)
+	}
+
+	pruneCmd.Flags().IntVarP(&port, "port", "p", 22, "ssh port number")
+	err = viper.BindPFlag("port", pruneCmd.Flags().Lookup("port"))
+	if err != nil {
+		log.Fatal("error binding viper to 'port' flag:", err)
+	}
+
+	pruneCmd.Flags().BoolVarP(&dryRun, "dryrun", "n", false, "show what will be done")
+	err = viper.BindPFlag("dryrun", pruneCmd.Flags().Lookup("dryrun"))
+	if err != nil {
+		log.Fatal("error binding viper to 'dryrun' flag:", err)
+	}
+
+	addSSHFlags(pruneCmd.Flags())
+
+	pruneCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output to the command line")
+	err = viper.BindPFlag("verbose", pruneCmd.Flags().Lookup("verbose"))
+	if err != nil {
+		log.Fatal("error binding viper to 'verbose' flag:", err)
+	}
+}
+
+// ------------------------------------------------------------------------------------
+
+// Prune removes snapshots from local directory 'dir' that are not covered by retention rules r.
+func Prune(dir string, r snapshot.Retention, dry bool) error {
+	fmt.Println("~~~ PRUNE ~~~")
+	fmt.Printf("'%s'\n\n", dir)
+
+	snaps, err := snapshot.List(dir)
+	if err != nil {
+		return err
+	}
+	latest, err := snapshot.Latest(dir)
+	if err != nil {
+		return err
+	}
+	return prune(snaps, latest, r, dry, func(name string) error {
+		return os.RemoveAll(filepath.Join(dir, name))
+	})
+}
+
+// SftpPrune removes snapshots from directory 'dir' on an SFTP server that are not covered
+// by retention rules r.
+func SftpPrune(dir string, creds libsftp.Credentials, r snapshot.Retention, dry bool) error {
+	fmt.Println("~~~ SFTP PRUNE ~~~")
+	verboseprintf("%s\n", &creds)
+	fmt.Printf("'%s'\n\n", dir)
+
+	sshcon, err := libsftp.GetSSHconn(creds)
+	if err != nil {
+		return err
+	}
+	defer sshcon.Close()
+
+	sc, err := sftp.NewClient(sshcon)
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+	verboseprintf("SFTP connection established; %s\n", &creds)
+
+	entries, err := libsftp.ListDirsFiles(sc, dir)
+	if err != nil {
+		return err
+	}
+	latest := ""
+	if target, err := sc.ReadLink(path.Join(dir, snapshot.LatestName)); err == nil {
+		latest = path.Base(target)
+	}
+	return prune(snapshot.FromDirEntries(entries), latest, r, dry, func(name string) error {
+		return sc.RemoveAll(path.Join(dir, name))
+	})
+}
+
+// prune applies retention rules r to snaps and calls remove for each snapshot to delete.
+// Snapshot 'latest', the target of the 'latest' link, is always kept.
+func prune(snaps []snapshot.Snapshot, latest string, r snapshot.Retention, dry bool, remove func(name string) error) error {
+	t0 := time.Now()
+	verboseprintf("retention: %s\n", r)
+
+	keep, drop := r.Select(snaps, t0)
+	for i, s := range drop {
+		if s.Name == latest {
+			keep = append(keep, s)
+			drop = append(drop[:i], drop[i+1:]...)
+			break
+		}
+	}
+	for _, s := range keep {
+		verboseprintf("keep snapshot '%s'\n", s.Name)
+	}
+	for _, s := range drop {
+		fmt.Printf("remove snapshot '%s'\n", s.Name)
+		if dry {
+			continue
+		}
+		if err := remove(s.Name); err != nil {
+			return fmt.Errorf("failed to remove snapshot '%s': %v", s.Name, err)
+		}
+	}
+
+	dt := time.Since(t0)
+	fmt.Printf("\n~~~ PRUNE done ~~~\n%v snapshots kept, %v removed, in %v\n~~~\n",
+		len(keep),
+		len(drop),
+		dt,
+	)
+	return nil
+}
@@ -0,0 +1,76 @@
+package cmd_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FObersteiner/gosyncit/cmd"
+	"github.com/FObersteiner/gosyncit/lib/snapshot"
+)
+
+func TestPrune(t *testing.T) {
+	dir, err := os.MkdirTemp("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	for d := 0; d < 5; d++ {
+		name := snapshot.Name(now.AddDate(0, 0, -d))
+		if err := os.MkdirAll(filepath.Join(dir, name, "subdir"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := snapshot.Retention{Last: 2}
+
+	// dry run must not remove anything
+	if err := cmd.Prune(dir, r, true); err != nil {
+		t.Fatal(err)
+	}
+	snaps, _ := snapshot.List(dir)
+	if len(snaps) != 5 {
+		t.Fatalf("dry run: expected 5 snapshots, have %v", len(snaps))
+	}
+
+	if err := cmd.Prune(dir, r, false); err != nil {
+		t.Fatal(err)
+	}
+	snaps, _ = snapshot.List(dir)
+	if len(snaps) != 2 {
+		t.Fatalf("expected 2 snapshots, have %v", len(snaps))
+	}
+	if snaps[1].Name != snapshot.Name(now) {
+		t.Logf("newest snapshot must be kept, have %v", snaps)
+		t.Fail()
+	}
+}
+
+func TestPruneKeepsLatest(t *testing.T) {
+	dir := t.TempDir()
+
+	// a complete snapshot that 'latest' points to, and a newer incomplete one
+	now := time.Now()
+	complete, partial := snapshot.Name(now.Add(-time.Hour)), snapshot.Name(now)
+	for _, name := range []string{complete, partial} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := snapshot.SetLatest(dir, complete); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.Prune(dir, snapshot.Retention{Last: 1}, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{complete, partial} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Logf("snapshot '%s' must be kept: %v", name, err)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,344 @@
+/*
+Copyright © 2023 Florian Obersteiner <f.obersteiner@posteo.de>
+
+License: see LICENSE in the root directory of the repo.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"github.com/FObersteiner/gosyncit/lib/compare"
+	"github.com/FObersteiner/gosyncit/lib/copy"
+	"github.com/FObersteiner/gosyncit/lib/fileset"
+	"github.com/FObersteiner/gosyncit/lib/libsftp"
+	"github.com/FObersteiner/gosyncit/lib/sidecar"
+	"github.com/FObersteiner/gosyncit/lib/space"
+)
+
+// remoteEndpoint returns the credentials and the path of a remote directory given as
+// [user@]host:path; ok is false if 's' is a local path. The user defaults to the current user.
+func remoteEndpoint(s string, port int) (creds libsftp.Credentials, dir string, ok bool, err error) {
+	usr, host, dir, ok := libsftp.ParseURL(s)
+	if !ok {
+		return creds, "", false, nil
+	}
+	if usr == "" {
+		u, err := user.Current()
+		if err != nil {
+			return creds, "", true, err
+		}
+		usr = u.Username
+	}
+	creds = libsftp.Credentials{
+		Usr:       usr,
+		Host:      host,
+		AgentSock: "SSH_AUTH_SOCK",
+		Port:      port,
+		SSH:       sshOptions(host),
+	}
+	return creds, dir, true, nil
+}
+
+// relayUnsupported returns the first option that is set but cannot be used to mirror between
+// two SFTP servers, or ""
+func relayUnsupported() string {
+	switch {
+	case backupDir != "":
+		return "--backup-dir"
+	case useTrash:
+		return "--trash"
+	case useDelta:
+		return "--delta"
+	case detectRenames:
+		return "--detect-renames"
+	case hardLinks:
+		return "--hard-links"
+	case useAgent:
+		return "--agent"
+	}
+	return ""
+}
+
+// SftpRelay mirrors directory 'srcDir' on the SFTP server of 'src' to 'dstDir' on the server
+// of 'dst'. File content is streamed from one server to the other through this process;
+// nothing is stored on the local disk.
+func SftpRelay(src, dst libsftp.Credentials, srcDir, dstDir string, dry, ignorehidden, clean bool) error {
+	fmt.Println("~~~ SFTP MIRROR ~~~")
+	verboseprintf("src %s\ndst %s\n", &src, &dst)
+	fmt.Printf("'%s:%s' --> '%s:%s'\n\n", src.Host, srcDir, dst.Host, dstDir)
+
+	if opt := relayUnsupported(); opt != "" {
+		return fmt.Errorf("%s cannot be used to mirror between two SFTP servers", opt)
+	}
+
+	var nItems, nBytes uint
+	t0 := time.Now()
+	verifyFailed = nil
+
+	srcPool, err := libsftp.NewPool(src, sftpConnections, sftpClientOptions)
+	if err != nil {
+		return err
+	}
+	defer srcPool.Close()
+	dstPool, err := libsftp.NewPool(dst, sftpConnections, sftpClientOptions)
+	if err != nil {
+		return err
+	}
+	defer dstPool.Close()
+	srcSc, dstSc := srcPool.Clients[0], dstPool.Clients[0]
+	verboseprintf("%v SFTP connection(s) established to each server\n", len(srcPool.Clients))
+
+	filesetSrc, err := sftpFileset(srcSc, srcDir)
+	if err != nil {
+		verboseprint("src fileset population got error", err)
+		return err
+	}
+	filesetDst, err := sftpFileset(dstSc, dstDir)
+	if err != nil {
+		verboseprint("dst fileset population got error", err)
+		return err
+	}
+
+	// modification times recorded by earlier uploads to src, if it does not set them
+	srcStore, err := sidecar.Load(srcSc, filesetSrc.Basepath)
+	if err != nil {
+		return err
+	}
+	srcStore.Apply(filesetSrc)
+
+	var dstStore *sidecar.Store
+	if dry {
+		// no probe file in a dry run; modification times recorded by earlier runs apply
+		store, err := sidecar.Load(dstSc, filesetDst.Basepath)
+		if err != nil {
+			return err
+		}
+		if store.Len() > 0 {
+			dstStore = store
+			dstStore.Apply(filesetDst)
+		}
+	} else if err := libsftp.CheckSetstat(dstSc, filesetDst.Basepath); errors.Is(err, libsftp.ErrSetstat) {
+		if dstStore, err = sidecar.Load(dstSc, filesetDst.Basepath); err != nil {
+			return err
+		}
+		fmt.Printf("warning: dst server does not set modification times, record them in '%s'\n", dstStore.Path)
+		dstStore.Apply(filesetDst)
+	} else if err != nil {
+		verboseprint("could not check if the dst server sets modification times:", err)
+	}
+
+	// mtimes are carried over from src to dst, so no clock offset is needed (and src is not written to)
+	cmpOpts := compare.Options{Granularity: time.Second}
+	unequal := relayUnequal(srcPool, dstPool, cmpOpts, filesetSrc, filesetDst)
+
+	keep := keepName(ignorehidden)
+	planned := filesetSrc.Filter(func(name string) bool { return keep(name) && !sidecar.IsStore(name) })
+	need := space.Needed(planned, filesetDst, cmpOpts.BasicUnequal, false)
+	if err := checkSpace(need, filesetDst.Basepath, func() (uint64, error) { return space.FreeSftp(dstSc, filesetDst.Basepath) }, dry); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(planned.Paths))
+	for name := range planned.Paths {
+		names = append(names, name)
+	}
+	sort.Strings(names) // directories before their content
+
+	// each transfer uses a connection to src and one to dst
+	pairs := make(map[*sftp.Client]*sftp.Client, len(srcPool.Clients))
+	for i, sc := range srcPool.Clients {
+		pairs[sc] = dstPool.Clients[i]
+	}
+
+	// step 1: copy everything from src to dst if src newer (or size different)
+	xfer := newTransfers(srcPool.Clients)
+	for _, name := range names {
+		srcInfo := planned.Paths[name]
+		srcPath := path.Join(filesetSrc.Basepath, filepath.ToSlash(name))
+		dstPath := path.Join(filesetDst.Basepath, filepath.ToSlash(name))
+		nItems++
+		nBytes += uint(srcInfo.Size())
+
+		if srcInfo.IsDir() {
+			if dstInfo, ok := filesetDst.Paths[name]; !ok || !dstInfo.IsDir() {
+				verboseprintf("create dir '%s'\n", dstPath)
+				if !dry {
+					if err = dstSc.MkdirAll(dstPath); err != nil {
+						break
+					}
+				}
+			}
+			continue
+		}
+		if !srcInfo.Mode().IsRegular() {
+			verboseprintf("skip non-regular file '%s'\n", srcPath)
+			continue
+		}
+
+		if dstInfo, ok := filesetDst.Paths[name]; !ok {
+			fmt.Printf("copy file '%s'\n", srcPath)
+		} else {
+			var differs bool
+			if differs, err = unequal(srcPath, dstPath, srcInfo, dstInfo); err != nil {
+				break
+			}
+			if !differs {
+				verboseprintf("skip file '%s'\n", srcPath)
+				continue
+			}
+			fmt.Printf("overwrite file '%s'\n", srcPath)
+		}
+		if dry {
+			continue
+		}
+		name := name
+		err = xfer.run(func(sc *sftp.Client) error {
+			return relayFile(sc, pairs[sc], srcPath, dstPath, name, srcInfo, srcStore.Len() > 0, dstStore)
+		})
+		if err != nil {
+			break
+		}
+	}
+
+	if errXfer := xfer.close(); err == nil {
+		err = errXfer
+	}
+	if err != nil {
+		return err
+	}
+
+	// step 2: clean everything from dst that is not in src
+	if clean {
+		var deletions []string
+		for name := range filesetDst.Paths {
+			if sidecar.IsStore(name) {
+				continue
+			}
+			if ignorehidden && (strings.HasPrefix(name, ".") || strings.Contains(name, "/.")) {
+				verboseprintf("skip hidden '%s'\n", name)
+				continue
+			}
+			if !filesetSrc.Contains(name) {
+				deletions = append(deletions, name)
+			}
+		}
+		// a remote directory must be empty to be removed, so handle its content first
+		sort.Sort(sort.Reverse(sort.StringSlice(deletions)))
+
+		if err := checkDeletions(deletions, len(filesetSrc.Paths), len(filesetDst.Paths), dry); err != nil {
+			return err
+		}
+		for _, name := range deletions {
+			fmt.Printf("file/dir '%v' does not exist in src, delete\n", name)
+			if dry {
+				continue
+			}
+			dstPath := path.Join(filesetDst.Basepath, filepath.ToSlash(name))
+			if filesetDst.Paths[name].IsDir() {
+				err = dstSc.RemoveDirectory(dstPath)
+			} else {
+				err = libsftp.DeleteFile(dstSc, dstPath, false)
+			}
+			if err != nil {
+				verboseprint("deletion failed,", err)
+			}
+		}
+	}
+
+	if !dry {
+		if err := dstStore.Save(dstSc); err != nil {
+			return fmt.Errorf("failed to save modification times: %v", err)
+		}
+	}
+
+	dt := time.Since(t0)
+	verboseprintf("~~~ SFTP MIRROR done ~~~\n%v items (%v) in %v\n~~~\n",
+		nItems,
+		copy.ByteCount(nBytes),
+		dt,
+	)
+
+	return verifySummary()
+}
+
+// sftpFileset populates the fileset of directory 'dir' on the SFTP server of 'sc'
+func sftpFileset(sc *sftp.Client, dir string) (*fileset.Fileset, error) {
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	set := &fileset.Fileset{
+		Basepath: dir,
+		Paths:    make(map[string]fs.FileInfo),
+	}
+	return set, set.SftpPopulate(sc)
+}
+
+// relayUnequal returns a function that reports if a file on src must be copied to dst, like
+// sftpUnequal. With the checksum option, files of equal size are hashed on both servers if both
+// can run the same checksum program, otherwise they are read via SFTP.
+func relayUnequal(srcPool, dstPool *libsftp.Pool, opts compare.Options, srcSet, dstSet *fileset.Fileset) func(src, dst string, srcInfo, dstInfo os.FileInfo) (bool, error) {
+	srcHasher, dstHasher := newSftpHasher(srcPool), newSftpHasher(dstPool)
+	if srcHasher != nil && srcHasher.Algo() != dstHasher.Algo() {
+		fmt.Printf("warning: src hashes with %s, dst with %s; files are read to compare them\n", srcHasher.Algo(), dstHasher.Algo())
+		srcHasher, dstHasher = nil, nil
+	}
+	wantHashes(srcHasher, srcSet, dstSet)
+	wantHashes(dstHasher, dstSet, srcSet)
+	return func(src, dst string, srcInfo, dstInfo os.FileInfo) (bool, error) {
+		if !checksum || srcInfo.Size() != dstInfo.Size() {
+			return opts.BasicUnequal(srcInfo, dstInfo), nil
+		}
+		if srcHasher == nil {
+			equal, err := libsftp.RelayEqual(srcPool.Clients[0], dstPool.Clients[0], src, dst)
+			return !equal, err
+		}
+		hs, err := srcHasher.Hash(src)
+		if err != nil {
+			return false, err
+		}
+		hd, err := dstHasher.Hash(dst)
+		if err != nil {
+			return false, err
+		}
+		return hs != hd, nil
+	}
+}
+
+// relayFile copies 'srcPath' on the src server to 'dstPath' on the dst server, verified if the
+// verify option is set. If 'setMtime' is set, the modification time is set from 'srcInfo',
+// which was recorded on src. If dstStore is not nil, it records the modification time for 'name'.
+func relayFile(src, dst *sftp.Client, srcPath, dstPath, name string, srcInfo os.FileInfo, setMtime bool, dstStore *sidecar.Store) error {
+	return verified(srcPath, false,
+		func() error {
+			n, err := libsftp.RelayFile(src, dst, srcPath, dstPath, sftpPreserve)
+			if err == nil && setMtime {
+				err = libsftp.SetAttrs(dst, dstPath, srcInfo, sftpPreserve)
+			}
+			verboseprintf("%v relayed for '%s'\n", copy.ByteCount(uint(n)), srcPath)
+			if dstStore == nil || (err != nil && !errors.Is(err, libsftp.ErrSetstat)) {
+				return err
+			}
+			dstInfo, err := dst.Stat(dstPath)
+			if err != nil {
+				return err
+			}
+			mtimeStoreMu.Lock()
+			dstStore.Set(name, srcInfo.ModTime(), dstInfo)
+			mtimeStoreMu.Unlock()
+			return nil
+		},
+		func() (bool, error) { return libsftp.RelayEqual(src, dst, srcPath, dstPath) },
+	)
+}
@@ -6,11 +6,17 @@ License: see LICENSE in the root directory of the repo.
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"github.com/FObersteiner/gosyncit/lib/compare"
+	"github.com/FObersteiner/gosyncit/lib/copy"
+	"github.com/FObersteiner/gosyncit/lib/hashcache"
 )
 
 var (
@@ -20,9 +26,61 @@ var (
 	dryRun     bool       // global option
 	noCleanDst bool       // option for copy and mirror
 	skipHidden bool       // option for mirror and sync
+	checksum   bool       // option for snapshot
+	useDelta   bool       // option for mirror and sftpmirror
+	hardLinks  bool       // option for mirror and sftpmirror
+	sparse     bool       // option for mirror, sync and snapshot
+	reflink    string     // option for mirror, sync and snapshot
+	// check copied files against their source; mirror, sync, snapshot and sftpmirror
+	verifyCopies bool
+	// checksum manifests; manifest, mirror and sync
+	manifestName   string
+	manifestOutput string
+	manifestFormat string
+	// scrub
+	repairFrom     string
+	updateManifest bool
+	// abort if the destination has not enough free space; mirror, sync, snapshot and sftpmirror
+	ignoreSpace bool
+	// modification time tolerance; mirror, sync, snapshot and diff
+	modifyWindow   time.Duration
+	ignoreDSTShift bool
+	// persistent hash cache; snapshot and diff
+	useHashCache   bool
+	hashCacheXattr bool
+	// move detection; mirror and sftpmirror
+	detectRenames bool
+	verifyRenames bool
+	// backup of overwritten / deleted files; mirror and sftpmirror
+	backupDir    string
+	backupSuffix string
+	// deletion safety limits; mirror and sftpmirror
+	maxDelete        int
+	maxDeletePercent float64
+	allowEmptySrc    bool
+	// trash for deleted files; mirror and sftpmirror
+	useTrash    bool
+	trashDir    string
+	trashMaxAge time.Duration
+	// snapshot retention; prune
+	keepLast    int
+	keepDaily   int
+	keepWeekly  int
+	keepMonthly int
 	// SFTP-specific
+	remoteURL        string // trash
+	username         string // trash
 	port             int
 	reverseDirection bool
+	clockSkewWarn    time.Duration
+	preserveAtimes   bool
+	preservePerms    bool
+	sftpConnections  int
+	// SFTP server; serve
+	listenAddr     string
+	authorizedKeys string
+	hostKey        string
+	readOnly       bool
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -42,12 +100,35 @@ Made with cobra CLI library for Go.`,
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	err := rootCmd.Execute()
+	var exit *exitError
+	if errors.As(err, &exit) {
+		if exit.err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", exit.err)
+		}
+		os.Exit(exit.code)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "There was an error while executing the CLI : '%s'\n", err)
 		os.Exit(1)
 	}
 }
 
+// exitError makes Execute exit with status 'code'. The error, if any, is printed by Execute;
+// commands that return an exitError must set SilenceErrors.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit status %d", e.code)
+	}
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error { return e.err }
+
 func init() {
 	cobra.OnInitialize(initConfig)
 
@@ -86,6 +167,100 @@ func initConfig() {
 	}
 }
 
+// copyOpts are the options for local file copies, see setCopyOptions
+var copyOpts copy.Options
+
+// setCopyOptions sets copyOpts from the 'sparse' and 'reflink' options
+func setCopyOptions() error {
+	r, err := copy.ParseReflink(viper.GetString("reflink"))
+	if err != nil {
+		return err
+	}
+	copyOpts = copy.Options{Sparse: viper.GetBool("sparse"), Reflink: r}
+	return nil
+}
+
+// compareOptions returns the options to compare modification times of files copied to 'dirs',
+// from the 'modify-window' and 'ignore-dst-shift' options. Without a modify window, the timestamp
+// resolution of the file systems of 'dirs' is probed, and the coarsest is used as window if it is
+// coarser than compare.TimeGranularity, e.g. 2s on FAT. The probe writes a file, so it is
+// skipped if dry is true.
+func compareOptions(dry bool, dirs ...string) compare.Options {
+	opts := compare.DefaultOptions()
+	opts.Window = viper.GetDuration("modify-window")
+	opts.DST = viper.GetBool("ignore-dst-shift")
+	if opts.Window > 0 {
+		return opts
+	}
+	if dry {
+		verboseprint("dry run, timestamp resolution not probed; set --modify-window for coarse file systems")
+		return opts
+	}
+	for _, dir := range dirs {
+		res, err := compare.ProbeResolution(dir)
+		if err != nil {
+			verboseprintf("could not probe timestamp resolution of '%s': %v\n", dir, err)
+			continue
+		}
+		if res > opts.Granularity && res > opts.Window {
+			verboseprintf("'%s' has a timestamp resolution of %v, using it as modify window\n", dir, res)
+			opts.Window = res
+		}
+	}
+	return opts
+}
+
+// hashCache is used for content comparisons of local files, if the 'hash-cache' option is set
+var hashCache *hashcache.Cache
+
+// openHashCache opens the hash cache in its default location if the 'hash-cache' option is set,
+// using extended attributes if the 'xattr' option is set. Call closeHashCache when done.
+func openHashCache() error {
+	hashCache = nil
+	if !viper.GetBool("hash-cache") {
+		return nil
+	}
+	path, err := hashcache.DefaultPath()
+	if err != nil {
+		return err
+	}
+	c, err := hashcache.Open(path)
+	if err != nil {
+		return err
+	}
+	c.Xattr = viper.GetBool("xattr")
+	hashCache = c
+	verboseprintf("using hash cache '%s' (%v entries)\n", c.Path, c.Len())
+	return nil
+}
+
+// closeHashCache saves the hash cache, if it is open
+func closeHashCache() {
+	if hashCache == nil {
+		return
+	}
+	if err := hashCache.Save(); err != nil {
+		fmt.Println("failed to save hash cache:", err)
+	}
+	hashCache = nil
+}
+
+// contentEqual compares the content of two local files, by hashes from the hash cache if it is open
+func contentEqual(a, b string) (bool, error) {
+	if hashCache != nil {
+		return compare.HashEqual(a, b, hashCache)
+	}
+	return compare.DeepEqual(a, b)
+}
+
+// copyFile copies a local file, see copy.CopyFileWith; verified if the verify option is set.
+func copyFile(src, dst string, srcInfo os.FileInfo, dry bool) error {
+	return verified(src, dry,
+		func() error { return copy.CopyFileWith(src, dst, srcInfo, dry, copyOpts) },
+		func() (bool, error) { return compare.DeepEqual(src, dst) },
+	)
+}
+
 func verboseprint(a ...any) {
 	if verbose {
 		fmt.Println(a...)
@@ -0,0 +1,306 @@
+/*
+Copyright © 2023 Florian Obersteiner <f.obersteiner@posteo.de>
+
+License: see LICENSE in the root directory of the repo.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/FObersteiner/gosyncit/lib/copy"
+	"github.com/FObersteiner/gosyncit/lib/fileset"
+	"github.com/FObersteiner/gosyncit/lib/hashcache"
+	"github.com/FObersteiner/gosyncit/lib/manifest"
+	"github.com/FObersteiner/gosyncit/lib/pathlib"
+)
+
+var scrubCmd = &cobra.Command{
+	Use:   "scrub 'dir' ['manifest']",
+	Short: "re-hash the files in directory 'dir' to detect silent corruption",
+	Long: `Re-hash the files listed in the manifest (see 'manifest create' or 'mirror --manifest'),
+or with --hash-cache, the files that have hashes in the hash cache (see 'cache'),
+and compare them to the recorded hashes. A file with changed size or mtime was modified;
+a file with unchanged size and mtime but a different hash is corrupted. Manifests in
+sha256sum format record no size and mtime; a different hash is then reported as changed,
+and counts as a failure unless --update records the new hash.
+With --repair-from, corrupted and missing files are restored from a mirror copy,
+if the hash of the copy matches the recorded one.`,
+	SilenceUsage: true,
+	Args:         cobra.RangeArgs(1, 2),
+	RunE: func(_ *cobra.Command, args []string) error {
+		dry := viper.GetBool("dryrun")
+		update := viper.GetBool("update")
+		mirror := viper.GetString("repair-from")
+		setGlobalVerbose := viper.GetBool("verbose")
+		verbose = setGlobalVerbose
+
+		var mf string
+		if len(args) == 2 {
+			mf = args[1]
+		}
+		if (mf == "") == !viper.GetBool("hash-cache") {
+			return errors.New("specify either a manifest or --hash-cache")
+		}
+		if err := openHashCache(); err != nil {
+			return err
+		}
+		ok, err := Scrub(args[0], mf, mirror, update, dry)
+		if !dry {
+			closeHashCache()
+		}
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("found corrupted, missing or changed files")
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scrubCmd)
+
+	scrubCmd.Flags().SortFlags = false
+
+	scrubCmd.Flags().StringVar(&repairFrom, "repair-from", "", "restore corrupted or missing files from this mirror copy")
+	err := viper.BindPFlag("repair-from", scrubCmd.Flags().Lookup("repair-from"))
+	if err != nil {
+		log.Fatal("error binding viper to 'repair-from' flag:", err)
+	}
+
+	scrubCmd.Flags().BoolVar(&useHashCache, "hash-cache", false, "check against the hashes in the hash cache instead of a manifest")
+	err = viper.BindPFlag("hash-cache", scrubCmd.Flags().Lookup("hash-cache"))
+	if err != nil {
+		log.Fatal("error binding viper to 'hash-cache' flag:", err)
+	}
+
+	scrubCmd.Flags().BoolVar(&hashCacheXattr, "xattr", false, "with --hash-cache, also use the hashes in extended attributes of the files")
+	err = viper.BindPFlag("xattr", scrubCmd.Flags().Lookup("xattr"))
+	if err != nil {
+		log.Fatal("error binding viper to 'xattr' flag:", err)
+	}
+
+	scrubCmd.Flags().BoolVar(&updateManifest, "update", false, "record the new hashes of modified files in the manifest or hash cache")
+	err = viper.BindPFlag("update", scrubCmd.Flags().Lookup("update"))
+	if err != nil {
+		log.Fatal("error binding viper to 'update' flag:", err)
+	}
+
+	scrubCmd.Flags().BoolVarP(&dryRun, "dryrun", "n", false, "show what will be done")
+	err = viper.BindPFlag("dryrun", scrubCmd.Flags().Lookup("dryrun"))
+	if err != nil {
+		log.Fatal("error binding viper to 'dryrun' flag:", err)
+	}
+
+	scrubCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output to the command line")
+	err = viper.BindPFlag("verbose", scrubCmd.Flags().Lookup("verbose"))
+	if err != nil {
+		log.Fatal("error binding viper to 'verbose' flag:", err)
+	}
+}
+
+// ------------------------------------------------------------------------------------
+
+// Scrub checks the files in directory 'dir' against manifest file 'mf' (see manifest.Scrub),
+// or against hashCache if 'mf' is empty (see scrubCache).
+// If 'mirror' is not empty, corrupted and missing files are restored from there.
+// If update is true, new hashes of modified files are written to the manifest or hash cache.
+// Returns true if no corrupted or missing files remain, and no changed ones unless update is true.
+func Scrub(dir, mf, mirror string, update, dry bool) (bool, error) {
+	fmt.Println("~~~ SCRUB ~~~")
+	t0 := time.Now()
+
+	dir, err := pathlib.CheckDirPath(dir)
+	if err != nil {
+		return false, err
+	}
+	set, err := fileset.New(dir)
+	if err != nil {
+		return false, err
+	}
+	if err := set.Populate(); err != nil {
+		return false, err
+	}
+
+	var entries []manifest.Entry
+	var findings []manifest.Finding
+	if mf != "" {
+		if entries, err = manifest.ReadFile(mf); err != nil {
+			return false, err
+		}
+		findings, err = manifest.Scrub(set, entries)
+	} else {
+		if hashCache == nil {
+			return false, errors.New("no manifest and no hash cache")
+		}
+		findings, err = scrubCache(hashCache, set)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	count := make(map[string]int)
+	changed := false
+	for i, f := range findings {
+		target := filepath.Join(set.Basepath, filepath.FromSlash(f.Path))
+		switch f.Status {
+		case manifest.StatusOk:
+			verboseprintf("ok '%s'\n", f.Path)
+		case manifest.StatusModified, manifest.StatusChanged:
+			fmt.Printf("%s '%s'\n", f.Status, f.Path)
+			if update && mf == "" {
+				if !dry {
+					if _, err := hashCache.Hash(target, set.Paths[filepath.FromSlash(f.Path)]); err != nil {
+						return false, err
+					}
+				}
+			} else if update {
+				e, err := manifestEntry(set.Basepath, f.Path)
+				if err != nil {
+					return false, err
+				}
+				entries[i] = e
+				changed = true
+			}
+		case manifest.StatusCorrupted, manifest.StatusMissing:
+			fmt.Printf("%s '%s'\n", f.Status, f.Path)
+			if mirror != "" {
+				repaired, err := repair(f.Entry, filepath.Join(mirror, filepath.FromSlash(f.Path)), target, dry)
+				if err != nil {
+					return false, err
+				}
+				if repaired {
+					fmt.Printf("repaired '%s' from '%s'\n", f.Path, mirror)
+					f.Status = "repaired"
+				}
+			}
+		}
+		count[f.Status]++
+	}
+
+	if changed && !dry {
+		if err := manifest.WriteFile(mf, entries); err != nil {
+			return false, err
+		}
+		fmt.Printf("updated manifest '%s'\n", mf)
+	}
+
+	fmt.Printf("\n~~~ SCRUB done ~~~\n%v ok, %v modified, %v changed, %v corrupted, %v missing, %v repaired, in %v\n~~~\n",
+		count[manifest.StatusOk],
+		count[manifest.StatusModified],
+		count[manifest.StatusChanged],
+		count[manifest.StatusCorrupted],
+		count[manifest.StatusMissing],
+		count["repaired"],
+		time.Since(t0),
+	)
+	return count[manifest.StatusCorrupted] == 0 && count[manifest.StatusMissing] == 0 &&
+		(update || count[manifest.StatusChanged] == 0), nil
+}
+
+// scrubCache works like manifest.Scrub for the hashes recorded in hash cache 'c': the files
+// below the basepath of 'set' that have an entry for their current version are re-hashed and
+// compared to it. Files only recorded in other versions were modified. Other files are ignored.
+func scrubCache(c *hashcache.Cache, set *fileset.Fileset) ([]manifest.Finding, error) {
+	files, err := c.Files(set.Basepath)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	findings := make([]manifest.Finding, 0, len(paths))
+	for _, path := range paths {
+		rel, err := filepath.Rel(set.Basepath, path)
+		if err != nil {
+			return findings, err
+		}
+		f := manifest.Finding{
+			Entry:  manifest.Entry{Path: filepath.ToSlash(rel), Size: -1, Sha256: files[path]},
+			Status: manifest.StatusOk,
+		}
+		info, ok := set.Paths[rel]
+		if !ok || !info.Mode().IsRegular() {
+			f.Status = manifest.StatusMissing
+			findings = append(findings, f)
+			continue
+		}
+		sum, ok := c.Recorded(path, info)
+		if !ok {
+			f.Status = manifest.StatusModified
+			findings = append(findings, f)
+			continue
+		}
+		f.Size, f.Mtime, f.Sha256 = info.Size(), info.ModTime(), sum
+		h, err := manifest.HashFile(path)
+		if err != nil {
+			return findings, err
+		}
+		if h != sum {
+			f.Status = manifest.StatusCorrupted
+		}
+		findings = append(findings, f)
+	}
+	return findings, nil
+}
+
+// manifestEntry hashes file 'name' in directory 'basepath'
+func manifestEntry(basepath, name string) (manifest.Entry, error) {
+	path := filepath.Join(basepath, filepath.FromSlash(name))
+	info, err := os.Stat(path)
+	if err != nil {
+		return manifest.Entry{}, err
+	}
+	h, err := manifest.HashFile(path)
+	if err != nil {
+		return manifest.Entry{}, err
+	}
+	return manifest.Entry{Path: name, Size: info.Size(), Mtime: info.ModTime().UTC(), Sha256: h}, nil
+}
+
+// repair 'target' by copying 'source' over it, if the hash of source matches the manifest entry.
+// Restores the recorded mtime. Returns false if source does not match.
+func repair(e manifest.Entry, source, target string, dry bool) (bool, error) {
+	info, err := os.Stat(source)
+	if err != nil || !info.Mode().IsRegular() {
+		verboseprintf("no mirror copy of '%s'\n", e.Path)
+		return false, nil
+	}
+	h, err := manifest.HashFile(source)
+	if err != nil {
+		return false, err
+	}
+	if h != e.Sha256 {
+		verboseprintf("mirror copy of '%s' does not match the manifest either\n", e.Path)
+		return false, nil
+	}
+	if dry {
+		return true, nil
+	}
+	if err := copy.CreateDir(filepath.Dir(target), dry); err != nil {
+		return false, err
+	}
+	if err := copy.CopyFile(source, target, info, dry); err != nil {
+		return false, err
+	}
+	if !e.Mtime.IsZero() {
+		if err := os.Chtimes(target, e.Mtime, e.Mtime); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,93 @@
+package cmd_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FObersteiner/gosyncit/cmd"
+)
+
+func TestScrub(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mirror, err := os.MkdirTemp("", "mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(mirror)
+
+	mtime := time.Date(2006, time.March, 1, 3, 4, 5, 0, time.UTC)
+	write := func(path, content string, mtime time.Time) {
+		_ = os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, d := range []string{dir, mirror} {
+		write(filepath.Join(d, "sub", "file"), "content", mtime)
+		write(filepath.Join(d, "other"), "other content", mtime)
+	}
+
+	mf := filepath.Join(mirror, "MANIFEST.json")
+	if err := cmd.ManifestCreate(dir, mf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := cmd.Scrub(dir, mf, "", false, false)
+	if err != nil || !ok {
+		t.Fatalf("scrub of unchanged dir failed: %v, %v", ok, err)
+	}
+
+	// a modification is no error
+	write(filepath.Join(dir, "other"), "modified content", mtime.Add(time.Hour))
+	ok, err = cmd.Scrub(dir, mf, "", false, false)
+	if err != nil || !ok {
+		t.Fatalf("scrub of modified dir failed: %v, %v", ok, err)
+	}
+
+	// bit rot: same size and mtime
+	corrupted := filepath.Join(dir, "sub", "file")
+	write(corrupted, "cOntent", mtime)
+	ok, err = cmd.Scrub(dir, mf, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("scrub must detect corrupted file")
+	}
+
+	// repair from mirror
+	ok, err = cmd.Scrub(dir, mf, mirror, false, false)
+	if err != nil || !ok {
+		t.Fatalf("scrub with repair failed: %v, %v", ok, err)
+	}
+	if b, _ := os.ReadFile(corrupted); string(b) != "content" {
+		t.Logf("file not repaired, content is '%s'", b)
+		t.Fail()
+	}
+
+	// a sha256sum manifest has no size and mtime; a different hash fails unless updated
+	sums := filepath.Join(mirror, "SHA256SUMS")
+	if err := cmd.ManifestCreate(dir, sums, ""); err != nil {
+		t.Fatal(err)
+	}
+	write(corrupted, "cOntent", mtime)
+	if ok, err := cmd.Scrub(dir, sums, "", false, false); err != nil || ok {
+		t.Fatalf("scrub must fail for changed file: %v, %v", ok, err)
+	}
+	if ok, err := cmd.Scrub(dir, sums, "", true, false); err != nil || !ok {
+		t.Fatalf("scrub with update failed: %v, %v", ok, err)
+	}
+	if ok, err := cmd.Scrub(dir, sums, "", false, false); err != nil || !ok {
+		t.Fatalf("scrub after update failed: %v, %v", ok, err)
+	}
+}
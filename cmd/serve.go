@@ -0,0 +1,137 @@
+/*
+Copyright © 2023 Florian Obersteiner <f.obersteiner@posteo.de>
+
+License: see LICENSE in the root directory of the repo.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/FObersteiner/gosyncit/lib/sftpd"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve 'root'",
+	Short: "run an SFTP server that exposes directory 'root'",
+	Long: `Run an SFTP server, so that gosyncit (or any SFTP client) can transfer files to and from
+a machine without an SSH server. Clients see 'root' as '/' and cannot access anything outside
+of it. They log in with a public key listed in the authorized keys file; the user name is ignored.
+A host key is generated on the first start. Add the printed line to the known_hosts file of the
+clients (prefix the host with the port as '[host]:port' if it is not 22).`,
+	SilenceUsage: true,
+	Args:         cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		verbose = viper.GetBool("verbose")
+		listenAddr = viper.GetString("listen")
+		authorizedKeys = viper.GetString("authorized-keys")
+		hostKey = viper.GetString("host-key")
+		readOnly = viper.GetBool("read-only")
+		return Serve(args[0], listenAddr, authorizedKeys, hostKey, readOnly)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().SortFlags = false
+
+	serveCmd.Flags().StringVarP(&listenAddr, "listen", "l", ":2022", "address to listen on")
+	err := viper.BindPFlag("listen", serveCmd.Flags().Lookup("listen"))
+	if err != nil {
+		log.Fatal("error binding viper to 'listen' flag:", err)
+	}
+
+	serveCmd.Flags().StringVar(&authorizedKeys, "authorized-keys", "", "file with the public keys of the clients (default: ~/.ssh/authorized_keys)")
+	err = viper.BindPFlag("authorized-keys", serveCmd.Flags().Lookup("authorized-keys"))
+	if err != nil {
+		log.Fatal("error binding viper to 'authorized-keys' flag:", err)
+	}
+
+	serveCmd.Flags().StringVar(&hostKey, "host-key", "", "private host key file, created if it does not exist (default: in the user config directory)")
+	err = viper.BindPFlag("host-key", serveCmd.Flags().Lookup("host-key"))
+	if err != nil {
+		log.Fatal("error binding viper to 'host-key' flag:", err)
+	}
+
+	serveCmd.Flags().BoolVar(&readOnly, "read-only", false, "reject all changes to 'root'")
+	err = viper.BindPFlag("read-only", serveCmd.Flags().Lookup("read-only"))
+	if err != nil {
+		log.Fatal("error binding viper to 'read-only' flag:", err)
+	}
+
+	serveCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output to the command line")
+	err = viper.BindPFlag("verbose", serveCmd.Flags().Lookup("verbose"))
+	if err != nil {
+		log.Fatal("error binding viper to 'verbose' flag:", err)
+	}
+}
+
+// ------------------------------------------------------------------------------------
+
+// Serve runs an SFTP server for directory 'root' on address 'addr' until it is killed.
+// Empty key file paths select the defaults.
+func Serve(root, addr, authorizedKeys, hostKey string, readOnly bool) error {
+	fmt.Println("~~~ SFTP SERVER ~~~")
+
+	if authorizedKeys == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		authorizedKeys = filepath.Join(home, ".ssh", "authorized_keys")
+	}
+	keys, skipped, err := sftpd.LoadAuthorizedKeys(authorizedKeys)
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		fmt.Printf("warning: %v key(s) with options in '%s' are not accepted, options cannot be enforced\n", skipped, authorizedKeys)
+	}
+	verboseprintf("%v authorized keys from '%s'\n", len(keys), authorizedKeys)
+
+	if hostKey == "" {
+		if hostKey, err = sftpd.DefaultHostKeyPath(); err != nil {
+			return err
+		}
+	}
+	signer, err := sftpd.LoadHostKey(hostKey)
+	if err != nil {
+		return err
+	}
+
+	s, err := sftpd.New(sftpd.Config{
+		Root:           root,
+		ReadOnly:       readOnly,
+		AuthorizedKeys: keys,
+		HostKey:        signer,
+		Logf:           verboseprintf,
+	})
+	if err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	mode := "read-write"
+	if readOnly {
+		mode = "read-only"
+	}
+	fmt.Printf("serving '%s' (%s) on %v\n", root, mode, l.Addr())
+	fmt.Printf("host key %s, for known_hosts:\n", ssh.FingerprintSHA256(signer.PublicKey()))
+	fmt.Printf("<host> %s\n", strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))))
+	return s.Serve(l)
+}
@@ -11,36 +11,47 @@ import (
 	"io/fs"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/sftp"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/FObersteiner/gosyncit/lib/backup"
 	"github.com/FObersteiner/gosyncit/lib/compare"
 	"github.com/FObersteiner/gosyncit/lib/copy"
+	"github.com/FObersteiner/gosyncit/lib/delta"
 	"github.com/FObersteiner/gosyncit/lib/fileset"
 	"github.com/FObersteiner/gosyncit/lib/libsftp"
+	"github.com/FObersteiner/gosyncit/lib/sidecar"
+	"github.com/FObersteiner/gosyncit/lib/space"
+	"github.com/FObersteiner/gosyncit/lib/trash"
 )
 
 // sftpmirrorCmd represents the sftpsync command
 var sftpmirrorCmd = &cobra.Command{
-	Use:     "sftpmirror 'local-path' 'remote-path' 'remote-url' 'username'",
+	Use:     "sftpmirror 'local-path' 'remote-path' 'remote-url' 'username' | 'src' 'dst'",
 	Aliases: []string{"smir"},
 	Short:   "mirrors directories via SFTP",
 	Long: `the direction can either be "local --> remote" or "remote --> local".
-  "local" in this context means local file system, remote means file system of the sftp server.`,
+  "local" in this context means local file system, remote means file system of the sftp server.
+With two arguments 'src' and 'dst', both given as [user@]host:path, the directory on one server
+is mirrored to the other; files are relayed through this machine, not stored on it.`,
 	SilenceUsage: true,
 	Args:         cobra.MaximumNArgs(4),
-	RunE: func(_ *cobra.Command, args []string) error {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sshFlags = cmd.Flags()
 		local := viper.GetString("local")
 		remote := viper.GetString("remote")
 		url := viper.GetString("remote-url")
 		usr := viper.GetString("username")
 
-		if len(args) < 4 && (url == "" || local == "" || remote == "") {
+		if len(args) != 2 && len(args) < 4 && (url == "" || local == "" || remote == "") {
 			return errors.New("missing required argument 'local', 'remote', 'URL' or 'username'")
 		}
 
@@ -58,13 +69,58 @@ var sftpmirrorCmd = &cobra.Command{
 		clean := !viper.GetBool("dirty")
 		setGlobalVerbose := viper.GetBool("verbose")
 		verbose = setGlobalVerbose
+		ignoreSpace = viper.GetBool("ignore-space")
+		verifyCopies = viper.GetBool("verify")
+		useDelta = viper.GetBool("delta")
+		checksum = viper.GetBool("checksum")
+		hardLinks = viper.GetBool("hard-links")
+		detectRenames = viper.GetBool("detect-renames")
+		verifyRenames = viper.GetBool("verify-renames")
+		backupDir = viper.GetString("backup-dir")
+		backupSuffix = viper.GetString("suffix")
+		maxDelete = viper.GetInt("max-delete")
+		maxDeletePercent = viper.GetFloat64("max-delete-percent")
+		allowEmptySrc = viper.GetBool("allow-empty-src")
+		useTrash = viper.GetBool("trash")
+		trashMaxAge = viper.GetDuration("trash-max-age")
+		trashDir = viper.GetString("trash-dir")
+		clockSkewWarn = viper.GetDuration("clock-skew-warn")
+		useAgent = viper.GetBool("agent")
+		agentCommand = viper.GetString("agent-command")
+		sftpPreserve = libsftp.Preserve{Atime: viper.GetBool("atimes"), Perms: viper.GetBool("perms")}
+		sftpConnections = viper.GetInt("connections")
+		sftpClientOptions = libsftp.ClientOptions{
+			MaxPacket:             viper.GetInt("max-packet"),
+			MaxConcurrentRequests: viper.GetInt("max-requests"),
+			ConcurrentWrites:      viper.GetBool("concurrent-writes"),
+			SequentialReads:       viper.GetBool("sequential-reads"),
+		}
+
+		// two remote directories: mirror from one server to the other
+		if len(args) == 2 {
+			src, srcDir, srcOK, err := remoteEndpoint(args[0], p)
+			if err != nil {
+				return err
+			}
+			dst, dstDir, dstOK, err := remoteEndpoint(args[1], p)
+			if err != nil {
+				return err
+			}
+			if !srcOK || !dstOK {
+				return errors.New("with two arguments, both must be remote directories, [user@]host:path")
+			}
+			if reverse {
+				src, dst, srcDir, dstDir = dst, src, dstDir, srcDir
+			}
+			return SftpRelay(src, dst, srcDir, dstDir, dry, ignorehidden, clean)
+		}
 
 		creds := libsftp.Credentials{
-			Usr:        usr,
-			Host:       url,
-			AgentSock:  "SSH_AUTH_SOCK",
-			Port:       p,
-			SSHtimeout: time.Second * 10,
+			Usr:       usr,
+			Host:      url,
+			AgentSock: "SSH_AUTH_SOCK",
+			Port:      p,
+			SSH:       sshOptions(url),
 		}
 
 		return SftpMir(local, remote, creds, reverse, dry, ignorehidden, clean)
@@ -106,6 +162,158 @@ func init() {
 		log.Fatal("error binding viper to 'dirty' flag:", err)
 	}
 
+	sftpmirrorCmd.Flags().BoolVarP(&checksum, "checksum", "c", false, "compare the content of files with equal size, instead of mtime; hashed on the server if it allows running sha256sum or md5sum")
+	err = viper.BindPFlag("checksum", sftpmirrorCmd.Flags().Lookup("checksum"))
+	if err != nil {
+		log.Fatal("error binding viper to 'checksum' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().BoolVar(&useDelta, "delta", false, "only write changed chunks of files that exist on the remote (local to remote only)")
+	err = viper.BindPFlag("delta", sftpmirrorCmd.Flags().Lookup("delta"))
+	if err != nil {
+		log.Fatal("error binding viper to 'delta' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().BoolVarP(&hardLinks, "hard-links", "H", false, "preserve hard links between local files (local to remote only, if the server supports it)")
+	err = viper.BindPFlag("hard-links", sftpmirrorCmd.Flags().Lookup("hard-links"))
+	if err != nil {
+		log.Fatal("error binding viper to 'hard-links' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().BoolVar(&detectRenames, "detect-renames", false, "move files and dirs on the remote that were moved locally, instead of upload and delete")
+	err = viper.BindPFlag("detect-renames", sftpmirrorCmd.Flags().Lookup("detect-renames"))
+	if err != nil {
+		log.Fatal("error binding viper to 'detect-renames' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().BoolVar(&verifyRenames, "verify-renames", false, "compare file content before treating a file as moved (downloads the remote file)")
+	err = viper.BindPFlag("verify-renames", sftpmirrorCmd.Flags().Lookup("verify-renames"))
+	if err != nil {
+		log.Fatal("error binding viper to 'verify-renames' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().IntVar(&maxDelete, "max-delete", 0, "abort if more than n files / dirs would be deleted (0: no limit)")
+	err = viper.BindPFlag("max-delete", sftpmirrorCmd.Flags().Lookup("max-delete"))
+	if err != nil {
+		log.Fatal("error binding viper to 'max-delete' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().Float64Var(&maxDeletePercent, "max-delete-percent", 0, "abort if more than this percentage of dst would be deleted (0: no limit)")
+	err = viper.BindPFlag("max-delete-percent", sftpmirrorCmd.Flags().Lookup("max-delete-percent"))
+	if err != nil {
+		log.Fatal("error binding viper to 'max-delete-percent' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().BoolVar(&allowEmptySrc, "allow-empty-src", false, "allow deleting the content of dst if src is empty")
+	err = viper.BindPFlag("allow-empty-src", sftpmirrorCmd.Flags().Lookup("allow-empty-src"))
+	if err != nil {
+		log.Fatal("error binding viper to 'allow-empty-src' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().BoolVar(&useTrash, "trash", false, "move deleted files to the trash instead of removing them")
+	err = viper.BindPFlag("trash", sftpmirrorCmd.Flags().Lookup("trash"))
+	if err != nil {
+		log.Fatal("error binding viper to 'trash' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().StringVar(&trashDir, "trash-dir", "", "trash directory on the SFTP server, relative to dst (default \".gosyncit-trash\")")
+	err = viper.BindPFlag("trash-dir", sftpmirrorCmd.Flags().Lookup("trash-dir"))
+	if err != nil {
+		log.Fatal("error binding viper to 'trash-dir' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().DurationVar(&trashMaxAge, "trash-max-age", 30*24*time.Hour, "remove items from the trash after this time (0: keep forever)")
+	err = viper.BindPFlag("trash-max-age", sftpmirrorCmd.Flags().Lookup("trash-max-age"))
+	if err != nil {
+		log.Fatal("error binding viper to 'trash-max-age' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().StringVar(&backupDir, "backup-dir", "", "move overwritten or deleted files to a timestamped tree in this directory (relative to dst)")
+	err = viper.BindPFlag("backup-dir", sftpmirrorCmd.Flags().Lookup("backup-dir"))
+	if err != nil {
+		log.Fatal("error binding viper to 'backup-dir' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().StringVar(&backupSuffix, "suffix", "", "suffix appended to files in the backup directory")
+	err = viper.BindPFlag("suffix", sftpmirrorCmd.Flags().Lookup("suffix"))
+	if err != nil {
+		log.Fatal("error binding viper to 'suffix' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().BoolVar(&verifyCopies, "verify", false, "read back copied files and compare them to the source")
+	err = viper.BindPFlag("verify", sftpmirrorCmd.Flags().Lookup("verify"))
+	if err != nil {
+		log.Fatal("error binding viper to 'verify' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().BoolVar(&preserveAtimes, "atimes", false, "also carry over access times (modification times are always carried over)")
+	err = viper.BindPFlag("atimes", sftpmirrorCmd.Flags().Lookup("atimes"))
+	if err != nil {
+		log.Fatal("error binding viper to 'atimes' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().BoolVar(&preservePerms, "perms", false, "carry over permission bits")
+	err = viper.BindPFlag("perms", sftpmirrorCmd.Flags().Lookup("perms"))
+	if err != nil {
+		log.Fatal("error binding viper to 'perms' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().IntVar(&sftpConnections, "connections", 1, "number of SSH connections to transfer files in parallel")
+	err = viper.BindPFlag("connections", sftpmirrorCmd.Flags().Lookup("connections"))
+	if err != nil {
+		log.Fatal("error binding viper to 'connections' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().IntVar(&sftpClientOptions.MaxPacket, "max-packet", 0, "maximum SFTP packet payload in bytes (0: 32768)")
+	err = viper.BindPFlag("max-packet", sftpmirrorCmd.Flags().Lookup("max-packet"))
+	if err != nil {
+		log.Fatal("error binding viper to 'max-packet' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().IntVar(&sftpClientOptions.MaxConcurrentRequests, "max-requests", 0, "maximum concurrent SFTP requests per file (0: 64)")
+	err = viper.BindPFlag("max-requests", sftpmirrorCmd.Flags().Lookup("max-requests"))
+	if err != nil {
+		log.Fatal("error binding viper to 'max-requests' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().BoolVar(&sftpClientOptions.ConcurrentWrites, "concurrent-writes", false, "upload each file with concurrent write requests")
+	err = viper.BindPFlag("concurrent-writes", sftpmirrorCmd.Flags().Lookup("concurrent-writes"))
+	if err != nil {
+		log.Fatal("error binding viper to 'concurrent-writes' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().BoolVar(&sftpClientOptions.SequentialReads, "sequential-reads", false, "download each file with sequential read requests, for servers that need it")
+	err = viper.BindPFlag("sequential-reads", sftpmirrorCmd.Flags().Lookup("sequential-reads"))
+	if err != nil {
+		log.Fatal("error binding viper to 'sequential-reads' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().DurationVar(&clockSkewWarn, "clock-skew-warn", 5*time.Second, "warn if the clock of the server is off by more than this")
+	err = viper.BindPFlag("clock-skew-warn", sftpmirrorCmd.Flags().Lookup("clock-skew-warn"))
+	if err != nil {
+		log.Fatal("error binding viper to 'clock-skew-warn' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().BoolVar(&ignoreSpace, "ignore-space", false, "copy even if the free space on the destination seems insufficient")
+	err = viper.BindPFlag("ignore-space", sftpmirrorCmd.Flags().Lookup("ignore-space"))
+	if err != nil {
+		log.Fatal("error binding viper to 'ignore-space' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().BoolVar(&useAgent, "agent", false, "run gosyncit on the remote host and use its native protocol instead of SFTP, if it is installed (local to remote only)")
+	err = viper.BindPFlag("agent", sftpmirrorCmd.Flags().Lookup("agent"))
+	if err != nil {
+		log.Fatal("error binding viper to 'agent' flag:", err)
+	}
+
+	sftpmirrorCmd.Flags().StringVar(&agentCommand, "agent-command", "gosyncit", "gosyncit executable on the remote host, for --agent")
+	err = viper.BindPFlag("agent-command", sftpmirrorCmd.Flags().Lookup("agent-command"))
+	if err != nil {
+		log.Fatal("error binding viper to 'agent-command' flag:", err)
+	}
+
+	addSSHFlags(sftpmirrorCmd.Flags())
+
 	sftpmirrorCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output to the command line")
 	err = viper.BindPFlag("verbose", sftpmirrorCmd.Flags().Lookup("verbose"))
 	if err != nil {
@@ -124,6 +332,9 @@ func SftpMir(local, remote string, creds libsftp.Credentials, reverse, dry, igno
 	fmt.Printf("'%s' %v '%s'\n\n", local, arrow, remote)
 
 	if reverse {
+		if useAgent {
+			fmt.Println("warning: --agent only supports local to remote; using SFTP")
+		}
 		return sftpRemoteToLocal(local, remote, creds, dry, ignorehidden, clean)
 	}
 	return sftpLocalToRemote(local, remote, creds, dry, ignorehidden, clean)
@@ -133,19 +344,22 @@ func SftpMir(local, remote string, creds libsftp.Credentials, reverse, dry, igno
 func sftpLocalToRemote(local, remote string, creds libsftp.Credentials, dry, ignorehidden, clean bool) error {
 	var nItems, nBytes uint
 	t0 := time.Now()
+	verifyFailed = nil
 
-	sshcon, err := libsftp.GetSSHconn(creds)
+	pool, err := libsftp.NewPool(creds, sftpConnections, sftpClientOptions)
 	if err != nil {
 		return err
 	}
-	defer sshcon.Close()
+	defer pool.Close()
+	sc := pool.Clients[0]
+	verboseprintf("%v SFTP connection(s) established; %s\n", len(pool.Clients), &creds)
 
-	sc, err := sftp.NewClient(sshcon)
-	if err != nil {
+	if c, err := dialAgent(pool, remote, dry); err != nil {
 		return err
+	} else if c != nil {
+		defer c.Close()
+		return agentLocalToRemote(c, local, dry, ignorehidden, clean)
 	}
-	defer sc.Close()
-	verboseprintf("SFTP connection established; %s\n", &creds)
 
 	filesetLocal, err := fileset.New(local)
 	if err != nil {
@@ -172,9 +386,103 @@ func sftpLocalToRemote(local, remote string, creds libsftp.Credentials, dry, ign
 		return err
 	}
 
+	cmpOpts := sftpCompareOptions(sc, filesetRemote.Basepath, !dry)
+	hasher := newSftpHasher(pool)
+	wantHashes(hasher, &filesetRemote, filesetLocal)
+
+	mtimeStore = nil
+	if dry {
+		// no probe file in a dry run; modification times recorded by earlier runs apply
+		store, err := sidecar.Load(sc, filesetRemote.Basepath)
+		if err != nil {
+			return err
+		}
+		if store.Len() > 0 {
+			mtimeStore = store
+			mtimeStore.Apply(&filesetRemote)
+		}
+	} else if err := libsftp.CheckSetstat(sc, filesetRemote.Basepath); errors.Is(err, libsftp.ErrSetstat) {
+		if mtimeStore, err = sidecar.Load(sc, filesetRemote.Basepath); err != nil {
+			return err
+		}
+		fmt.Printf("warning: server does not set modification times, record them in '%s'\n", mtimeStore.Path)
+		mtimeStore.Apply(&filesetRemote)
+	} else if err != nil {
+		verboseprint("could not check if the server sets modification times:", err)
+	}
+
+	var bk *backup.Backup
+	if backupDir != "" {
+		bk = backup.New(backupDir, backupSuffix, filesetRemote.Basepath)
+		verboseprintf("backup of overwritten or deleted files to '%s'\n", bk.Dir)
+	}
+
+	var tr *trash.Trash
+	if useTrash && clean {
+		dir := trashDir
+		if dir == "" {
+			dir = trash.DefaultSftpDir
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filesetRemote.Basepath, dir)
+		}
+		tr = trash.Sftp(sc, dir)
+		verboseprintf("move deleted files to trash '%s'\n", tr.Dir)
+		expireTrash(tr, dry)
+	}
+
+	// step 0: move what was moved or renamed locally, instead of uploading it again
+	if detectRenames && clean {
+		var verify func(srcPath, dstPath string) bool
+		if verifyRenames {
+			verify = func(srcPath, dstPath string) bool {
+				equal, err := libsftp.DeepEqual(sc, srcPath, dstPath)
+				return err == nil && equal
+			}
+		}
+		renames, err := findRenames(local, filesetRemote.Filter(func(name string) bool {
+			full := filepath.Join(filesetRemote.Basepath, name)
+			return !bk.Contains(full) && !tr.Contains(full) && !sidecar.IsStore(name)
+		}), skipHidden, cmpOpts, verify)
+		if err != nil {
+			return err
+		}
+		for _, r := range renames {
+			fmt.Printf("move '%s' to '%s'\n", r.From, r.To)
+			if !dry {
+				to := filepath.Join(filesetRemote.Basepath, r.To)
+				if err := sc.MkdirAll(filepath.Dir(to)); err != nil {
+					return err
+				}
+				if err := libsftp.Rename(sc, filepath.Join(filesetRemote.Basepath, r.From), to); err != nil {
+					return err
+				}
+			}
+			filesetRemote.Move(r.From, r.To)
+			mtimeStore.Move(r.From, r.To)
+		}
+	}
+
+	canLink := false
+	if hardLinks {
+		if _, canLink = sc.HasExtension("hardlink@openssh.com"); !canLink {
+			fmt.Println("warning: server does not support hard links, files are uploaded individually")
+		}
+	}
+
+	planned := filesetLocal.Filter(keepName(ignorehidden))
+	if canLink {
+		planned.Links = filesetLocal.Links
+	}
+	need := space.Needed(planned, &filesetRemote, cmpOpts.BasicUnequal, bk != nil)
+	if err := checkSpace(need, filesetRemote.Basepath, func() (uint64, error) { return space.FreeSftp(sc, filesetRemote.Basepath) }, dry); err != nil {
+		return err
+	}
+
 	basepath := strings.TrimSuffix(filesetLocal.Basepath, string(os.PathSeparator))
 
 	// step 1: copy everything from local to remote if src newer (or size different)
+	xfer := newTransfers(pool.Clients)
 	err = filepath.Walk(local,
 		func(srcPath string, srcInfo os.FileInfo, err error) error {
 			if err != nil {
@@ -215,7 +523,37 @@ func sftpLocalToRemote(local, remote string, creds libsftp.Credentials, dry, ign
 				return nil
 			}
 
-			// B) item is file.
+			// B) item is a hard link to a file that was handled before.
+			//   link to that file on the remote if it is missing or changed.
+			//   The remote does not report inodes, so an unchanged file is assumed to be linked already.
+			if first, ok := filesetLocal.Links[childPath]; ok && canLink {
+				if dstInfo, ok := filesetRemote.Paths[childPath]; ok {
+					if !cmpOpts.BasicUnequal(srcInfo, dstInfo) {
+						verboseprintf("skip hard link '%s'\n", srcPath)
+						return nil
+					}
+					if err := bk.SftpSave(sc, dstPath, dry); err != nil {
+						return err
+					}
+				}
+				fmt.Printf("hard link '%s' to '%s'\n", srcPath, first)
+				if dry {
+					return nil
+				}
+				// the file to link to might still be uploading
+				if err := xfer.wait(); err != nil {
+					return err
+				}
+				err := libsftp.Link(sc, filepath.Join(remote, first), dstPath)
+				if err == nil {
+					return nil
+				}
+				verboseprint("hard link failed, upload instead:", err)
+				_, err = uploadFile(sc, srcPath, dstPath)
+				return err
+			}
+
+			// C) item is file.
 			//   exists in dst?
 			//     no  --> write.
 			//     yes --> overwrite?
@@ -226,20 +564,56 @@ func sftpLocalToRemote(local, remote string, creds libsftp.Credentials, dry, ign
 			if !filesetRemote.Contains(childPath) {
 				fmt.Printf("copy file '%s'\n", srcPath)
 				if !dry {
-					_, err := libsftp.UploadFile(sc, srcPath, dstPath)
-					return err
+					return xfer.run(func(sc *sftp.Client) error {
+						_, err := uploadFile(sc, srcPath, dstPath)
+						return err
+					})
 				}
 				return nil
 			}
 
-			dstInfo, _ := sc.Stat(filepath.Join(filesetRemote.Basepath, childPath))
-			if compare.BasicUnequal(srcInfo, dstInfo) {
+			dstInfo := filesetRemote.Paths[childPath]
+			unequal, err := sftpUnequal(hasher, cmpOpts, srcPath, dstPath, srcInfo, dstInfo, false)
+			if err != nil {
+				return err
+			}
+			if unequal {
 				fmt.Printf("overwrite file '%s'\n", srcPath)
 				if dry {
 					return nil
 				}
-				_, err := libsftp.UploadFile(sc, srcPath, dstPath)
-				return err
+				if useDelta {
+					return xfer.run(func(sc *sftp.Client) error {
+						// the old file is the base of the delta upload, keep it
+						if err := bk.SftpCopy(sc, dstPath, dry); err != nil {
+							return err
+						}
+						return verified(srcPath, dry,
+							func() error {
+								n, err := libsftp.UploadFileDelta(sc, srcPath, dstPath, delta.DefaultBlockSize)
+								verboseprintf("delta upload: %v of %v written\n", copy.ByteCount(uint(n)), copy.ByteCount(uint(srcInfo.Size())))
+								if err == nil {
+									err = libsftp.SetAttrs(sc, dstPath, srcInfo, sftpPreserve)
+								}
+								return keepMtime(sc, srcPath, dstPath, err)
+							},
+							func() (bool, error) { return libsftp.DeepEqual(sc, srcPath, dstPath) },
+						)
+					})
+				}
+				if err := bk.SftpSave(sc, dstPath, dry); err != nil {
+					return err
+				}
+				if canLink {
+					// remote file might be hard-linked to another file; don't write through the link
+					if err := sc.Remove(dstPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+						return err
+					}
+				}
+				return xfer.run(func(sc *sftp.Client) error {
+					_, err := uploadFile(sc, srcPath, dstPath)
+					return err
+				})
 			} else {
 				verboseprintf("skip file '%s'\n", srcPath)
 			}
@@ -247,6 +621,9 @@ func sftpLocalToRemote(local, remote string, creds libsftp.Credentials, dry, ign
 		},
 	)
 
+	if errXfer := xfer.close(); err == nil {
+		err = errXfer
+	}
 	if err != nil {
 		return err
 	}
@@ -254,29 +631,57 @@ func sftpLocalToRemote(local, remote string, creds libsftp.Credentials, dry, ign
 	// step 2: clean everything from remote that is not in local
 	if clean {
 		// for file in filesetDst: file exists in filesetSrc ? --> Delete if not.
-		for name, dstInfo := range filesetRemote.Paths {
+		var deletions []string
+		for name := range filesetRemote.Paths {
+			if bk.Contains(filepath.Join(filesetRemote.Basepath, name)) || tr.Contains(filepath.Join(filesetRemote.Basepath, name)) || sidecar.IsStore(name) {
+				continue
+			}
 			if skipHidden && (strings.HasPrefix(name, ".") || strings.Contains(name, "/.")) {
 				verboseprintf("skip hidden '%s'\n", name)
-				return nil
+				continue
 			}
 			if !filesetLocal.Contains(name) {
-				fmt.Printf("file/dir '%v' does not exist in src, delete\n", name)
-				if dry {
-					return nil
-				}
-				if dstInfo.IsDir() {
-					err = sc.RemoveDirectory(filepath.Join(filesetRemote.Basepath, name))
-				} else {
-					err = libsftp.DeleteFile(sc, filepath.Join(filesetRemote.Basepath, name), true)
-				}
-				if err != nil {
-					// this can sometimes give an error if the parent directory was deleted before...
-					verboseprint("deletion failed,", err)
-				}
+				deletions = append(deletions, name)
+			}
+		}
+		// a remote directory must be empty to be removed, so handle its content first.
+		// If it is moved to a backup or the trash instead, it goes first, with all its content.
+		sort.Strings(deletions)
+		if bk == nil && tr == nil {
+			sort.Sort(sort.Reverse(sort.StringSlice(deletions)))
+		}
+
+		if err := checkDeletions(deletions, len(filesetLocal.Paths), len(filesetRemote.Paths), dry); err != nil {
+			return err
+		}
+
+		for _, name := range deletions {
+			fmt.Printf("file/dir '%v' does not exist in src, delete\n", name)
+			if dry {
+				continue
+			}
+			if tr != nil {
+				err = tr.Put(filepath.Join(filesetRemote.Basepath, name), dry)
+			} else if bk != nil {
+				err = bk.SftpSave(sc, filepath.Join(filesetRemote.Basepath, name), dry)
+			} else if filesetRemote.Paths[name].IsDir() {
+				err = sc.RemoveDirectory(filepath.Join(filesetRemote.Basepath, name))
+			} else {
+				err = libsftp.DeleteFile(sc, filepath.Join(filesetRemote.Basepath, name), true)
+			}
+			if err != nil {
+				// this can sometimes give an error if the parent directory was deleted before...
+				verboseprint("deletion failed,", err)
 			}
 		}
 	}
 
+	if !dry {
+		if err := mtimeStore.Save(sc); err != nil {
+			return fmt.Errorf("failed to save modification times: %v", err)
+		}
+	}
+
 	dt := time.Since(t0)
 	verboseprintf("~~~ SFTP MIRROR done ~~~\n%v items (%v) in %v\n~~~\n",
 		nItems,
@@ -284,7 +689,7 @@ func sftpLocalToRemote(local, remote string, creds libsftp.Credentials, dry, ign
 		dt,
 	)
 
-	return nil
+	return verifySummary()
 }
 
 // wrapper if reverse == True; mirror from remote to local
@@ -292,19 +697,15 @@ func sftpRemoteToLocal(local, remote string, creds libsftp.Credentials, dry, ign
 	_ = clean // NOTE : unused ?!
 	var nItems, nBytes uint
 	t0 := time.Now()
+	verifyFailed = nil
 
-	sshcon, err := libsftp.GetSSHconn(creds)
-	if err != nil {
-		return err
-	}
-	defer sshcon.Close()
-
-	sc, err := sftp.NewClient(sshcon)
+	pool, err := libsftp.NewPool(creds, sftpConnections, sftpClientOptions)
 	if err != nil {
 		return err
 	}
-	defer sc.Close()
-	verboseprintf("SFTP connection established; %s\n", &creds)
+	defer pool.Close()
+	sc := pool.Clients[0]
+	verboseprintf("%v SFTP connection(s) established; %s\n", len(pool.Clients), &creds)
 
 	filesetLocal, err := fileset.New(local)
 	if err != nil {
@@ -331,96 +732,142 @@ func sftpRemoteToLocal(local, remote string, creds libsftp.Credentials, dry, ign
 		return err
 	}
 
+	// the server is the source, which is not written to; no clock offset probe
+	cmpOpts := sftpCompareOptions(sc, filesetRemote.Basepath, false)
+	hasher := newSftpHasher(pool)
+	wantHashes(hasher, &filesetRemote, filesetLocal)
+
+	// modification times recorded by uploads to a server that does not set them
+	mtimeStore, err = sidecar.Load(sc, filesetRemote.Basepath)
+	if err != nil {
+		return err
+	}
+
+	var bk *backup.Backup
+	if backupDir != "" {
+		bk = backup.New(backupDir, backupSuffix, filesetLocal.Basepath)
+		verboseprintf("backup of overwritten files to '%s'\n", bk.Dir)
+	}
+
+	keep := keepName(ignorehidden)
+	planned := filesetRemote.Filter(func(name string) bool { return keep(name) && !sidecar.IsStore(name) })
+	need := space.Needed(planned, filesetLocal, cmpOpts.BasicUnequal, bk != nil)
+	if err := checkSpace(need, filesetLocal.Basepath, func() (uint64, error) { return space.Free(filesetLocal.Basepath) }, dry); err != nil {
+		return err
+	}
+
 	basepath := strings.TrimSuffix(filesetLocal.Basepath, string(os.PathSeparator))
 
 	// step 1: copy everything from remote to local if newer
+	xfer := newTransfers(pool.Clients)
 	walker := sc.Walk(filesetRemote.Basepath)
 	for walker.Step() {
-		if err := walker.Err(); err != nil {
-			return err
+		if err = walker.Err(); err != nil {
+			break
 		}
 
-		srcPath := walker.Path()
+		visit := func() error {
+			srcPath := walker.Path()
 
-		childPath := strings.TrimPrefix(srcPath, filesetRemote.Basepath)
+			childPath := strings.TrimPrefix(srcPath, filesetRemote.Basepath)
 
-		if childPath == "" {
-			continue
-		}
-
-		if childPath == basepath {
-			continue
-		}
+			if childPath == "" {
+				return nil
+			}
 
-		if ignorehidden && (strings.HasPrefix(srcPath, ".") || strings.Contains(srcPath, "/.")) {
-			verboseprintf("skip hidden '%s'\n", srcPath)
-			return nil
-		}
+			if childPath == basepath || sidecar.IsStore(childPath) {
+				return nil
+			}
 
-		if strings.HasSuffix(srcPath, "humbs.db") {
-			verboseprint("skip Windows Thumbs.db")
-			return nil
-		}
+			if ignorehidden && (strings.HasPrefix(srcPath, ".") || strings.Contains(srcPath, "/.")) {
+				verboseprintf("skip hidden '%s'\n", srcPath)
+				return nil
+			}
 
-		srcInfo := walker.Stat()
+			if strings.HasSuffix(srcPath, "humbs.db") {
+				verboseprint("skip Windows Thumbs.db")
+				return nil
+			}
 
-		nItems++
-		nBytes += uint(srcInfo.Size())
+			srcInfo := mtimeStore.Stat(childPath, walker.Stat())
 
-		dstPath := filepath.Join(local, childPath)
+			nItems++
+			nBytes += uint(srcInfo.Size())
 
-		// A) item is directory.
-		//   exists in dst?
-		//     no  --> create.
-		//     yes --> skip.
-		if srcInfo.IsDir() { // dir is created locally
-			verboseprintf("create or skip dir '%s'\n", dstPath)
-			return copy.CreateDir(dstPath, dry) // ignores error if dir exists
-		}
+			dstPath := filepath.Join(local, childPath)
 
-		if !srcInfo.Mode().IsRegular() {
-			verboseprintf("skip non-regular file '%s'\n", srcPath)
-			return nil
-		}
+			// A) item is directory.
+			//   exists in dst?
+			//     no  --> create.
+			//     yes --> skip.
+			if srcInfo.IsDir() { // dir is created locally
+				verboseprintf("create or skip dir '%s'\n", dstPath)
+				return copy.CreateDir(dstPath, dry) // ignores error if dir exists
+			}
 
-		// B) item is file.
-		//   exists in dst?
-		//     no  --> write.
-		//     yes --> overwrite?
-		//       yes --> write.
-		//       no  --> src younger?
-		//         yes --> write.
-		//         no  --> skip.
-		if !filesetLocal.Contains(childPath) {
-			fmt.Printf("copy file '%s'\n", srcPath)
-			if !dry {
-				_, err := libsftp.DownloadFile(sc, srcPath, dstPath)
-				return err
+			if !srcInfo.Mode().IsRegular() {
+				verboseprintf("skip non-regular file '%s'\n", srcPath)
+				return nil
 			}
-			return nil
-		}
 
-		dstInfo, _ := os.Stat(filepath.Join(filesetLocal.Basepath, childPath))
-		if compare.BasicUnequal(srcInfo, dstInfo) {
-			fmt.Printf("overwrite file '%s'\n", srcPath)
-			// fmt.Println(srcInfo.ModTime(), dstInfo.ModTime())
-			// fmt.Println(srcInfo.Size(), dstInfo.Size())
-			if dry {
+			// B) item is file.
+			//   exists in dst?
+			//     no  --> write.
+			//     yes --> overwrite?
+			//       yes --> write.
+			//       no  --> src younger?
+			//         yes --> write.
+			//         no  --> skip.
+			if !filesetLocal.Contains(childPath) {
+				fmt.Printf("copy file '%s'\n", srcPath)
+				if !dry {
+					return xfer.run(func(sc *sftp.Client) error {
+						_, err := downloadFile(sc, srcPath, dstPath, srcInfo)
+						return err
+					})
+				}
 				return nil
 			}
-			_, err := libsftp.DownloadFile(sc, srcPath, dstPath)
+
+			dstInfo, err := os.Stat(filepath.Join(filesetLocal.Basepath, childPath))
 			if err != nil {
 				return err
 			}
-			// // SFTP timestamps tend to be messed up; make sure it is set:
-			// mtime := srcInfo.ModTime()
-			// err = os.Chtimes(dstPath, mtime, mtime)
-			return err
-		} else {
-			verboseprintf("skip file '%s'\n", srcPath)
+			unequal, err := sftpUnequal(hasher, cmpOpts, srcPath, dstPath, srcInfo, dstInfo, true)
+			if err != nil {
+				return err
+			}
+			if unequal {
+				fmt.Printf("overwrite file '%s'\n", srcPath)
+				// fmt.Println(srcInfo.ModTime(), dstInfo.ModTime())
+				// fmt.Println(srcInfo.Size(), dstInfo.Size())
+				if dry {
+					return nil
+				}
+				if err := bk.Save(dstPath, dry); err != nil {
+					return err
+				}
+				return xfer.run(func(sc *sftp.Client) error {
+					_, err := downloadFile(sc, srcPath, dstPath, srcInfo)
+					return err
+				})
+			} else {
+				verboseprintf("skip file '%s'\n", srcPath)
+			}
+			return nil
+		}
+		if err = visit(); err != nil {
+			break
 		}
 	}
 
+	if errXfer := xfer.close(); err == nil {
+		err = errXfer
+	}
+	if err != nil {
+		return err
+	}
+
 	dt := time.Since(t0)
 	verboseprintf("~~~ SFTP MIRROR done ~~~\n%v items (%v) in %v\n~~~\n",
 		nItems,
@@ -428,5 +875,147 @@ func sftpRemoteToLocal(local, remote string, creds libsftp.Credentials, dry, ign
 		dt,
 	)
 
+	return verifySummary()
+}
+
+// sftpCompareOptions returns the options to compare local and remote (dst) modification times:
+// SFTP only transfers whole seconds, and if probe is set, the clock offset of the server is
+// measured with a probe file in directory 'dir' on the server. Do not probe in dry runs, or
+// servers that are only read from.
+func sftpCompareOptions(sc *sftp.Client, dir string, probe bool) compare.Options {
+	opts := compare.Options{Granularity: time.Second}
+	if !probe {
+		return opts
+	}
+	offset, err := libsftp.ClockOffset(sc, dir)
+	if err != nil {
+		verboseprint("could not measure clock offset of the server:", err)
+		return opts
+	}
+	verboseprintf("server clock offset: %v\n", offset)
+	if clockSkewWarn > 0 && offset.Abs() > clockSkewWarn {
+		fmt.Printf("warning: the clock of the server is off by %v, modification times are corrected\n", offset)
+	}
+	opts.Offset = offset
+	return opts
+}
+
+// newSftpHasher returns a hasher for the server of the pool if the checksum option is set, or nil
+func newSftpHasher(pool *libsftp.Pool) *libsftp.Hasher {
+	if !checksum {
+		return nil
+	}
+	hasher := libsftp.NewHasher(pool.Clients[0], pool.Conn())
+	if hasher.Exec() != "" {
+		verboseprintf("hash files on the server with '%s'\n", hasher.Exec())
+	} else {
+		fmt.Println("warning: cannot hash files on the server, they are downloaded to compare them")
+	}
+	return hasher
+}
+
+// wantHashes announces the files of remote fileset 'remote' that have a counterpart of equal
+// size in 'other' to the hasher, so they are hashed in batches, see libsftp.Hasher.Want.
+// Files of different size are compared without hashing them.
+func wantHashes(hasher *libsftp.Hasher, remote, other *fileset.Fileset) {
+	if hasher == nil {
+		return
+	}
+	for name, info := range remote.Paths {
+		if o, ok := other.Paths[name]; ok && info.Mode().IsRegular() && o.Mode().IsRegular() && info.Size() == o.Size() {
+			hasher.Want(path.Join(remote.Basepath, filepath.ToSlash(name)))
+		}
+	}
+}
+
+// sftpUnequal returns true if file 'src' must be copied to 'dst'. If hasher is not nil, files
+// of equal size are compared by hash, otherwise by mtime and size. If reverse is set,
+// 'src' is the remote file, else 'dst'.
+func sftpUnequal(hasher *libsftp.Hasher, opts compare.Options, src, dst string, srcInfo, dstInfo os.FileInfo, reverse bool) (bool, error) {
+	if hasher == nil || srcInfo.Size() != dstInfo.Size() {
+		return opts.BasicUnequal(srcInfo, dstInfo), nil
+	}
+	local, remote := src, dst
+	if reverse {
+		local, remote = dst, src
+	}
+	hl, err := hasher.LocalHash(local)
+	if err != nil {
+		return false, err
+	}
+	hr, err := hasher.Hash(remote)
+	if err != nil {
+		return false, err
+	}
+	return hl != hr, nil
+}
+
+// sftpPreserve are the file attributes carried over by uploads and downloads
+var sftpPreserve libsftp.Preserve
+
+// sftpClientOptions tune the SFTP clients
+var sftpClientOptions libsftp.ClientOptions
+
+// mtimeStore records modification times on a server that does not set them; nil if not needed
+var (
+	mtimeStore   *sidecar.Store
+	mtimeStoreMu sync.Mutex // uploads might run concurrently
+)
+
+// uploadFile to the SFTP server; verified if the verify option is set.
+func uploadFile(sc *sftp.Client, local, remote string) (int64, error) {
+	var n int64
+	err := verified(local, false,
+		func() (err error) {
+			n, err = libsftp.UploadFileWith(sc, local, remote, sftpPreserve)
+			return keepMtime(sc, local, remote, err)
+		},
+		func() (bool, error) { return libsftp.DeepEqual(sc, local, remote) },
+	)
+	return n, err
+}
+
+// keepMtime records the modification time of 'local' for 'remote' in mtimeStore, if it is used,
+// after an upload that returned 'err'. Then, an error wrapping libsftp.ErrSetstat is expected.
+func keepMtime(sc *sftp.Client, local, remote string, err error) error {
+	if mtimeStore == nil || (err != nil && !errors.Is(err, libsftp.ErrSetstat)) {
+		return err
+	}
+	name, err := filepath.Rel(mtimeStore.Dir, remote)
+	if err != nil {
+		return err
+	}
+	localInfo, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	remoteInfo, err := sc.Stat(remote)
+	if err != nil {
+		return err
+	}
+	mtimeStoreMu.Lock()
+	mtimeStore.Set(name, localInfo.ModTime(), remoteInfo)
+	mtimeStoreMu.Unlock()
 	return nil
 }
+
+// downloadFile from the SFTP server; verified if the verify option is set.
+// The local modification time is set to that of 'srcInfo', which might have been recorded in mtimeStore.
+func downloadFile(sc *sftp.Client, remote, local string, srcInfo os.FileInfo) (int64, error) {
+	var n int64
+	err := verified(remote, false,
+		func() (err error) {
+			n, err = libsftp.DownloadFileWith(sc, remote, local, sftpPreserve)
+			if err != nil {
+				return err
+			}
+			mtime := srcInfo.ModTime()
+			if info, err := os.Stat(local); err == nil && info.ModTime().Equal(mtime) {
+				return nil
+			}
+			return os.Chtimes(local, mtime, mtime)
+		},
+		func() (bool, error) { return libsftp.DeepEqual(sc, local, remote) },
+	)
+	return n, err
+}
@@ -0,0 +1,329 @@
+/*
+Copyright © 2023 Florian Obersteiner <f.obersteiner@posteo.de>
+
+License: see LICENSE in the root directory of the repo.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/FObersteiner/gosyncit/lib/compare"
+	"github.com/FObersteiner/gosyncit/lib/copy"
+	"github.com/FObersteiner/gosyncit/lib/fileset"
+	"github.com/FObersteiner/gosyncit/lib/pathlib"
+	"github.com/FObersteiner/gosyncit/lib/snapshot"
+	"github.com/FObersteiner/gosyncit/lib/space"
+)
+
+var snapshotCmd = &cobra.Command{
+	Use:     "snapshot 'src' 'dst'",
+	Aliases: []string{"snap"},
+	Short:   "create a snapshot of directory 'src' in directory 'dst'",
+	Long: `Create a point-in-time copy of the source directory in a timestamped
+subdirectory of the destination directory.
+Files that are unchanged since the previous snapshot are hard-linked instead of copied,
+so each snapshot only uses disk space for what has changed.
+The 'latest' symlink in the destination points to the newest snapshot.`,
+	SilenceUsage: true,
+	Args:         cobra.MaximumNArgs(2),
+	RunE: func(_ *cobra.Command, args []string) error {
+		src := viper.GetString("src")
+		dst := viper.GetString("dst")
+		if len(args) < 2 && (src == "" || dst == "") {
+			return errors.New("missing required argument 'src' or 'dst'")
+		}
+
+		if len(args) == 2 {
+			src = args[0]
+			dst = args[1]
+		}
+
+		dry := viper.GetBool("dryrun")
+		ignorehidden := viper.GetBool("skiphidden")
+		deep := viper.GetBool("checksum")
+		setGlobalVerbose := viper.GetBool("verbose")
+		verbose = setGlobalVerbose
+		ignoreSpace = viper.GetBool("ignore-space")
+		verifyCopies = viper.GetBool("verify")
+		if err := setCopyOptions(); err != nil {
+			return err
+		}
+
+		if err := openHashCache(); err != nil {
+			return err
+		}
+		defer closeHashCache()
+
+		return Snapshot(src, dst, dry, ignorehidden, deep)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(snapshotCmd)
+
+	snapshotCmd.Flags().SortFlags = false
+
+	snapshotCmd.Flags().BoolVarP(&dryRun, "dryrun", "n", false, "show what will be done")
+	err := viper.BindPFlag("dryrun", snapshotCmd.Flags().Lookup("dryrun"))
+	if err != nil {
+		log.Fatal("error binding viper to 'dryrun' flag:", err)
+	}
+
+	snapshotCmd.Flags().BoolVarP(&skipHidden, "skiphidden", "s", false, "skip hidden files")
+	err = viper.BindPFlag("skiphidden", snapshotCmd.Flags().Lookup("skiphidden"))
+	if err != nil {
+		log.Fatal("error binding viper to 'skiphidden' flag:", err)
+	}
+
+	snapshotCmd.Flags().BoolVarP(&checksum, "checksum", "c", false, "compare content, not only mtime and size, before linking to the previous snapshot")
+	err = viper.BindPFlag("checksum", snapshotCmd.Flags().Lookup("checksum"))
+	if err != nil {
+		log.Fatal("error binding viper to 'checksum' flag:", err)
+	}
+
+	snapshotCmd.Flags().BoolVar(&useHashCache, "hash-cache", false, "keep hashes of local files in a persistent cache, so unchanged files are not hashed again")
+	err = viper.BindPFlag("hash-cache", snapshotCmd.Flags().Lookup("hash-cache"))
+	if err != nil {
+		log.Fatal("error binding viper to 'hash-cache' flag:", err)
+	}
+
+	snapshotCmd.Flags().BoolVar(&hashCacheXattr, "xattr", false, "with --hash-cache, also store hashes in extended attributes of the files")
+	err = viper.BindPFlag("xattr", snapshotCmd.Flags().Lookup("xattr"))
+	if err != nil {
+		log.Fatal("error binding viper to 'xattr' flag:", err)
+	}
+
+	snapshotCmd.Flags().BoolVar(&sparse, "sparse", false, "turn blocks of zeros into holes in dst files")
+	err = viper.BindPFlag("sparse", snapshotCmd.Flags().Lookup("sparse"))
+	if err != nil {
+		log.Fatal("error binding viper to 'sparse' flag:", err)
+	}
+
+	snapshotCmd.Flags().StringVar(&reflink, "reflink", "never", "clone files instead of copying data, if the file system supports it: auto, always or never")
+	err = viper.BindPFlag("reflink", snapshotCmd.Flags().Lookup("reflink"))
+	if err != nil {
+		log.Fatal("error binding viper to 'reflink' flag:", err)
+	}
+
+	snapshotCmd.Flags().BoolVar(&verifyCopies, "verify", false, "read back copied files and compare them to the source")
+	err = viper.BindPFlag("verify", snapshotCmd.Flags().Lookup("verify"))
+	if err != nil {
+		log.Fatal("error binding viper to 'verify' flag:", err)
+	}
+
+	snapshotCmd.Flags().DurationVar(&modifyWindow, "modify-window", 0, "treat modification times as equal if they differ by no more than this (0: detect from dst)")
+	err = viper.BindPFlag("modify-window", snapshotCmd.Flags().Lookup("modify-window"))
+	if err != nil {
+		log.Fatal("error binding viper to 'modify-window' flag:", err)
+	}
+
+	snapshotCmd.Flags().BoolVar(&ignoreDSTShift, "ignore-dst-shift", false, "also treat modification times as equal if they differ by exactly one hour (FAT and DST changes)")
+	err = viper.BindPFlag("ignore-dst-shift", snapshotCmd.Flags().Lookup("ignore-dst-shift"))
+	if err != nil {
+		log.Fatal("error binding viper to 'ignore-dst-shift' flag:", err)
+	}
+
+	snapshotCmd.Flags().BoolVar(&ignoreSpace, "ignore-space", false, "copy even if the free space on the destination seems insufficient")
+	err = viper.BindPFlag("ignore-space", snapshotCmd.Flags().Lookup("ignore-space"))
+	if err != nil {
+		log.Fatal("error binding viper to 'ignore-space' flag:", err)
+	}
+
+	snapshotCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output to the command line")
+	err = viper.BindPFlag("verbose", snapshotCmd.Flags().Lookup("verbose"))
+	if err != nil {
+		log.Fatal("error binding viper to 'verbose' flag:", err)
+	}
+}
+
+// ------------------------------------------------------------------------------------
+
+// Snapshot copies directory 'src' to a new timestamped directory within 'dst'.
+// Files that did not change compared to the previous snapshot are hard-linked.
+// If deep is true, file content is compared in addition to mtime and size.
+func Snapshot(src, dst string, dry, skipHidden, deep bool) error {
+	fmt.Println("~~~ SNAPSHOT ~~~")
+	fmt.Printf("'%s' --> '%s'\n\n", src, dst)
+
+	var nItems, nBytes, nLinked uint
+	t0 := time.Now()
+	verifyFailed = nil
+
+	src, dst, err := pathlib.CheckSrcDst(src, dst)
+	if err != nil {
+		verboseprint("path check error:", err)
+		return err
+	}
+
+	filesetSrc, err := fileset.New(src)
+	if err != nil {
+		verboseprint("src file set creation error:", err)
+		return err
+	}
+
+	prev, err := snapshot.Latest(dst)
+	if err != nil {
+		return err
+	}
+	prevPath := filepath.Join(dst, prev)
+	if prev == "" {
+		verboseprint("no previous snapshot found, copy everything.")
+	} else {
+		verboseprintf("previous snapshot: '%s'\n", prev)
+	}
+
+	name := snapshot.Name(t0)
+	if _, err := os.Stat(filepath.Join(dst, name)); err == nil {
+		return fmt.Errorf("snapshot '%s' already exists", filepath.Join(dst, name))
+	}
+	// the snapshot is built under a temporary name, so that an incomplete snapshot
+	// is never taken for a real one
+	target := filepath.Join(dst, "."+name+".partial")
+	if !dry {
+		_ = os.RemoveAll(target) // left over from an aborted run
+	}
+	if err := copy.CreateDir(target, dry); err != nil {
+		return err
+	}
+	complete := false
+	defer func() {
+		if !complete && !dry {
+			_ = os.RemoveAll(target)
+		}
+	}()
+
+	cmpOpts := compareOptions(dry, target)
+
+	// files unchanged since the previous snapshot are linked, everything else is written in full
+	verboseprint("analyzing source...")
+	if err := filesetSrc.Populate(); err != nil {
+		return err
+	}
+	filesetPrev := &fileset.Fileset{Paths: make(map[string]os.FileInfo)}
+	if prev != "" {
+		if filesetPrev, err = fileset.New(prevPath); err != nil {
+			return err
+		}
+		if err := filesetPrev.Populate(); err != nil {
+			return err
+		}
+	}
+	changed := func(srcInfo, prevInfo os.FileInfo) bool {
+		return srcInfo.Size() != prevInfo.Size() || !cmpOpts.MtimeEqual(srcInfo.ModTime(), prevInfo.ModTime())
+	}
+	need := space.Needed(filesetSrc.Filter(keepName(skipHidden)), filesetPrev, changed, true)
+	if err := checkSpace(need, dst, func() (uint64, error) { return space.Free(target) }, dry); err != nil {
+		return err
+	}
+
+	basepath := strings.TrimSuffix(filesetSrc.Basepath, string(os.PathSeparator))
+
+	err = filepath.Walk(src,
+		func(srcPath string, srcInfo os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			childPath := strings.TrimPrefix(srcPath, filesetSrc.Basepath)
+			if childPath == basepath {
+				return nil // skip basepath
+			}
+
+			if skipHidden && (strings.HasPrefix(srcPath, ".") || strings.Contains(srcPath, "/.")) {
+				verboseprintf("skip hidden '%s'\n", srcPath)
+				return nil
+			}
+
+			if strings.HasSuffix(srcPath, "humbs.db") {
+				verboseprint("skip Windows Thumbs.db")
+				return nil
+			}
+
+			nItems++
+			dstPath := filepath.Join(target, childPath)
+
+			if srcInfo.IsDir() {
+				verboseprintf("create dir '%s'\n", dstPath)
+				return copy.CreateDir(dstPath, dry)
+			}
+
+			if !srcInfo.Mode().IsRegular() {
+				verboseprintf("skip non-regular file '%s'\n", srcPath)
+				return nil
+			}
+
+			// file is unchanged since the previous snapshot --> link.
+			if prev != "" {
+				prevFile := filepath.Join(prevPath, childPath)
+				if unchanged(srcPath, prevFile, srcInfo, cmpOpts, deep) {
+					verboseprintf("link file '%s'\n", srcPath)
+					if dry {
+						nLinked++
+						return nil
+					}
+					err := os.Link(prevFile, dstPath)
+					if err == nil {
+						nLinked++
+						return nil
+					}
+					verboseprint("link failed, copy instead:", err)
+				}
+			}
+
+			fmt.Printf("copy file '%s'\n", srcPath)
+			nBytes += uint(srcInfo.Size())
+			return copyFile(srcPath, dstPath, srcInfo, dry)
+		},
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if !dry {
+		if err := os.Rename(target, filepath.Join(dst, name)); err != nil {
+			return err
+		}
+		complete = true
+		if err := snapshot.SetLatest(dst, name); err != nil {
+			return fmt.Errorf("failed to update '%s' link: %v", snapshot.LatestName, err)
+		}
+	}
+
+	dt := time.Since(t0)
+	fmt.Printf("\n~~~ SNAPSHOT done ~~~\n'%s': %v items, %v linked, %v copied, in %v\n~~~\n",
+		name,
+		nItems,
+		nLinked,
+		copy.ByteCount(nBytes),
+		dt,
+	)
+	return verifySummary()
+}
+
+// unchanged returns true if file 'prev' from the previous snapshot can be used for 'src'
+func unchanged(src, prev string, srcInfo os.FileInfo, opts compare.Options, deep bool) bool {
+	prevInfo, err := os.Lstat(prev)
+	if err != nil || !prevInfo.Mode().IsRegular() {
+		return false
+	}
+	// unlike mirror, a file that is older in src than in the previous snapshot also differs
+	if srcInfo.Size() != prevInfo.Size() || !opts.MtimeEqual(srcInfo.ModTime(), prevInfo.ModTime()) {
+		return false
+	}
+	if !deep {
+		return true
+	}
+	equal, err := contentEqual(src, prev)
+	return err == nil && equal
+}
@@ -0,0 +1,121 @@
+package cmd_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FObersteiner/gosyncit/cmd"
+	"github.com/FObersteiner/gosyncit/lib/snapshot"
+)
+
+func TestSnapshot(t *testing.T) {
+	dry := false
+	ignorehidden := false
+	deep := false
+
+	err := cmd.Snapshot("A", "B", dry, ignorehidden, deep)
+	if err == nil {
+		t.Fail()
+		t.Log("snapshot must fail with invalid src/dst input")
+	}
+
+	src, err := os.MkdirTemp("", "src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+
+	dst, err := os.MkdirTemp("", "dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	mtime := time.Date(2006, time.March, 1, 3, 4, 5, 0, time.UTC)
+	for _, name := range []string{"unchanged", "changed"} {
+		fname := filepath.Join(src, "subdir", name)
+		_ = os.MkdirAll(filepath.Dir(fname), 0755)
+		if err := os.WriteFile(fname, []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(fname, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// previous snapshot; 'changed' is older there
+	prev := snapshot.Name(time.Now().Add(-24 * time.Hour))
+	for name, content := range map[string]string{"unchanged": "content", "changed": "old"} {
+		fname := filepath.Join(dst, prev, "subdir", name)
+		_ = os.MkdirAll(filepath.Dir(fname), 0755)
+		if err := os.WriteFile(fname, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		m := mtime
+		if name == "changed" {
+			m = mtime.Add(-time.Hour)
+		}
+		if err := os.Chtimes(fname, m, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := snapshot.SetLatest(dst, prev); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.Snapshot(src, dst, dry, ignorehidden, deep); err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := snapshot.Latest(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest == prev {
+		t.Fatal("'latest' must point to the new snapshot")
+	}
+
+	a, _ := os.Stat(filepath.Join(dst, prev, "subdir", "unchanged"))
+	b, _ := os.Stat(filepath.Join(dst, latest, "subdir", "unchanged"))
+	if !os.SameFile(a, b) {
+		t.Log("unchanged file must be hard-linked to the previous snapshot")
+		t.Fail()
+	}
+
+	a, _ = os.Stat(filepath.Join(dst, prev, "subdir", "changed"))
+	b, _ = os.Stat(filepath.Join(dst, latest, "subdir", "changed"))
+	if os.SameFile(a, b) {
+		t.Log("changed file must be copied, not linked")
+		t.Fail()
+	}
+	content, _ := os.ReadFile(filepath.Join(dst, latest, "subdir", "changed"))
+	if string(content) != "content" {
+		t.Logf("expected content of src, got %q", content)
+		t.Fail()
+	}
+}
+
+func TestSnapshotFailed(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read any file")
+	}
+	src, dst := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "unreadable"), []byte("content"), 0000); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.Snapshot(src, dst, false, false, false); err == nil {
+		t.Fatal("snapshot must fail if a file cannot be copied")
+	}
+	entries, _ := os.ReadDir(dst)
+	if len(entries) != 0 {
+		t.Logf("a failed snapshot must not leave anything in dst, have %v", entries)
+		t.Fail()
+	}
+	if latest, _ := snapshot.Latest(dst); latest != "" {
+		t.Logf("expected no latest snapshot, have '%s'", latest)
+		t.Fail()
+	}
+}
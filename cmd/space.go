@@ -0,0 +1,45 @@
+/*
+Copyright © 2023 Florian Obersteiner <f.obersteiner@posteo.de>
+
+License: see LICENSE in the root directory of the repo.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/FObersteiner/gosyncit/lib/copy"
+)
+
+// checkSpace returns an error if 'need' bytes exceed the space available on destination 'dst',
+// as returned by 'free'. With the ignore-space option, or in a dry run, it only prints a warning.
+// If the free space cannot be determined, the check is skipped.
+func checkSpace(need uint64, dst string, free func() (uint64, error), dry bool) error {
+	avail, err := free()
+	if err != nil {
+		verboseprintf("cannot determine free space on '%s', skip check: %v\n", dst, err)
+		return nil
+	}
+	verboseprintf("%v to write, %v available on '%s'\n", copy.ByteCount(uint(need)), copy.ByteCount(uint(avail)), dst)
+	if need <= avail {
+		return nil
+	}
+	msg := fmt.Sprintf("not enough space on '%s': %v to write, %v available", dst, copy.ByteCount(uint(need)), copy.ByteCount(uint(avail)))
+	if ignoreSpace || dry {
+		fmt.Println("warning:", msg)
+		return nil
+	}
+	return fmt.Errorf("%s (use --ignore-space to copy anyway)", msg)
+}
+
+// keepName returns a filter for paths relative to a source directory, matching what the
+// commands skip while walking the source: hidden files if skipHidden is set, and Thumbs.db.
+func keepName(skipHidden bool) func(name string) bool {
+	return func(name string) bool {
+		if skipHidden && (strings.HasPrefix(name, ".") || strings.Contains(name, "/.")) {
+			return false
+		}
+		return !strings.HasSuffix(name, "humbs.db")
+	}
+}
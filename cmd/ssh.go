@@ -0,0 +1,90 @@
+/*
+Copyright © 2023 Florian Obersteiner <f.obersteiner@posteo.de>
+
+License: see LICENSE in the root directory of the repo.
+*/
+package cmd
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/FObersteiner/gosyncit/lib/libsftp"
+)
+
+// addSSHFlags adds the options of the SSH connection to the flags of a command that connects
+// to an SFTP server
+func addSSHFlags(flags *pflag.FlagSet) {
+	flags.DurationVar(&sshFlagOptions.ConnectTimeout, "ssh-connect-timeout", 10*time.Second, "give up connecting to the SSH server after this time (0: no limit)")
+	flags.DurationVar(&sshFlagOptions.Keepalive, "ssh-keepalive", 0, "send a keepalive to the SSH server in this interval, so idle connections are not dropped (0: none)")
+	flags.DurationVar(&sshFlagOptions.Timeout, "ssh-timeout", 0, "close the SSH connection if the server sends nothing for this time; use with a shorter --ssh-keepalive (0: no limit)")
+	flags.StringSliceVar(&sshFlagOptions.Ciphers, "ssh-ciphers", nil, "SSH ciphers in order of preference (default: those of golang.org/x/crypto/ssh)")
+	flags.StringSliceVar(&sshFlagOptions.KeyExchanges, "ssh-kex", nil, "SSH key exchange algorithms in order of preference")
+	flags.StringSliceVar(&sshFlagOptions.MACs, "ssh-macs", nil, "SSH MAC algorithms in order of preference")
+	flags.StringSliceVar(&sshFlagOptions.HostKeyAlgorithms, "ssh-host-key-algorithms", nil, "accepted SSH host key algorithms (default: ssh-rsa)")
+
+	for _, name := range []string{"ssh-connect-timeout", "ssh-keepalive", "ssh-timeout", "ssh-ciphers", "ssh-kex", "ssh-macs", "ssh-host-key-algorithms"} {
+		err := viper.BindPFlag(name, flags.Lookup(name))
+		if err != nil {
+			log.Fatal("error binding viper to '"+name+"' flag:", err)
+		}
+	}
+}
+
+// sshFlagOptions hold the values of the SSH flags; see sshOptions for the options of a host
+var sshFlagOptions libsftp.SSHOptions
+
+// sshFlags are the flags of the running command, set in its RunE
+var sshFlags *pflag.FlagSet
+
+// sshOptions returns the SSH options for 'host'. Flags set on the command line take precedence
+// over the table of the host in the config file, e.g. [hosts."example.com"] with the flag
+// names as keys, which takes precedence over the other config values and the flag defaults.
+func sshOptions(host string) libsftp.SSHOptions {
+	flags := sshFlags
+	if flags == nil {
+		flags = pflag.NewFlagSet("", pflag.ContinueOnError)
+	}
+	hostConf := viper.New()
+	if m, ok := viper.GetStringMap("hosts")[strings.ToLower(host)].(map[string]any); ok {
+		_ = hostConf.MergeConfigMap(m)
+	}
+	// the flags are read directly, since viper binds each key to the flag of one command only
+	duration := func(name string) time.Duration {
+		d := viper.GetDuration(name)
+		if hostConf.IsSet(name) {
+			d = hostConf.GetDuration(name)
+		}
+		if f, err := flags.GetDuration(name); err == nil && flags.Changed(name) {
+			d = f
+		}
+		return d
+	}
+	list := func(name string) []string {
+		l := viper.GetStringSlice(name)
+		if hostConf.IsSet(name) {
+			l = hostConf.GetStringSlice(name)
+		}
+		if f, err := flags.GetStringSlice(name); err == nil && flags.Changed(name) {
+			l = f
+		}
+		if len(l) == 0 {
+			return nil // an empty list would leave no algorithm at all
+		}
+		return l
+	}
+
+	return libsftp.SSHOptions{
+		ConnectTimeout:    duration("ssh-connect-timeout"),
+		Keepalive:         duration("ssh-keepalive"),
+		Timeout:           duration("ssh-timeout"),
+		Ciphers:           list("ssh-ciphers"),
+		KeyExchanges:      list("ssh-kex"),
+		MACs:              list("ssh-macs"),
+		HostKeyAlgorithms: list("ssh-host-key-algorithms"),
+	}
+}
@@ -18,10 +18,10 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
-	"github.com/FObersteiner/gosyncit/lib/compare"
 	"github.com/FObersteiner/gosyncit/lib/copy"
 	"github.com/FObersteiner/gosyncit/lib/fileset"
 	"github.com/FObersteiner/gosyncit/lib/pathlib"
+	"github.com/FObersteiner/gosyncit/lib/space"
 )
 
 var syncCmd = &cobra.Command{
@@ -48,6 +48,12 @@ Files will be copied if one is newer or doesn't exit in the destination.`,
 		ignorehidden := viper.GetBool("skiphidden")
 		setGlobalVerbose := viper.GetBool("verbose")
 		verbose = setGlobalVerbose
+		ignoreSpace = viper.GetBool("ignore-space")
+		verifyCopies = viper.GetBool("verify")
+		manifestName = viper.GetString("manifest")
+		if err := setCopyOptions(); err != nil {
+			return err
+		}
 
 		return Sync(src, dst, dry, ignorehidden)
 	},
@@ -70,6 +76,48 @@ func init() {
 		log.Fatal("error binding viper to 'skiphidden' flag:", err)
 	}
 
+	syncCmd.Flags().BoolVar(&sparse, "sparse", false, "turn blocks of zeros into holes in dst files")
+	err = viper.BindPFlag("sparse", syncCmd.Flags().Lookup("sparse"))
+	if err != nil {
+		log.Fatal("error binding viper to 'sparse' flag:", err)
+	}
+
+	syncCmd.Flags().StringVar(&reflink, "reflink", "never", "clone files instead of copying data, if the file system supports it: auto, always or never")
+	err = viper.BindPFlag("reflink", syncCmd.Flags().Lookup("reflink"))
+	if err != nil {
+		log.Fatal("error binding viper to 'reflink' flag:", err)
+	}
+
+	syncCmd.Flags().StringVar(&manifestName, "manifest", "", "update a checksum manifest with this name in dst after syncing (.json, .csv or sha256sum format)")
+	err = viper.BindPFlag("manifest", syncCmd.Flags().Lookup("manifest"))
+	if err != nil {
+		log.Fatal("error binding viper to 'manifest' flag:", err)
+	}
+
+	syncCmd.Flags().BoolVar(&verifyCopies, "verify", false, "read back copied files and compare them to the source")
+	err = viper.BindPFlag("verify", syncCmd.Flags().Lookup("verify"))
+	if err != nil {
+		log.Fatal("error binding viper to 'verify' flag:", err)
+	}
+
+	syncCmd.Flags().DurationVar(&modifyWindow, "modify-window", 0, "treat modification times as equal if they differ by no more than this (0: detect from dst)")
+	err = viper.BindPFlag("modify-window", syncCmd.Flags().Lookup("modify-window"))
+	if err != nil {
+		log.Fatal("error binding viper to 'modify-window' flag:", err)
+	}
+
+	syncCmd.Flags().BoolVar(&ignoreDSTShift, "ignore-dst-shift", false, "also treat modification times as equal if they differ by exactly one hour (FAT and DST changes)")
+	err = viper.BindPFlag("ignore-dst-shift", syncCmd.Flags().Lookup("ignore-dst-shift"))
+	if err != nil {
+		log.Fatal("error binding viper to 'ignore-dst-shift' flag:", err)
+	}
+
+	syncCmd.Flags().BoolVar(&ignoreSpace, "ignore-space", false, "copy even if the free space on the destination seems insufficient")
+	err = viper.BindPFlag("ignore-space", syncCmd.Flags().Lookup("ignore-space"))
+	if err != nil {
+		log.Fatal("error binding viper to 'ignore-space' flag:", err)
+	}
+
 	syncCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output to the command line")
 	err = viper.BindPFlag("verbose", syncCmd.Flags().Lookup("verbose"))
 	if err != nil {
@@ -86,6 +134,7 @@ func Sync(src, dst string, dry bool, skipHidden bool) error {
 
 	var nItems, nBytes uint
 	t0 := time.Now()
+	verifyFailed = nil
 
 	src, dst, err := pathlib.CheckSrcDst(src, dst)
 	if err != nil {
@@ -122,6 +171,23 @@ func Sync(src, dst string, dry bool, skipHidden bool) error {
 		}
 	}
 
+	cmpOpts := compareOptions(dry, filesetSrc.Basepath, filesetDst.Basepath)
+
+	// check free space on both sides; src files go to dst if younger, and vice versa
+	verboseprint("analyzing source...")
+	if err := filesetSrc.Populate(); err != nil {
+		return err
+	}
+	keep := keepName(skipHidden)
+	need := space.Needed(filesetSrc.Filter(keep), &filesetDst, cmpOpts.SrcYounger, false)
+	if err := checkSpace(need, filesetDst.Basepath, func() (uint64, error) { return space.Free(filesetDst.Basepath) }, dry); err != nil {
+		return err
+	}
+	need = space.Needed(filesetDst.Filter(keep), filesetSrc, cmpOpts.SrcYounger, false)
+	if err := checkSpace(need, filesetSrc.Basepath, func() (uint64, error) { return space.Free(filesetSrc.Basepath) }, dry); err != nil {
+		return err
+	}
+
 	// we also need a 'seen' map to track which files were copied from src to dst,
 	// so we can skip copying them from dst to src (as their mtime will be newer)
 	newInDst := make(map[string]struct{})
@@ -182,14 +248,14 @@ func Sync(src, dst string, dry bool, skipHidden bool) error {
 			//         no  --> skip.
 			if !filesetDst.Contains(childPath) {
 				fmt.Printf("copy file '%s'\n", srcPath)
-				return copy.CopyFile(srcPath, dstPath, srcInfo, dry)
+				return copyFile(srcPath, dstPath, srcInfo, dry)
 			}
 
 			dstInfo, _ := os.Stat(filepath.Join(filesetDst.Basepath, childPath))
-			if compare.SrcYounger(srcInfo, dstInfo) {
+			if cmpOpts.SrcYounger(srcInfo, dstInfo) {
 				fmt.Printf("overwrite file (src -> dst) '%s'\n", srcPath)
 				newInDst[childPath] = struct{}{}
-				return copy.CopyFile(srcPath, dstPath, srcInfo, dry)
+				return copyFile(srcPath, dstPath, srcInfo, dry)
 			} else {
 				verboseprintf("skip file '%s'\n", srcPath)
 			}
@@ -202,6 +268,9 @@ func Sync(src, dst string, dry bool, skipHidden bool) error {
 		return err
 	}
 
+	// the manifest describes dst; it is not copied to src
+	mf := isManifest(filesetDst.Basepath)
+
 	// STEP 2 : copy everything from dst to src if dst newer
 	err = filepath.Walk(dst,
 		func(srcPath string, srcInfo os.FileInfo, err error) error {
@@ -224,6 +293,10 @@ func Sync(src, dst string, dry bool, skipHidden bool) error {
 				return nil
 			}
 
+			if mf != nil && mf(childPath) {
+				return nil
+			}
+
 			nItems++
 			nBytes += uint(srcInfo.Size())
 
@@ -253,7 +326,7 @@ func Sync(src, dst string, dry bool, skipHidden bool) error {
 			//         no  --> skip.
 			if !filesetSrc.Contains(childPath) {
 				fmt.Printf("copy file '%s'\n", srcPath)
-				return copy.CopyFile(srcPath, dstPath, srcInfo, dry)
+				return copyFile(srcPath, dstPath, srcInfo, dry)
 			}
 			if _, ok := newInDst[childPath]; ok {
 				verboseprintf("skip new file '%s'\n", srcPath)
@@ -261,9 +334,9 @@ func Sync(src, dst string, dry bool, skipHidden bool) error {
 			}
 
 			dstInfo, _ := os.Stat(filepath.Join(filesetSrc.Basepath, childPath))
-			if compare.SrcYounger(srcInfo, dstInfo) {
+			if cmpOpts.SrcYounger(srcInfo, dstInfo) {
 				fmt.Printf("overwrite file (dst -> src) '%s'\n", srcPath)
-				return copy.CopyFile(srcPath, dstPath, srcInfo, dry)
+				return copyFile(srcPath, dstPath, srcInfo, dry)
 			} else {
 				verboseprintf("skip file '%s'\n", srcPath)
 			}
@@ -276,11 +349,17 @@ func Sync(src, dst string, dry bool, skipHidden bool) error {
 		return err
 	}
 
+	if manifestName != "" {
+		if err := writeManifest(dst, nil, dry); err != nil {
+			return err
+		}
+	}
+
 	dt := time.Since(t0)
 	fmt.Printf("\n~~~ SYNC done ~~~\n%v items, %v, in %v\n~~~\n",
 		nItems,
 		copy.ByteCount(nBytes),
 		dt,
 	)
-	return nil
+	return verifySummary()
 }
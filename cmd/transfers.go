@@ -0,0 +1,79 @@
+/*
+Copyright © 2023 Florian Obersteiner <f.obersteiner@posteo.de>
+
+License: see LICENSE in the root directory of the repo.
+*/
+package cmd
+
+import (
+	"sync"
+
+	"github.com/pkg/sftp"
+)
+
+// transfers runs file transfers in the background, one worker per SFTP client,
+// so that several files are transferred at once if there are several clients.
+// The first error stops further transfers; it is returned by run, wait and close.
+type transfers struct {
+	jobs    chan func(sc *sftp.Client) error
+	pending sync.WaitGroup // jobs not done yet
+	workers sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+// newTransfers starts a worker for each client. Call close when done.
+func newTransfers(clients []*sftp.Client) *transfers {
+	t := &transfers{jobs: make(chan func(sc *sftp.Client) error)}
+	for _, sc := range clients {
+		t.workers.Add(1)
+		go func(sc *sftp.Client) {
+			defer t.workers.Done()
+			for job := range t.jobs {
+				if t.failed() == nil {
+					if err := job(sc); err != nil {
+						t.mu.Lock()
+						if t.err == nil {
+							t.err = err
+						}
+						t.mu.Unlock()
+					}
+				}
+				t.pending.Done()
+			}
+		}(sc)
+	}
+	return t
+}
+
+// failed returns the first error of a transfer
+func (t *transfers) failed() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// run 'job' on the next free client
+func (t *transfers) run(job func(sc *sftp.Client) error) error {
+	if err := t.failed(); err != nil {
+		return err
+	}
+	t.pending.Add(1)
+	t.jobs <- job
+	return nil
+}
+
+// wait until all transfers started so far are done
+func (t *transfers) wait() error {
+	t.pending.Wait()
+	return t.failed()
+}
+
+// close waits for all transfers and stops the workers
+func (t *transfers) close() error {
+	err := t.wait()
+	close(t.jobs)
+	t.workers.Wait()
+	return err
+}
@@ -0,0 +1,170 @@
+/*
+Copyright © 2023 Florian Obersteiner <f.obersteiner@posteo.de>
+
+License: see LICENSE in the root directory of the repo.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/FObersteiner/gosyncit/lib/libsftp"
+	"github.com/FObersteiner/gosyncit/lib/trash"
+)
+
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "list, restore or empty files moved to the trash by mirror --trash",
+	Long: `Inspect the trash that 'mirror --trash' and 'sftpmirror --trash' move deleted files to.
+Locally, this is the XDG trash of the user (see --trash-dir to use another one); for a
+destination on another file system, '.Trash-$uid' in the top directory of that file system.
+On an SFTP server, specify the trash directory with --trash-dir.
+Items that other programs put in the same trash are listed, but never expired or emptied.`,
+}
+
+var trashListCmd = &cobra.Command{
+	Use:          "list",
+	Short:        "list the items in the trash",
+	SilenceUsage: true,
+	Args:         cobra.NoArgs,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		return withTrash(func(tr *trash.Trash) error {
+			items, err := tr.List()
+			if err != nil {
+				return err
+			}
+			for _, item := range items {
+				fmt.Printf("%s  %s  (%s)\n", item.Deleted.Format(time.DateTime), item.Name, item.Path)
+			}
+			return nil
+		})
+	},
+}
+
+var trashRestoreCmd = &cobra.Command{
+	Use:          "restore 'name' ...",
+	Short:        "move items from the trash back to their original path",
+	SilenceUsage: true,
+	Args:         cobra.MinimumNArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		return withTrash(func(tr *trash.Trash) error {
+			for _, name := range args {
+				if err := tr.Restore(name); err != nil {
+					return err
+				}
+				fmt.Printf("restored '%s'\n", name)
+			}
+			return nil
+		})
+	},
+}
+
+var trashEmptyCmd = &cobra.Command{
+	Use:          "empty",
+	Short:        "permanently remove all items gosyncit put in the trash",
+	SilenceUsage: true,
+	Args:         cobra.NoArgs,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		return withTrash(func(tr *trash.Trash) error {
+			return tr.Empty()
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(trashCmd)
+	trashCmd.AddCommand(trashListCmd, trashRestoreCmd, trashEmptyCmd)
+
+	trashCmd.PersistentFlags().SortFlags = false
+
+	trashCmd.PersistentFlags().StringVar(&trashDir, "trash-dir", "", "trash directory (default: local XDG trash)")
+	err := viper.BindPFlag("trash-dir", trashCmd.PersistentFlags().Lookup("trash-dir"))
+	if err != nil {
+		log.Fatal("error binding viper to 'trash-dir' flag:", err)
+	}
+
+	trashCmd.PersistentFlags().StringVar(&remoteURL, "remote-url", "", "SFTP server the trash directory is on")
+	err = viper.BindPFlag("remote-url", trashCmd.PersistentFlags().Lookup("remote-url"))
+	if err != nil {
+		log.Fatal("error binding viper to 'remote-url' flag:", err)
+	}
+
+	trashCmd.PersistentFlags().StringVar(&username, "username", "", "username on the SFTP server")
+	err = viper.BindPFlag("username", trashCmd.PersistentFlags().Lookup("username"))
+	if err != nil {
+		log.Fatal("error binding viper to 'username' flag:", err)
+	}
+
+	trashCmd.PersistentFlags().IntVarP(&port, "port", "p", 22, "ssh port number")
+	err = viper.BindPFlag("port", trashCmd.PersistentFlags().Lookup("port"))
+	if err != nil {
+		log.Fatal("error binding viper to 'port' flag:", err)
+	}
+
+	addSSHFlags(trashCmd.PersistentFlags())
+}
+
+// withTrash calls f with the trash specified by the trash command flags
+func withTrash(f func(tr *trash.Trash) error) error {
+	dir := viper.GetString("trash-dir")
+	remoteURL = viper.GetString("remote-url")
+	username = viper.GetString("username")
+
+	url := remoteURL
+	if url == "" {
+		if dir != "" {
+			return f(trash.Local(dir))
+		}
+		tr, err := trash.Home()
+		if err != nil {
+			return err
+		}
+		return f(tr)
+	}
+
+	if dir == "" {
+		return errors.New("remote trash requires --trash-dir")
+	}
+	sshFlags = trashCmd.PersistentFlags()
+	creds := libsftp.Credentials{
+		Usr:       username,
+		Host:      url,
+		AgentSock: "SSH_AUTH_SOCK",
+		Port:      viper.GetInt("port"),
+		SSH:       sshOptions(url),
+	}
+	sshcon, err := libsftp.GetSSHconn(creds)
+	if err != nil {
+		return err
+	}
+	defer sshcon.Close()
+
+	sc, err := sftp.NewClient(sshcon)
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+
+	return f(trash.Sftp(sc, dir))
+}
+
+// expireTrash removes items older than trashMaxAge from the trash
+func expireTrash(tr *trash.Trash, dry bool) {
+	if trashMaxAge <= 0 || dry {
+		return
+	}
+	expired, err := tr.Expire(trashMaxAge, time.Now())
+	if err != nil {
+		fmt.Println("failed to expire trash:", err)
+	}
+	for _, item := range expired {
+		verboseprintf("expired from trash: '%s'\n", item.Path)
+	}
+}
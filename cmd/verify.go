@@ -0,0 +1,62 @@
+/*
+Copyright © 2023 Florian Obersteiner <f.obersteiner@posteo.de>
+
+License: see LICENSE in the root directory of the repo.
+*/
+package cmd
+
+import (
+	"fmt"
+	"sync"
+)
+
+// verifyRetries is the number of times a copy is repeated if verification fails
+const verifyRetries = 2
+
+// verifyFailed collects the files that still differed from their source after all retries
+var (
+	verifyFailed   []string
+	verifyFailedMu sync.Mutex // copies might run concurrently
+)
+
+// verified runs 'copy' and, if the verify option is set, uses 'equal' to check that the copy
+// matches its source afterwards. On a mismatch, the copy is repeated up to verifyRetries times;
+// if it still differs, 'name' is recorded in verifyFailed.
+func verified(name string, dry bool, copy func() error, equal func() (bool, error)) error {
+	if err := copy(); err != nil || dry || !verifyCopies {
+		return err
+	}
+	for i := 0; ; i++ {
+		ok, err := equal()
+		if err != nil {
+			return err
+		}
+		if ok {
+			verboseprintf("verified '%s'\n", name)
+			return nil
+		}
+		if i == verifyRetries {
+			fmt.Printf("verification failed for '%s'\n", name)
+			verifyFailedMu.Lock()
+			verifyFailed = append(verifyFailed, name)
+			verifyFailedMu.Unlock()
+			return nil
+		}
+		fmt.Printf("verification failed for '%s', copy again\n", name)
+		if err := copy(); err != nil {
+			return err
+		}
+	}
+}
+
+// verifySummary lists the files in verifyFailed and returns an error if there are any.
+func verifySummary() error {
+	if len(verifyFailed) == 0 {
+		return nil
+	}
+	fmt.Printf("\n%v file(s) differ from their source after copying %v times:\n", len(verifyFailed), verifyRetries+1)
+	for _, name := range verifyFailed {
+		fmt.Printf("  '%s'\n", name)
+	}
+	return fmt.Errorf("verification failed for %v file(s)", len(verifyFailed))
+}
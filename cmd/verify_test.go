@@ -0,0 +1,55 @@
+package cmd_test
+
+import (
+	"testing"
+
+	"github.com/FObersteiner/gosyncit/cmd"
+)
+
+func TestVerified(t *testing.T) {
+	cmd.SetVerifyCopies(true)
+	defer cmd.SetVerifyCopies(false)
+
+	// the copy stays wrong: recorded after all retries, the summary fails
+	copies := 0
+	err := cmd.Verified("bad", false,
+		func() error { copies++; return nil },
+		func() (bool, error) { return false, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copies != 3 {
+		t.Logf("expected 3 copies, have %v", copies)
+		t.Fail()
+	}
+	if failed := cmd.VerifyFailed(); len(failed) != 1 || failed[0] != "bad" {
+		t.Logf("expected 'bad' to be recorded, have %v", failed)
+		t.Fail()
+	}
+	if err := cmd.VerifySummary(); err == nil {
+		t.Log("summary must return an error after a failed verification")
+		t.Fail()
+	}
+
+	// the copy is fixed on the second try: nothing is recorded
+	cmd.SetVerifyCopies(true)
+	copies = 0
+	err = cmd.Verified("good", false,
+		func() error { copies++; return nil },
+		func() (bool, error) { return copies > 1, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copies != 2 {
+		t.Logf("expected 2 copies, have %v", copies)
+		t.Fail()
+	}
+	if failed := cmd.VerifyFailed(); len(failed) != 0 {
+		t.Logf("expected no failed verification, have %v", failed)
+		t.Fail()
+	}
+	if err := cmd.VerifySummary(); err != nil {
+		t.Logf("summary must not fail, have %v", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,313 @@
+package agent_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/FObersteiner/gosyncit/lib/agent"
+	"github.com/FObersteiner/gosyncit/lib/manifest"
+)
+
+// The test binary doubles as the remote gosyncit for TestDial: 'agent root' runs the agent.
+func TestMain(m *testing.M) {
+	if len(os.Args) == 3 && os.Args[1] == "agent" {
+		if err := agent.Serve(os.Args[2], false, os.Stdin, os.Stdout); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// peers runs an agent for 'root' in-process and returns a client connected to it by pipes
+func peers(t *testing.T, root string) *agent.Client {
+	toAgent, fromClient := io.Pipe()
+	toClient, fromAgent := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := agent.Serve(root, false, toAgent, fromAgent)
+		fromAgent.Close()
+		done <- err
+	}()
+	c, err := agent.NewClient(toClient, fromClient, fromClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		if err := <-done; err != nil {
+			t.Logf("agent: %v", err)
+			t.Fail()
+		}
+	})
+	return c
+}
+
+func writeFile(t *testing.T, name string, content []byte, mtime time.Time) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAgent(t *testing.T) {
+	local, remote := t.TempDir(), t.TempDir()
+	mtime := time.Date(2021, 2, 3, 4, 5, 6, 789000000, time.UTC)
+
+	big := make([]byte, 3<<20+123) // several chunks
+	if _, err := rand.Read(big); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(local, "big"), big, mtime)
+	writeFile(t, filepath.Join(local, "empty"), nil, mtime)
+	writeFile(t, filepath.Join(remote, "old", "file"), []byte("old"), mtime)
+
+	c := peers(t, remote)
+	if c.Root != remote {
+		t.Logf("want root '%s', got '%s'", remote, c.Root)
+		t.Fail()
+	}
+
+	set, err := c.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Paths) != 2 || !set.Contains("old") || set.Paths[filepath.Join("old", "file")].Size() != 3 {
+		t.Logf("unexpected scan result %v", set.Paths)
+		t.Fail()
+	}
+
+	if err := c.Mkdir(filepath.Join("new", "dir")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"big", "empty"} {
+		if _, err := c.Upload(filepath.Join(local, name), filepath.Join("new", "dir", name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Remove(filepath.Join("old", "file")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Remove("old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(remote, "new", "dir", "big"))
+	if err != nil || !bytes.Equal(got, big) {
+		t.Logf("uploaded file differs (%v)", err)
+		t.Fail()
+	}
+	if info, err := os.Stat(filepath.Join(remote, "new", "dir", "empty")); err != nil || info.Size() != 0 || !info.ModTime().Equal(mtime) {
+		t.Logf("empty file not uploaded with mtime: %v", err)
+		t.Fail()
+	}
+	if _, err := os.Stat(filepath.Join(remote, "old")); !errors.Is(err, os.ErrNotExist) {
+		t.Log("expected 'old' to be removed")
+		t.Fail()
+	}
+
+	// change a few bytes, only those are sent
+	big[1<<20] ^= 0xff
+	writeFile(t, filepath.Join(local, "big"), big, mtime.Add(time.Hour))
+	n, err := c.UploadDelta(filepath.Join(local, "big"), filepath.Join("new", "dir", "big"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n > 64<<10 {
+		t.Logf("delta upload sent %v bytes", n)
+		t.Fail()
+	}
+	got, err = os.ReadFile(filepath.Join(remote, "new", "dir", "big"))
+	if err != nil || !bytes.Equal(got, big) {
+		t.Logf("patched file differs (%v)", err)
+		t.Fail()
+	}
+
+	hashes, err := c.Hash([]string{filepath.Join("new", "dir", "big")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := manifest.HashFile(filepath.Join(local, "big"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashes[0] != want {
+		t.Logf("want hash %s, got %s", want, hashes[0])
+		t.Fail()
+	}
+
+	// errors are reported per file, the others are applied
+	if _, err := c.Upload(filepath.Join(local, "empty"), filepath.Join("missing", "file")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Upload(filepath.Join(local, "empty"), "file"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err == nil {
+		t.Log("expected error writing to a missing directory")
+		t.Fail()
+	}
+	if _, err := os.Stat(filepath.Join(remote, "file")); err != nil {
+		t.Log("expected the other file to be written")
+		t.Fail()
+	}
+	entries, _ := os.ReadDir(remote)
+	if len(entries) != 2 {
+		t.Logf("temporary files left: %v", entries)
+		t.Fail()
+	}
+}
+
+func TestNoAgent(t *testing.T) {
+	_, err := agent.NewClient(bytes.NewReader([]byte("sh: gosyncit: command not found\n")), io.Discard, nil)
+	if !errors.Is(err, agent.ErrNoAgent) {
+		t.Logf("want ErrNoAgent, got %v", err)
+		t.Fail()
+	}
+
+	// e.g. an SFTP server, which waits for the client
+	defer func(d time.Duration) { agent.HelloTimeout = d }(agent.HelloTimeout)
+	agent.HelloTimeout = 50 * time.Millisecond
+	r, _ := io.Pipe()
+	_, err = agent.NewClient(r, io.Discard, r)
+	if !errors.Is(err, agent.ErrNoAgent) {
+		t.Logf("want ErrNoAgent after timeout, got %v", err)
+		t.Fail()
+	}
+}
+
+// sshServer starts an SSH server on a local port that runs exec requests with 'sh -c'.
+// Returns a connected client.
+func sshServer(t *testing.T) *ssh.Client {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveExec(nc, config)
+		}
+	}()
+
+	conn, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func serveExec(nc net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(nc, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newCh := range chans {
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			for req := range chReqs {
+				if req.Type != "exec" {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Value string }
+				_ = ssh.Unmarshal(req.Payload, &payload)
+				_ = req.Reply(true, nil)
+				cmd := exec.Command("sh", "-c", payload.Value)
+				cmd.Stdout, cmd.Stderr = ch, ch.Stderr()
+				// don't wait for the client to close stdin if the command exits early
+				stdin, err := cmd.StdinPipe()
+				if err != nil {
+					return
+				}
+				go func() {
+					_, _ = io.Copy(stdin, ch)
+					stdin.Close()
+				}()
+				status := 0
+				if err := cmd.Run(); err != nil {
+					status = 127
+				}
+				_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+				return
+			}
+		}()
+	}
+}
+
+func TestDial(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell")
+	}
+	conn := sshServer(t)
+	remote := t.TempDir()
+
+	_, err := agent.Dial(conn, "gosyncit-not-installed", remote, false)
+	if !errors.Is(err, agent.ErrNoAgent) {
+		t.Logf("want ErrNoAgent, got %v", err)
+		t.Fail()
+	}
+
+	c, err := agent.Dial(conn, os.Args[0], remote, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(t.TempDir(), "file")
+	writeFile(t, local, []byte("content"), time.Now())
+	if _, err := c.Upload(local, "file"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(remote, "file")); err != nil || string(got) != "content" {
+		t.Logf("want 'content', got '%s' (%v)", got, err)
+		t.Fail()
+	}
+}
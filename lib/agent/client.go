@@ -0,0 +1,356 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/FObersteiner/gosyncit/lib/delta"
+	"github.com/FObersteiner/gosyncit/lib/fileset"
+)
+
+// ErrNoAgent is returned by Dial and NewClient if the other end does not speak the protocol,
+// e.g. because gosyncit is not installed on the remote host.
+var ErrNoAgent = errors.New("no gosyncit agent")
+
+// HelloTimeout limits the time NewClient waits for the hello of an agent, e.g. if the
+// account only allows SFTP and the server waits for the client to speak first.
+var HelloTimeout = 30 * time.Second
+
+// Client of an agent. Operations are queued and sent in batches; errors of queued
+// operations are returned by the call that sends them, or by Flush.
+type Client struct {
+	Root       string        // absolute path of the root on the agent's host
+	Resolution time.Duration // of the timestamps on the agent's file system
+	Free       uint64        // bytes available on the agent's file system, 0 if unknown
+	Perms      bool          // set the permission bits of uploaded files from the local files
+
+	w      *bufio.Writer
+	enc    *gob.Encoder
+	dec    *gob.Decoder
+	closer io.Closer
+	ops    []Op
+	size   int
+}
+
+// Dial starts 'command agent root' on the host of 'conn', e.g. with command 'gosyncit',
+// and returns a client connected to its stdin and stdout. If dry is true, the agent is
+// started with --dryrun, see Serve.
+func Dial(conn *ssh.Client, command, root string, dry bool) (*Client, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	args := " agent "
+	if dry {
+		args += "--dryrun "
+	}
+	if err := session.Start(command + args + quote(root)); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("%w: %v", ErrNoAgent, err)
+	}
+	c, err := NewClient(stdout, stdin, session)
+	if err != nil {
+		session.Close()
+		_ = session.Wait() // stderr is complete
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w (%s)", err, msg)
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient reads the hello of an agent from 'r' and returns a client that sends requests
+// to 'w'. Close closes 'closer', if it is not nil. If there is no hello within HelloTimeout,
+// 'closer' is closed and ErrNoAgent returned.
+func NewClient(r io.Reader, w io.Writer, closer io.Closer) (*Client, error) {
+	bw := bufio.NewWriter(w)
+	c := &Client{w: bw, enc: gob.NewEncoder(bw), dec: gob.NewDecoder(bufio.NewReader(r)), closer: closer}
+	var h hello
+	done := make(chan error, 1)
+	go func() { done <- c.dec.Decode(&h) }()
+	timer := time.NewTimer(HelloTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNoAgent, err)
+		}
+	case <-timer.C:
+		if closer != nil {
+			closer.Close() // ends the decode
+		}
+		return nil, fmt.Errorf("%w: no answer within %v", ErrNoAgent, HelloTimeout)
+	}
+	if h.Version != Version {
+		return nil, fmt.Errorf("%w: protocol version %v, want %v", ErrNoAgent, h.Version, Version)
+	}
+	if h.Err != "" {
+		return nil, errors.New(h.Err)
+	}
+	c.Root, c.Resolution, c.Free = h.Root, h.Resolution, h.Free
+	return c, nil
+}
+
+func (c *Client) call(req request) (response, error) {
+	var resp response
+	if err := c.enc.Encode(req); err != nil {
+		return resp, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return resp, err
+	}
+	if err := c.dec.Decode(&resp); err != nil {
+		return resp, err
+	}
+	if resp.Err != "" {
+		return resp, errors.New(resp.Err)
+	}
+	return resp, nil
+}
+
+// Scan returns the files and directories below the root. The names in Paths use the
+// path separator of the local OS, like fileset.Populate.
+func (c *Client) Scan() (*fileset.Fileset, error) {
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	set := &fileset.Fileset{Basepath: c.Root + "/", Paths: make(map[string]os.FileInfo)}
+	resp, err := c.call(request{Kind: reqScan})
+	for {
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range resp.Entries {
+			set.Paths[filepath.FromSlash(e.Name)] = fileInfo{e}
+		}
+		if !resp.More {
+			return set, nil
+		}
+		resp = response{}
+		if err = c.dec.Decode(&resp); err == nil && resp.Err != "" {
+			err = errors.New(resp.Err)
+		}
+	}
+}
+
+// Hash returns the hex SHA-256 of files 'names', relative to the root, computed by the agent.
+// See manifest.HashFile for the local counterpart.
+func (c *Client) Hash(names []string) ([]string, error) {
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	resp, err := c.call(request{Kind: reqHash, Names: slashed(names)})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Hashes, opErrors(names, resp.Errs)
+}
+
+// Mkdir queues the creation of directory 'name' and its parents
+func (c *Client) Mkdir(name string) error {
+	return c.queue(Op{Kind: OpMkdir, Name: filepath.ToSlash(name)})
+}
+
+// Remove queues the removal of file or empty directory 'name'
+func (c *Client) Remove(name string) error {
+	return c.queue(Op{Kind: OpRemove, Name: filepath.ToSlash(name)})
+}
+
+// Upload queues local file 'local' to be written to 'name', and returns its size
+func (c *Client) Upload(local, name string) (int64, error) {
+	f, err := os.Open(local)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	op := c.fileOp(OpWrite, name, info)
+	buf := make([]byte, chunkSize)
+	var n int64
+	for {
+		m, err := io.ReadFull(f, buf)
+		n += int64(m)
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			op.Data, op.Last = buf[:m], true
+			return n, c.queue(op)
+		}
+		if err != nil {
+			return n, err
+		}
+		op.Data = buf[:m]
+		if err := c.queue(op); err != nil {
+			return n, err
+		}
+		op.First = false
+	}
+}
+
+// UploadDelta updates file 'name' to the content of local file 'local', only sending the
+// parts that changed, and returns the number of bytes sent. The agent computes the
+// signature of 'name', which takes a round trip.
+func (c *Client) UploadDelta(local, name string) (int64, error) {
+	if err := c.Flush(); err != nil {
+		return 0, err
+	}
+	resp, err := c.call(request{Kind: reqSign, Names: []string{filepath.ToSlash(name)}})
+	if err != nil {
+		return 0, err
+	}
+	if err := opErrors([]string{name}, resp.Errs); err != nil {
+		return 0, err
+	}
+	sig := &resp.Sigs[0]
+
+	f, err := os.Open(local)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	op := c.fileOp(OpPatch, name, info)
+	var n int64
+	var size int
+	send := func() error {
+		if err := c.queue(op); err != nil {
+			return err
+		}
+		op.First, op.Delta, size = false, nil, 0
+		return nil
+	}
+	err = delta.Diff(sig, bufio.NewReader(f), func(d delta.Op) error {
+		// Data is only valid during the call
+		d.Data = append([]byte(nil), d.Data...)
+		op.Delta = append(op.Delta, d)
+		n += int64(len(d.Data))
+		if size += len(d.Data) + 8; size >= chunkSize {
+			return send()
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	op.Last = true
+	return n, send()
+}
+
+func (c *Client) fileOp(kind, name string, info os.FileInfo) Op {
+	op := Op{Kind: kind, Name: filepath.ToSlash(name), First: true, Mtime: info.ModTime()}
+	if c.Perms {
+		op.Mode = info.Mode().Perm()
+	}
+	return op
+}
+
+// queue adds 'op' to the batch, and sends the batch if it is full
+func (c *Client) queue(op Op) error {
+	if op.Data != nil {
+		// the buffer is reused by the caller
+		op.Data = append([]byte(nil), op.Data...)
+	}
+	c.ops = append(c.ops, op)
+	c.size += len(op.Data)
+	for _, d := range op.Delta {
+		c.size += len(d.Data)
+	}
+	if c.size >= batchSize || len(c.ops) >= batchOps {
+		return c.Flush()
+	}
+	return nil
+}
+
+// Flush sends the queued operations and returns the errors of those that failed
+func (c *Client) Flush() error {
+	if len(c.ops) == 0 {
+		return nil
+	}
+	ops := c.ops
+	c.ops, c.size = nil, 0
+	resp, err := c.call(request{Kind: reqBatch, Ops: ops})
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(ops))
+	for i, op := range ops {
+		names[i] = op.Name
+	}
+	return opErrors(names, resp.Errs)
+}
+
+// Close sends the queued operations and ends the agent
+func (c *Client) Close() error {
+	err := c.Flush()
+	if e := c.enc.Encode(request{Kind: reqQuit}); e == nil {
+		_ = c.w.Flush()
+	}
+	if c.closer != nil {
+		c.closer.Close()
+	}
+	return err
+}
+
+func opErrors(names, errs []string) error {
+	var err error
+	for i, e := range errs {
+		if e != "" {
+			err = errors.Join(err, fmt.Errorf("'%s': %s", names[i], e))
+		}
+	}
+	return err
+}
+
+func slashed(names []string) []string {
+	s := make([]string, len(names))
+	for i, name := range names {
+		s[i] = filepath.ToSlash(name)
+	}
+	return s
+}
+
+// quote a string for the remote shell
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// fileInfo of an Entry
+type fileInfo struct{ e Entry }
+
+func (fi fileInfo) Name() string       { return path.Base(fi.e.Name) }
+func (fi fileInfo) Size() int64        { return fi.e.Size }
+func (fi fileInfo) Mode() fs.FileMode  { return fi.e.Mode }
+func (fi fileInfo) ModTime() time.Time { return fi.e.Mtime }
+func (fi fileInfo) IsDir() bool        { return fi.e.Mode.IsDir() }
+func (fi fileInfo) Sys() any           { return nil }
@@ -0,0 +1,86 @@
+// Package agent implements the native protocol between two gosyncit instances. One of them
+// runs 'gosyncit agent' on the remote host, with stdin and stdout connected to the other one
+// via SSH. The agent scans and hashes its files locally and applies operations in batches,
+// so a transfer needs a few round trips instead of several per file as with SFTP.
+//
+// Messages are encoded with encoding/gob. The agent starts with a hello; then the client
+// sends requests, each answered by one response (or several for a scan, see response.More).
+package agent
+
+import (
+	"io/fs"
+	"time"
+
+	"github.com/FObersteiner/gosyncit/lib/delta"
+)
+
+// Version of the protocol; client and agent must use the same
+const Version = 1
+
+const (
+	batchSize = 4 << 20 // send a batch of operations once it holds this much data
+	batchOps  = 1024    // ... or this many operations
+	chunkSize = 1 << 20 // file content per operation
+	scanBatch = 1000    // entries per response to a scan
+)
+
+// Kinds of operations in a batch
+const (
+	OpMkdir  = "mkdir"  // create directory Name and its parents
+	OpWrite  = "write"  // write a chunk of the content of file Name
+	OpPatch  = "patch"  // apply a chunk of delta ops to file Name; the signature must be requested before
+	OpRemove = "remove" // remove file or empty directory Name
+)
+
+// Entry is a file or directory in the tree of the agent
+type Entry struct {
+	Name  string // relative to the root, with forward slashes
+	Size  int64
+	Mode  fs.FileMode
+	Mtime time.Time
+}
+
+// Op is an operation on the tree of the agent. The content of a file is sent in chunks;
+// it is written to a temporary file which replaces file Name with the last chunk.
+type Op struct {
+	Kind        string
+	Name        string      // relative to the root, with forward slashes
+	Data        []byte      // OpWrite
+	Delta       []delta.Op  // OpPatch
+	First, Last bool        // first and last chunk of a file
+	Mtime       time.Time   // set with the last chunk
+	Mode        fs.FileMode // permission bits, set with the last chunk if not zero
+}
+
+// hello is sent by the agent when it starts
+type hello struct {
+	Version    int
+	Root       string        // absolute path of the root
+	Resolution time.Duration // of the timestamps on the file system of the root
+	Free       uint64        // bytes available on the file system of the root, 0 if unknown
+	Err        string        // the root cannot be served
+}
+
+// request kinds
+const (
+	reqScan  = "scan"
+	reqHash  = "hash"
+	reqSign  = "sign"
+	reqBatch = "batch"
+	reqQuit  = "quit"
+)
+
+type request struct {
+	Kind  string
+	Names []string // hash, sign
+	Ops   []Op     // batch
+}
+
+type response struct {
+	Err     string            // the request failed as a whole
+	Entries []Entry           // scan
+	More    bool              // scan: more responses with entries follow
+	Hashes  []string          // hash: hex SHA-256 per name
+	Sigs    []delta.Signature // sign: per name
+	Errs    []string          // hash, sign, batch: per name or op, empty on success
+}
@@ -0,0 +1,320 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/FObersteiner/gosyncit/lib/compare"
+	"github.com/FObersteiner/gosyncit/lib/delta"
+	"github.com/FObersteiner/gosyncit/lib/manifest"
+	"github.com/FObersteiner/gosyncit/lib/space"
+)
+
+// server is the state of an agent
+type server struct {
+	root    string
+	sigs    map[string]*delta.Signature // requested by the client, for patches
+	pending map[string]*pending         // files being written
+	failed  map[string]bool             // files with a failed chunk; their other chunks are skipped
+}
+
+// pending is a file that is being written to a temporary file
+type pending struct {
+	tmp  *os.File
+	base *os.File // old version, for patches
+	sig  *delta.Signature
+}
+
+// Serve runs the agent for directory 'root', reading requests from 'r' and writing responses
+// to 'w', until the client quits or closes the connection. If dry is true, nothing is written
+// below 'root': the timestamp resolution is not probed and batches are rejected.
+func Serve(root string, dry bool, r io.Reader, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := gob.NewEncoder(bw)
+	dec := gob.NewDecoder(bufio.NewReader(r))
+	send := func(v any) error {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+
+	h := hello{Version: Version}
+	root, err := filepath.Abs(root)
+	if err == nil {
+		var info os.FileInfo
+		if info, err = os.Stat(root); err == nil && !info.IsDir() {
+			err = fmt.Errorf("'%s' is not a directory", root)
+		}
+	}
+	if err != nil {
+		h.Err = err.Error()
+		return errors.Join(err, send(h))
+	}
+	h.Root = root
+	if !dry {
+		if res, err := compare.ProbeResolution(root); err == nil {
+			h.Resolution = res
+		}
+	}
+	h.Free, _ = space.Free(root)
+	if err := send(h); err != nil {
+		return err
+	}
+
+	s := &server{
+		root:    root,
+		sigs:    make(map[string]*delta.Signature),
+		pending: make(map[string]*pending),
+		failed:  make(map[string]bool),
+	}
+	defer s.abort()
+
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		switch req.Kind {
+		case reqScan:
+			err = s.scan(send)
+		case reqHash:
+			err = send(s.hash(req.Names))
+		case reqSign:
+			err = send(s.sign(req.Names))
+		case reqBatch:
+			if dry {
+				err = send(response{Err: "dry run, nothing is written"})
+				break
+			}
+			err = send(s.batch(req.Ops))
+		case reqQuit:
+			return nil
+		default:
+			err = send(response{Err: fmt.Sprintf("unknown request %q", req.Kind)})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// path returns the local path of 'name', which cannot be outside the root
+func (s *server) path(name string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// scan sends the entries below the root, in batches
+func (s *server) scan(send func(v any) error) error {
+	var entries []Entry
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == s.root {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, Entry{Name: filepath.ToSlash(rel), Size: info.Size(), Mode: info.Mode(), Mtime: info.ModTime()})
+		if len(entries) == scanBatch {
+			if err := send(response{Entries: entries, More: true}); err != nil {
+				return err
+			}
+			entries = nil
+		}
+		return nil
+	})
+	if err != nil {
+		return send(response{Err: err.Error()})
+	}
+	return send(response{Entries: entries})
+}
+
+func (s *server) hash(names []string) response {
+	resp := response{Hashes: make([]string, len(names)), Errs: make([]string, len(names))}
+	for i, name := range names {
+		sum, err := manifest.HashFile(s.path(name))
+		if err != nil {
+			resp.Errs[i] = err.Error()
+			continue
+		}
+		resp.Hashes[i] = sum
+	}
+	return resp
+}
+
+func (s *server) sign(names []string) response {
+	resp := response{Sigs: make([]delta.Signature, len(names)), Errs: make([]string, len(names))}
+	for i, name := range names {
+		sig, err := signFile(s.path(name))
+		if err != nil {
+			resp.Errs[i] = err.Error()
+			continue
+		}
+		s.sigs[name] = sig
+		resp.Sigs[i] = *sig
+	}
+	return resp
+}
+
+func signFile(name string) (*delta.Signature, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return delta.Sign(bufio.NewReader(f), delta.DefaultBlockSize)
+}
+
+func (s *server) batch(ops []Op) response {
+	resp := response{Errs: make([]string, len(ops))}
+	for i, op := range ops {
+		if op.First {
+			delete(s.failed, op.Name)
+		} else if s.failed[op.Name] {
+			continue
+		}
+		if err := s.apply(op); err != nil {
+			resp.Errs[i] = err.Error()
+			if op.Kind == OpWrite || op.Kind == OpPatch {
+				s.failed[op.Name] = true
+				s.discard(op.Name)
+			}
+		}
+	}
+	return resp
+}
+
+func (s *server) apply(op Op) error {
+	name := s.path(op.Name)
+	switch op.Kind {
+	case OpMkdir:
+		return os.MkdirAll(name, 0755)
+	case OpRemove:
+		if name == s.root {
+			return errors.New("cannot remove the root")
+		}
+		return os.Remove(name)
+	case OpWrite, OpPatch:
+		p, err := s.open(op, name)
+		if err != nil {
+			return err
+		}
+		if op.Kind == OpWrite {
+			_, err = p.tmp.Write(op.Data)
+		} else {
+			patch := delta.Patch(p.base, p.sig, p.tmp)
+			for _, d := range op.Delta {
+				if err = patch(d); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil || !op.Last {
+			return err
+		}
+		return s.finish(op, name, p)
+	}
+	return fmt.Errorf("unknown operation %q", op.Kind)
+}
+
+// open returns the pending file for 'op', creating it with the first chunk
+func (s *server) open(op Op, name string) (*pending, error) {
+	if !op.First {
+		p, ok := s.pending[op.Name]
+		if !ok {
+			return nil, errors.New("no transfer in progress")
+		}
+		return p, nil
+	}
+	s.discard(op.Name)
+	p := &pending{}
+	if op.Kind == OpPatch {
+		if p.sig = s.sigs[op.Name]; p.sig == nil {
+			return nil, errors.New("no signature requested")
+		}
+		base, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		p.base = base
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.gosyncit")
+	if err != nil {
+		if p.base != nil {
+			p.base.Close()
+		}
+		return nil, err
+	}
+	p.tmp = tmp
+	s.pending[op.Name] = p
+	return p, nil
+}
+
+// finish sets the attributes of the temporary file of 'p' and moves it to 'name'
+func (s *server) finish(op Op, name string, p *pending) error {
+	delete(s.pending, op.Name)
+	delete(s.sigs, op.Name)
+	mode := op.Mode.Perm()
+	if p.base != nil {
+		if info, err := p.base.Stat(); err == nil && mode == 0 {
+			mode = info.Mode().Perm() // a patched file keeps its permissions
+		}
+		p.base.Close()
+	}
+	if mode == 0 {
+		mode = 0644
+	}
+	err := p.tmp.Close()
+	if err == nil {
+		err = os.Chmod(p.tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Chtimes(p.tmp.Name(), op.Mtime, op.Mtime)
+	}
+	if err == nil {
+		err = os.Rename(p.tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(p.tmp.Name())
+	}
+	return err
+}
+
+// discard removes the temporary file of a pending file 'name'
+func (s *server) discard(name string) {
+	p, ok := s.pending[name]
+	if !ok {
+		return
+	}
+	delete(s.pending, name)
+	if p.base != nil {
+		p.base.Close()
+	}
+	p.tmp.Close()
+	os.Remove(p.tmp.Name())
+}
+
+// abort discards all pending files, if the client went away during a transfer
+func (s *server) abort() {
+	for name := range s.pending {
+		s.discard(name)
+	}
+}
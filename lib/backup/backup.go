@@ -0,0 +1,166 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"github.com/FObersteiner/gosyncit/lib/copy"
+	"github.com/FObersteiner/gosyncit/lib/libsftp"
+)
+
+// TimeFormat is the layout used to name the timestamped backup tree
+const TimeFormat = "2006-01-02T15-04-05"
+
+// Backup moves files that are about to be overwritten or deleted into a timestamped
+// backup tree, preserving their path relative to Basepath.
+// A nil *Backup is valid and does nothing.
+type Backup struct {
+	Dir      string // root of the backup tree, e.g. 'dst/.backup'
+	Suffix   string // appended to the name of each backed-up file
+	Basepath string // base directory the relative paths are derived from
+	stamp    string
+}
+
+// New returns a Backup for files below basepath. A relative dir is taken relative to basepath.
+// The timestamp of the backup tree is fixed at the time New is called, so that all files
+// of one run end up in the same tree.
+func New(dir, suffix, basepath string) *Backup {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(basepath, dir)
+	}
+	return &Backup{
+		Dir:      filepath.Clean(dir),
+		Suffix:   suffix,
+		Basepath: basepath,
+		stamp:    time.Now().Format(TimeFormat),
+	}
+}
+
+// Path returns the location 'file' will be moved to.
+func (b *Backup) Path(file string) (string, error) {
+	rel, err := filepath.Rel(b.Basepath, file)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("'%s' is not below '%s'", file, b.Basepath)
+	}
+	return filepath.Join(b.Dir, b.stamp, rel) + b.Suffix, nil
+}
+
+// Contains returns true if 'file' is the backup directory or is located within it.
+// Callers use this to keep the backup tree out of the mirror clean step.
+func (b *Backup) Contains(file string) bool {
+	if b == nil {
+		return false
+	}
+	rel, err := filepath.Rel(b.Dir, file)
+	return err == nil && !strings.HasPrefix(rel, "..")
+}
+
+// Save moves 'file' into the backup tree. It is a no-op if b is nil, dry is true or
+// 'file' does not exist.
+func (b *Backup) Save(file string, dry bool) error {
+	if b == nil || dry {
+		return nil
+	}
+	if _, err := os.Lstat(file); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	target, err := b.Path(file)
+	if err != nil {
+		return err
+	}
+	if err := copy.CreateDir(filepath.Dir(target), false); err != nil {
+		return err
+	}
+	if err := copy.Move(file, target); err != nil {
+		return fmt.Errorf("failed to back up '%s': %v", file, err)
+	}
+	return nil
+}
+
+// Copy copies 'file' into the backup tree, leaving it in place, e.g. so that it can be updated
+// with a delta copy. Other than regular files are moved, see Save. It is a no-op if b is nil,
+// dry is true or 'file' does not exist.
+func (b *Backup) Copy(file string, dry bool) error {
+	if b == nil || dry {
+		return nil
+	}
+	info, err := os.Lstat(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return b.Save(file, dry)
+	}
+	target, err := b.Path(file)
+	if err != nil {
+		return err
+	}
+	if err := copy.CreateDir(filepath.Dir(target), false); err != nil {
+		return err
+	}
+	if err := copy.CopyFileWith(file, target, info, false, copy.Options{Reflink: copy.ReflinkAuto}); err != nil {
+		return fmt.Errorf("failed to back up '%s': %v", file, err)
+	}
+	return copy.CopyPerm(file, target)
+}
+
+// SftpSave moves 'file' on the SFTP server into the backup tree on the same server.
+// It is a no-op if b is nil, dry is true or 'file' does not exist.
+func (b *Backup) SftpSave(sc *sftp.Client, file string, dry bool) error {
+	if b == nil || dry {
+		return nil
+	}
+	if _, err := sc.Lstat(file); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	target, err := b.Path(file)
+	if err != nil {
+		return err
+	}
+	target = filepath.ToSlash(target)
+	if err := sc.MkdirAll(path.Dir(target)); err != nil {
+		return err
+	}
+	return libsftp.Rename(sc, file, target)
+}
+
+// SftpCopy copies 'file' on the SFTP server into the backup tree on the same server, leaving
+// it in place, see Copy. The content passes through the client, since SFTP cannot copy files
+// on the server. It is a no-op if b is nil, dry is true or 'file' does not exist.
+func (b *Backup) SftpCopy(sc *sftp.Client, file string, dry bool) error {
+	if b == nil || dry {
+		return nil
+	}
+	info, err := sc.Lstat(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return b.SftpSave(sc, file, dry)
+	}
+	target, err := b.Path(file)
+	if err != nil {
+		return err
+	}
+	target = filepath.ToSlash(target)
+	if err := sc.MkdirAll(path.Dir(target)); err != nil {
+		return err
+	}
+	if _, err := libsftp.RelayFile(sc, sc, file, target, libsftp.Preserve{Perms: true}); err != nil {
+		return fmt.Errorf("failed to back up '%s': %v", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package backup_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/FObersteiner/gosyncit/lib/backup"
+	"github.com/FObersteiner/gosyncit/lib/internal/sftptest"
+)
+
+func TestSave(t *testing.T) {
+	dst := t.TempDir()
+
+	file := filepath.Join(dst, "sub", "file")
+	_ = os.MkdirAll(filepath.Dir(file), 0755)
+	if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var none *backup.Backup
+	if err := none.Save(file, false); err != nil {
+		t.Fatal(err)
+	}
+
+	bk := backup.New(".backup", "~", dst)
+	if bk.Dir != filepath.Join(dst, ".backup") {
+		t.Fatalf("relative backup dir must be relative to basepath, got '%s'", bk.Dir)
+	}
+	want, err := bk.Path(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bk.Save(file, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Fatal("dry run must not move the file")
+	}
+
+	if err := bk.Save(file, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Log("file must have been moved to backup tree")
+		t.Fail()
+	}
+	content, err := os.ReadFile(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "content" {
+		t.Logf("unexpected backup content %q", content)
+		t.Fail()
+	}
+	if !bk.Contains(want) || bk.Contains(file) {
+		t.Log("Contains must only be true within the backup tree")
+		t.Fail()
+	}
+
+	// saving a file that does not exist is not an error
+	if err := bk.Save(file, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSftpSave(t *testing.T) {
+	sc := sftptest.Pipe(t)
+	dst := t.TempDir()
+
+	file := filepath.Join(dst, "sub", "file")
+	_ = os.MkdirAll(filepath.Dir(file), 0755)
+	if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bk := backup.New(filepath.Join(t.TempDir(), "bak"), "", dst)
+	want, _ := bk.Path(file)
+	if err := bk.SftpSave(sc, file, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Logf("expected backup at '%s': %v", want, err)
+		t.Fail()
+	}
+}
+
+func TestCopy(t *testing.T) {
+	sc := sftptest.Pipe(t)
+	dst := t.TempDir()
+	bk := backup.New(".backup", "", dst)
+
+	for i, save := range []func(file string) error{
+		func(file string) error { return bk.Copy(file, false) },
+		func(file string) error { return bk.SftpCopy(sc, file, false) },
+	} {
+		file := filepath.Join(dst, "sub", fmt.Sprintf("file%v", i))
+		_ = os.MkdirAll(filepath.Dir(file), 0755)
+		if err := os.WriteFile(file, []byte("content"), 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := save(file); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(file); err != nil {
+			t.Log("file must be kept in place")
+			t.Fail()
+		}
+		want, _ := bk.Path(file)
+		content, err := os.ReadFile(want)
+		if err != nil || string(content) != "content" {
+			t.Logf("unexpected backup content %q (%v)", content, err)
+			t.Fail()
+		}
+		if info, err := os.Stat(want); err != nil || info.Mode().Perm() != 0640 {
+			t.Logf("backup must keep the permissions (%v)", err)
+			t.Fail()
+		}
+	}
+}
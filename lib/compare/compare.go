@@ -12,24 +12,19 @@ const (
 )
 
 var (
-	TimeGranularity = time.Microsecond // caller might want to set this
+	TimeGranularity = time.Microsecond // default; use Options to compare with other settings per run
 )
 
 // BasicUnequal returns true if source modification time is after that of dst modification time,
-// or file sizes do not match.
+// or file sizes do not match. Modification times are compared with TimeGranularity, see DefaultOptions.
 func BasicUnequal(srcInfo, dstInfo os.FileInfo) bool {
-	// only compare microsecond granularity:
-	srcMtime := srcInfo.ModTime().Truncate(TimeGranularity)
-	dstMtime := dstInfo.ModTime().Truncate(TimeGranularity)
-	return srcMtime.After(dstMtime) || (srcInfo.Size() != dstInfo.Size())
+	return DefaultOptions().BasicUnequal(srcInfo, dstInfo)
 }
 
 // SrcYounger returns true if source modification time is after that of dst modification time.
+// Modification times are compared with TimeGranularity, see DefaultOptions.
 func SrcYounger(srcInfo, dstInfo os.FileInfo) bool {
-	// only compare microsecond granularity:
-	srcMtime := srcInfo.ModTime().Truncate(TimeGranularity)
-	dstMtime := dstInfo.ModTime().Truncate(TimeGranularity)
-	return srcMtime.After(dstMtime)
+	return DefaultOptions().SrcYounger(srcInfo, dstInfo)
 }
 
 // DeepEqual returns true if two files are equal on a byte-level.
@@ -83,3 +78,55 @@ func DeepEqual(src, dst string) (bool, error) {
 	}
 	return true, nil
 }
+
+// ReaderEqual returns true if readers 'a' and 'b' return the same bytes until EOF.
+func ReaderEqual(a, b io.Reader) (bool, error) {
+	bufA := make([]byte, BUFFERSIZE)
+	bufB := make([]byte, BUFFERSIZE)
+	for {
+		nA, errA := io.ReadFull(a, bufA)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return false, errA
+		}
+		nB, errB := io.ReadFull(b, bufB)
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return false, errB
+		}
+		if !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+		if nA < len(bufA) { // both at EOF, since nA == nB
+			return true, nil
+		}
+	}
+}
+
+// Hasher returns a hash of a file's content, e.g. from a cache (see package hashcache)
+type Hasher interface {
+	Hash(path string, info os.FileInfo) (string, error)
+}
+
+// HashEqual returns true if two files have equal size and equal hashes from h.
+// File modification timestamps are ignored.
+func HashEqual(src, dst string, h Hasher) (bool, error) {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return false, err
+	}
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		return false, err
+	}
+	if srcInfo.Size() != dstInfo.Size() {
+		return false, nil
+	}
+	srcHash, err := h.Hash(src, srcInfo)
+	if err != nil {
+		return false, err
+	}
+	dstHash, err := h.Hash(dst, dstInfo)
+	if err != nil {
+		return false, err
+	}
+	return srcHash == dstHash, nil
+}
@@ -3,7 +3,9 @@ package compare_test
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/FObersteiner/gosyncit/lib/compare"
 )
@@ -77,3 +79,77 @@ func TestDeep(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestReaderEqual(t *testing.T) {
+	long := strings.Repeat("0123456789abcdef", 1000)
+	for _, tc := range []struct {
+		a, b string
+		want bool
+	}{
+		{"", "", true},
+		{"abc", "abc", true},
+		{long, long, true},
+		{"abc", "abd", false},
+		{"abc", "abcd", false},
+		{long, long + "x", false},
+		{long + "x", long, false},
+	} {
+		have, err := compare.ReaderEqual(strings.NewReader(tc.a), iotest.OneByteReader(strings.NewReader(tc.b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have != tc.want {
+			t.Logf("ReaderEqual(len %v, len %v): want %v, have %v", len(tc.a), len(tc.b), tc.want, have)
+			t.Fail()
+		}
+	}
+}
+
+// contentHasher "hashes" a file by returning its content
+type contentHasher struct{ calls int }
+
+func (h *contentHasher) Hash(path string, _ os.FileInfo) (string, error) {
+	h.calls++
+	b, err := os.ReadFile(path)
+	return string(b), err
+}
+
+func TestHashEqual(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dirA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	a, b, c, d := write("a", "file A"), write("b", "file B"), write("c", "file A"), write("d", "file D long")
+
+	h := &contentHasher{}
+	for _, tc := range []struct {
+		src, dst string
+		want     bool
+	}{
+		{a, b, false},
+		{a, c, true},
+		{a, d, false},
+	} {
+		equal, err := compare.HashEqual(tc.src, tc.dst, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if equal != tc.want {
+			t.Logf("'%s', '%s': want %v, have %v", tc.src, tc.dst, tc.want, equal)
+			t.Fail()
+		}
+	}
+	if h.calls != 4 {
+		t.Logf("files of different size must not be hashed; have %v calls", h.calls)
+		t.Fail()
+	}
+}
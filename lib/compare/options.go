@@ -0,0 +1,105 @@
+package compare
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Options for comparing modification times. The zero value compares with nanosecond precision.
+type Options struct {
+	// Granularity that modification times are truncated to before comparing
+	Granularity time.Duration
+	// Window is the maximum difference of modification times that are still considered equal,
+	// like rsync's --modify-window; e.g. 2s for FAT file systems.
+	Window time.Duration
+	// DST also considers modification times equal that differ by one hour (within Window).
+	// FAT file systems store local time, so their timestamps shift by one hour across
+	// daylight saving time changes.
+	DST bool
+	// Offset is how far the clock of the file system of the second (dst) time is ahead of the first,
+	// see libsftp.ClockOffset. The second time may have been set by either clock (e.g. an mtime
+	// assigned by a server, or one carried over from the source), so times are equal if they match
+	// with or without Offset, and newer only if they are with and without. Which clock set a time
+	// is not known, so a change that moved a time by about Offset is missed; e.g. with an Offset
+	// of -1m, a file modified one minute after it was copied appears unchanged.
+	Offset time.Duration
+}
+
+// DefaultOptions compares modification times truncated to TimeGranularity
+func DefaultOptions() Options {
+	return Options{Granularity: TimeGranularity}
+}
+
+// MtimeEqual returns true if modification times a and b are equal within the options
+func (o Options) MtimeEqual(a, b time.Time) bool {
+	return o.within(o.sub(a, b, 0)) || (o.Offset != 0 && o.within(o.sub(a, b, o.Offset)))
+}
+
+// Newer returns true if modification time a is after b, and not equal within the options
+func (o Options) Newer(a, b time.Time) bool {
+	return o.sub(a, b, 0) > 0 && o.sub(a, b, o.Offset) > 0 && !o.MtimeEqual(a, b)
+}
+
+// sub returns a - b, truncated to Granularity, with 'offset' subtracted from b
+func (o Options) sub(a, b time.Time, offset time.Duration) time.Duration {
+	return a.Truncate(o.Granularity).Sub(b.Add(-offset).Truncate(o.Granularity))
+}
+
+// within returns true if difference d is within Window, or one hour off if DST is set
+func (o Options) within(d time.Duration) bool {
+	d = d.Abs()
+	return d <= o.Window || (o.DST && (d-time.Hour).Abs() <= o.Window)
+}
+
+// BasicUnequal returns true if source modification time is after that of dst modification time,
+// or file sizes do not match.
+func (o Options) BasicUnequal(srcInfo, dstInfo os.FileInfo) bool {
+	return o.Newer(srcInfo.ModTime(), dstInfo.ModTime()) || (srcInfo.Size() != dstInfo.Size())
+}
+
+// SrcYounger returns true if source modification time is after that of dst modification time.
+func (o Options) SrcYounger(srcInfo, dstInfo os.FileInfo) bool {
+	return o.Newer(srcInfo.ModTime(), dstInfo.ModTime())
+}
+
+// resolutions that ProbeResolution can detect, from fine to coarse
+var resolutions = []time.Duration{
+	time.Nanosecond,
+	time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond, // exFAT
+	100 * time.Millisecond,
+	time.Second,
+	2 * time.Second, // FAT
+}
+
+// ProbeResolution returns the resolution of modification times in directory 'dir', by setting
+// the mtime of a temporary file and reading it back.
+func ProbeResolution(dir string) (time.Duration, error) {
+	f, err := os.CreateTemp(dir, ".gosyncit-probe-*")
+	if err != nil {
+		return 0, err
+	}
+	name := f.Name()
+	f.Close()
+	defer os.Remove(name)
+
+	// odd second and all digits of the fraction set, so that any rounding shows
+	want := time.Date(2006, time.January, 2, 15, 4, 5, 123456789, time.Local)
+	if err := os.Chtimes(name, want, want); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(filepath.Clean(name))
+	if err != nil {
+		return 0, err
+	}
+
+	d := info.ModTime().Sub(want).Abs()
+	for _, r := range resolutions {
+		if d < r {
+			return r, nil
+		}
+	}
+	return resolutions[len(resolutions)-1], nil
+}
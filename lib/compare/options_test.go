@@ -0,0 +1,75 @@
+package compare_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/FObersteiner/gosyncit/lib/compare"
+)
+
+func TestOptionsMtimeEqual(t *testing.T) {
+	t0 := time.Date(2006, time.January, 2, 15, 4, 5, 500_000_000, time.UTC)
+
+	for _, tc := range []struct {
+		name  string
+		opts  compare.Options
+		b     time.Time
+		equal bool
+		newer bool // t0 newer than b
+	}{
+		{"exact equal", compare.Options{}, t0, true, false},
+		{"exact ns", compare.Options{}, t0.Add(-time.Nanosecond), false, true},
+		{"granularity", compare.Options{Granularity: time.Second}, t0.Add(-400 * time.Millisecond), true, false},
+		{"granularity exceeded", compare.Options{Granularity: time.Second}, t0.Add(-time.Second), false, true},
+		{"window", compare.Options{Window: 2 * time.Second}, t0.Add(-2 * time.Second), true, false},
+		{"window other way", compare.Options{Window: 2 * time.Second}, t0.Add(2 * time.Second), true, false},
+		{"window exceeded", compare.Options{Window: 2 * time.Second}, t0.Add(-3 * time.Second), false, true},
+		{"hour without DST", compare.Options{Window: 2 * time.Second}, t0.Add(-time.Hour), false, true},
+		{"DST", compare.Options{Window: 2 * time.Second, DST: true}, t0.Add(-time.Hour - time.Second), true, false},
+		{"DST other way", compare.Options{DST: true}, t0.Add(time.Hour), true, false},
+		{"offset", compare.Options{Granularity: time.Second, Offset: time.Minute}, t0.Add(time.Minute), true, false},
+		{"offset, dst set by src clock", compare.Options{Granularity: time.Second, Offset: time.Minute}, t0, true, false},
+		{"offset, dst older by both clocks", compare.Options{Granularity: time.Second, Offset: time.Minute}, t0.Add(-time.Second), false, true},
+		{"offset, dst older by one clock", compare.Options{Granularity: time.Second, Offset: time.Minute}, t0.Add(time.Second), false, false},
+		{"negative offset", compare.Options{Granularity: time.Second, Offset: -time.Minute}, t0.Add(-time.Minute), true, false},
+		{"DST exceeded", compare.Options{Window: 2 * time.Second, DST: true}, t0.Add(-time.Hour - 3*time.Second), false, true},
+	} {
+		if got := tc.opts.MtimeEqual(t0, tc.b); got != tc.equal {
+			t.Logf("%s: MtimeEqual: want %v, got %v", tc.name, tc.equal, got)
+			t.Fail()
+		}
+		if got := tc.opts.Newer(t0, tc.b); got != tc.newer {
+			t.Logf("%s: Newer: want %v, got %v", tc.name, tc.newer, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestProbeResolution(t *testing.T) {
+	dir, err := os.MkdirTemp("", "probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	res, err := compare.ProbeResolution(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// any file system a test runs on resolves at least seconds
+	if res <= 0 || res > time.Second {
+		t.Logf("unexpected resolution %v", res)
+		t.Fail()
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Log("probe file was not removed")
+		t.Fail()
+	}
+
+	if _, err := compare.ProbeResolution("/does/not/exist"); err == nil {
+		t.Log("want error for non-existing dir")
+		t.Fail()
+	}
+}
@@ -1,11 +1,15 @@
 package copy
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
-	"io"
 	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/FObersteiner/gosyncit/lib/delta"
 )
 
 const (
@@ -14,6 +18,9 @@ const (
 	DefaultModeFile fs.FileMode = 0644 // rw-r--r--
 )
 
+// copyBufferSize is the buffer size for copies in userspace
+const copyBufferSize = 1 << 20
+
 // createDir wraps os.MkdirAll and ignores dir exists error
 func CreateDir(dst string, dry bool) error {
 	if dry {
@@ -25,9 +32,31 @@ func CreateDir(dst string, dry bool) error {
 	return nil
 }
 
+// Options for CopyFileWith
+type Options struct {
+	// Sparse turns blocks of zeros into holes in dst, so that preallocated files
+	// from a non-sparse src do not use disk space for them.
+	Sparse bool
+	// Reflink controls if dst may share its data blocks with src (copy-on-write clone).
+	Reflink Reflink
+}
+
 // CopyFile copies src to dst. If dst exists, it will be overwritten.
-// mtime and atime of the destination file will be set to that of the source file
+// mtime and atime of the destination file will be set to that of the source file.
+// Holes in a sparse src file are preserved where the OS can report them.
+// Data is copied in the kernel where possible (copy_file_range on Linux).
 func CopyFile(src, dst string, sourceFileStat fs.FileInfo, dry bool) error {
+	return CopyFileWith(src, dst, sourceFileStat, dry, Options{})
+}
+
+// CopyFileSparse works like CopyFile, but additionally turns blocks of zeros into holes in dst,
+// so that preallocated files from a non-sparse src do not use disk space for them.
+func CopyFileSparse(src, dst string, sourceFileStat fs.FileInfo, dry bool) error {
+	return CopyFileWith(src, dst, sourceFileStat, dry, Options{Sparse: true})
+}
+
+// CopyFileWith works like CopyFile, with additional options.
+func CopyFileWith(src, dst string, sourceFileStat fs.FileInfo, dry bool, opts Options) error {
 	if dry {
 		return nil
 	}
@@ -47,16 +76,16 @@ func CopyFile(src, dst string, sourceFileStat fs.FileInfo, dry bool) error {
 	}
 	defer destination.Close()
 
-	buf := make([]byte, BUFFERSIZE)
-	for {
-		n, err := source.Read(buf)
-		if err != nil && err != io.EOF {
-			return err
-		}
-		if n == 0 {
-			break
+	cloned := false
+	if opts.Reflink != ReflinkNever {
+		err := reflink(destination, source)
+		if err != nil && opts.Reflink == ReflinkAlways {
+			return fmt.Errorf("reflink '%s': %w", src, err)
 		}
-		if _, err := destination.Write(buf[:n]); err != nil {
+		cloned = err == nil
+	}
+	if !cloned {
+		if err := copyData(destination, source, sourceFileStat.Size(), opts.Sparse); err != nil {
 			return err
 		}
 	}
@@ -69,6 +98,83 @@ func CopyFile(src, dst string, sourceFileStat fs.FileInfo, dry bool) error {
 	return nil
 }
 
+// CopyFileDelta copies src to an existing dst, only writing the blocks of dst that differ
+// (see package delta). The new content is assembled in a temporary file next to dst,
+// which then replaces dst, so dst is never left half-written.
+// If dst does not exist, this falls back to CopyFile.
+func CopyFileDelta(src, dst string, sourceFileStat fs.FileInfo, dry bool) error {
+	if dry {
+		return nil
+	}
+	if !sourceFileStat.Mode().IsRegular() {
+		return fmt.Errorf("'%s' is not a regular file", src)
+	}
+
+	basis, err := os.Open(dst)
+	if errors.Is(err, os.ErrNotExist) {
+		return CopyFile(src, dst, sourceFileStat, dry)
+	}
+	if err != nil {
+		return err
+	}
+	defer basis.Close()
+
+	basisStat, err := basis.Stat()
+	if err != nil {
+		return err
+	}
+	sig, err := delta.Sign(bufio.NewReader(basis), delta.DefaultBlockSize)
+	if err != nil {
+		return err
+	}
+
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails after successful rename; no problem
+
+	w := bufio.NewWriter(tmp)
+	if err := delta.Diff(sig, source, delta.Patch(basis, sig, w)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), basisStat.Mode().Perm()); err != nil {
+		return err
+	}
+	mtime := sourceFileStat.ModTime()
+	if err := os.Chtimes(tmp.Name(), mtime, mtime); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
+
+// LinkFile creates 'dst' as a hard link to 'target'. An existing file 'dst' is replaced.
+func LinkFile(target, dst string, dry bool) error {
+	if dry {
+		return nil
+	}
+	if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Link(target, dst)
+}
+
 // CopyPerm tries to copy permissions from src to dst file
 func CopyPerm(src, dst string) error {
 	srcStat, err := os.Stat(src)
@@ -79,6 +185,42 @@ func CopyPerm(src, dst string) error {
 	return os.Chmod(dst, srcStat.Mode())
 }
 
+// Move moves a file or directory 'src' to 'dst'. If that is not possible with a rename,
+// e.g. because 'dst' is on another file system, 'src' is copied and then removed.
+func Move(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		if err := CopyFile(src, dst, info, false); err != nil {
+			return err
+		}
+		return os.Remove(src)
+	}
+	err = filepath.Walk(src,
+		func(path string, finfo os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(dst, strings.TrimPrefix(path, src))
+			if finfo.IsDir() {
+				return CreateDir(target, false)
+			}
+			if !finfo.Mode().IsRegular() {
+				return nil
+			}
+			return CopyFile(path, target, finfo, false)
+		})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
 func ByteCount(b uint) string {
 	const unit = 1024
 	if b < unit {
@@ -2,6 +2,7 @@ package copy_test
 
 import (
 	"bytes"
+	"crypto/rand"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -78,3 +79,182 @@ func TestCopyFile(t *testing.T) {
 		}
 	}
 }
+
+func TestCopyFileDelta(t *testing.T) {
+	dirA, err := os.MkdirTemp("", "dirA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirA)
+
+	dirB, err := os.MkdirTemp("", "dirB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirB)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 10000)
+	src := filepath.Join(dirA, "tmpfileA")
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fstSrc, _ := os.Stat(src)
+
+	// dst does not exist yet; full copy
+	dst := filepath.Join(dirB, "tmpfileA")
+	if err := cp.CopyFileDelta(src, dst, fstSrc, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// change src, delta copy to existing dst
+	content = append([]byte("prefix"), content...)
+	content[50000] = 'x'
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fstSrc, _ = os.Stat(src)
+	if err := cp.CopyFileDelta(src, dst, fstSrc, false); err != nil {
+		t.Fatal(err)
+	}
+
+	have, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(have, content) {
+		t.Log("dst content differs from src after delta copy")
+		t.Fail()
+	}
+	fstDst, _ := os.Stat(dst)
+	if !fstSrc.ModTime().Equal(fstDst.ModTime()) {
+		t.Logf("Expected mtime to be equal, got %v (src) and %v (dst)", fstSrc.ModTime(), fstDst.ModTime())
+		t.Fail()
+	}
+	entries, _ := os.ReadDir(dirB)
+	if len(entries) != 1 {
+		t.Logf("temporary file must be gone, have %v entries", len(entries))
+		t.Fail()
+	}
+}
+
+func TestParseReflink(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want cp.Reflink
+		ok   bool
+	}{
+		{"never", cp.ReflinkNever, true},
+		{"auto", cp.ReflinkAuto, true},
+		{"always", cp.ReflinkAlways, true},
+		{"sometimes", cp.ReflinkNever, false},
+	} {
+		have, err := cp.ParseReflink(tc.s)
+		if (err == nil) != tc.ok || have != tc.want {
+			t.Logf("ParseReflink(%q): want %v (ok: %v), have %v (%v)", tc.s, tc.want, tc.ok, have, err)
+			t.Fail()
+		}
+		if tc.ok && have.String() != tc.s {
+			t.Logf("want %v, have %v", tc.s, have.String())
+			t.Fail()
+		}
+	}
+}
+
+func TestCopyFileReflink(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dirA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 100000)
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fst, _ := os.Stat(src)
+
+	for _, mode := range []cp.Reflink{cp.ReflinkNever, cp.ReflinkAuto, cp.ReflinkAlways} {
+		dst := filepath.Join(dir, mode.String())
+		err := cp.CopyFileWith(src, dst, fst, false, cp.Options{Reflink: mode})
+		if err != nil {
+			if mode == cp.ReflinkAlways {
+				t.Logf("file system does not support reflinks: %v", err)
+				continue
+			}
+			t.Fatal(err)
+		}
+		have, err := os.ReadFile(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(have, content) {
+			t.Logf("reflink %v: dst content differs from src", mode)
+			t.Fail()
+		}
+	}
+}
+
+// BenchmarkCopyFile compares the copy implementations; the first is the former
+// copy loop with a 4 KiB buffer.
+func BenchmarkCopyFile(b *testing.B) {
+	dir, err := os.MkdirTemp("", "bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const size = 64 << 20
+	content := make([]byte, size)
+	_, _ = rand.Read(content)
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		b.Fatal(err)
+	}
+	fst, _ := os.Stat(src)
+	dst := filepath.Join(dir, "dst")
+
+	b.Run("4KiB buffer", func(b *testing.B) {
+		b.SetBytes(size)
+		for i := 0; i < b.N; i++ {
+			source, err := os.Open(src)
+			if err != nil {
+				b.Fatal(err)
+			}
+			destination, err := os.Create(dst)
+			if err != nil {
+				b.Fatal(err)
+			}
+			buf := make([]byte, 4096)
+			for {
+				n, err := source.Read(buf)
+				if n == 0 || err != nil {
+					break
+				}
+				if _, err := destination.Write(buf[:n]); err != nil {
+					b.Fatal(err)
+				}
+			}
+			source.Close()
+			destination.Close()
+		}
+	})
+
+	for _, bc := range []struct {
+		name string
+		opts cp.Options
+	}{
+		{"userspace", cp.Options{Sparse: true}}, // sparse copies always go through userspace
+		{"kernel", cp.Options{}},
+		{"reflink auto", cp.Options{Reflink: cp.ReflinkAuto}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				if err := cp.CopyFileWith(src, dst, fst, false, bc.opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
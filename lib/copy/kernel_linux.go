@@ -0,0 +1,35 @@
+package copy
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyRange copies n bytes from src to dst at their current offsets with copy_file_range,
+// without copying data to userspace. Returns errUnsupported if nothing could be copied
+// because the kernel or file system does not support it, e.g. across file systems on older kernels.
+func copyRange(dst, src *os.File, n int64) (int64, error) {
+	var copied int64
+	for copied < n {
+		m, err := unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, int(min(n-copied, 1<<30)), 0)
+		if err != nil {
+			if copied == 0 && (errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EXDEV) ||
+				errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EPERM)) {
+				return 0, errUnsupported
+			}
+			return copied, err
+		}
+		if m == 0 {
+			break // src shrunk while copying
+		}
+		copied += int64(m)
+	}
+	return copied, nil
+}
+
+// reflink makes dst a copy-on-write clone of src (FICLONE ioctl), as supported by e.g. Btrfs and XFS.
+func reflink(dst, src *os.File) error {
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+}
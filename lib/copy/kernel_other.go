@@ -0,0 +1,18 @@
+//go:build !linux
+
+package copy
+
+import (
+	"errors"
+	"os"
+)
+
+// copyRange is not implemented on this platform; data is copied in userspace.
+func copyRange(dst, src *os.File, n int64) (int64, error) {
+	return 0, errUnsupported
+}
+
+// reflink is not implemented on this platform.
+func reflink(dst, src *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,31 @@
+package copy
+
+import "fmt"
+
+// Reflink is a mode for copy-on-write clones of files, see Options
+type Reflink int
+
+const (
+	ReflinkNever  Reflink = iota // always copy data
+	ReflinkAuto                  // clone if the file system supports it, copy data otherwise
+	ReflinkAlways                // clone, fail if that is not possible
+)
+
+var reflinkNames = []string{"never", "auto", "always"}
+
+func (r Reflink) String() string {
+	if r < 0 || int(r) >= len(reflinkNames) {
+		return fmt.Sprintf("Reflink(%d)", int(r))
+	}
+	return reflinkNames[r]
+}
+
+// ParseReflink parses a reflink mode: "auto", "always" or "never"
+func ParseReflink(s string) (Reflink, error) {
+	for i, name := range reflinkNames {
+		if s == name {
+			return Reflink(i), nil
+		}
+	}
+	return ReflinkNever, fmt.Errorf("invalid reflink mode '%s', must be one of auto, always, never", s)
+}
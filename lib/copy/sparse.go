@@ -0,0 +1,109 @@
+package copy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// blockSize is the unit in which blocks of zeros are turned into holes
+const blockSize = BUFFERSIZE
+
+// errUnsupported is returned by copyRange if the kernel can not copy the data
+var errUnsupported = errors.New("in-kernel copy not supported")
+
+// region is a range [start, end) of a file that contains data
+type region struct {
+	start, end int64
+}
+
+// copyData copies 'size' bytes from src to the empty file dst. Only the data regions of src
+// are copied (see dataRegions); the file system keeps the rest of dst as holes.
+// Data is copied in the kernel if possible (see copyRange), in userspace otherwise.
+// If sparse is set, data is always copied in userspace, and blocks of zeros within
+// the data regions are skipped as well.
+func copyData(dst, src *os.File, size int64, sparse bool) error {
+	regions, err := dataRegions(src, size)
+	if err != nil {
+		return err
+	}
+
+	kernel := !sparse
+	var buf []byte
+	for _, r := range regions {
+		if _, err := src.Seek(r.start, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := dst.Seek(r.start, io.SeekStart); err != nil {
+			return err
+		}
+		remaining := r.end - r.start
+
+		if kernel {
+			n, err := copyRange(dst, src, remaining)
+			remaining -= n
+			if errors.Is(err, errUnsupported) {
+				kernel = false // don't try again for the next region
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if remaining > 0 && buf == nil {
+			buf = make([]byte, copyBufferSize)
+		}
+		for remaining > 0 {
+			n, err := src.Read(buf[:min(int64(len(buf)), remaining)])
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if n == 0 {
+				break // src shrunk while copying
+			}
+			remaining -= int64(n)
+			if err := write(dst, buf[:n], sparse); err != nil {
+				return err
+			}
+		}
+	}
+
+	// a hole at the end of the file is not created by seeking alone
+	return dst.Truncate(size)
+}
+
+// write b to f. If sparse is set, full blocks of zeros are skipped instead of written.
+func write(f *os.File, b []byte, sparse bool) error {
+	if !sparse {
+		_, err := f.Write(b)
+		return err
+	}
+	for len(b) > 0 {
+		// data up to the next block of zeros
+		n := 0
+		for n < len(b) && !(n+blockSize <= len(b) && isZero(b[n:n+blockSize])) {
+			n += min(blockSize, len(b)-n)
+		}
+		if _, err := f.Write(b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+		// zeros up to the next data
+		n = 0
+		for n+blockSize <= len(b) && isZero(b[n:n+blockSize]) {
+			n += blockSize
+		}
+		if _, err := f.Seek(int64(n), io.SeekCurrent); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+var zeros = make([]byte, blockSize)
+
+// isZero reports whether b only contains zeros
+func isZero(b []byte) bool {
+	return bytes.Equal(b, zeros[:len(b)])
+}
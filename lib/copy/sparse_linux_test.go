@@ -0,0 +1,94 @@
+package copy_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	cp "github.com/FObersteiner/gosyncit/lib/copy"
+)
+
+// allocated returns the number of bytes a file uses on disk
+func allocated(t *testing.T, path string) int64 {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		t.Fatal(err)
+	}
+	return st.Blocks * 512
+}
+
+func TestCopyFileSparse(t *testing.T) {
+	dirA, err := os.MkdirTemp("", "dirA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirA)
+
+	dirB, err := os.MkdirTemp("", "dirB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirB)
+
+	const size = 8 << 20
+	data := []byte("some data in the middle of nowhere")
+
+	// sparse src: 8 MiB hole with some data in the middle
+	src := filepath.Join(dirA, "sparse")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(data, size/2); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if allocated(t, src) >= size {
+		t.Skip("file system does not support sparse files")
+	}
+
+	// non-sparse src with the same content
+	want := make([]byte, size)
+	copy(want[size/2:], data)
+	srcFull := filepath.Join(dirA, "full")
+	if err := os.WriteFile(srcFull, want, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		src    string
+		sparse bool
+	}{
+		{"preserve holes", src, false},
+		{"punch holes", srcFull, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := filepath.Join(dirB, filepath.Base(tc.src))
+			fst, _ := os.Stat(tc.src)
+			copyFile := cp.CopyFile
+			if tc.sparse {
+				copyFile = cp.CopyFileSparse
+			}
+			if err := copyFile(tc.src, dst, fst, false); err != nil {
+				t.Fatal(err)
+			}
+			have, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(have, want) {
+				t.Fatal("dst content differs from src")
+			}
+			if n := allocated(t, dst); n >= size/2 {
+				t.Logf("dst should be sparse, but uses %v bytes on disk", n)
+				t.Fail()
+			}
+		})
+	}
+}
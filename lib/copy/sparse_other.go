@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package copy
+
+import "os"
+
+// dataRegions returns the whole file as one region; holes cannot be detected on this platform.
+func dataRegions(f *os.File, size int64) ([]region, error) {
+	return []region{{0, size}}, nil
+}
@@ -0,0 +1,40 @@
+//go:build linux || darwin || freebsd
+
+package copy
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// dataRegions returns the regions of f that contain data, using SEEK_DATA and SEEK_HOLE.
+// If the file system does not support that, the whole file is one region.
+func dataRegions(f *os.File, size int64) ([]region, error) {
+	var regions []region
+	var offset int64
+	for offset < size {
+		start, err := f.Seek(offset, unix.SEEK_DATA)
+		if errors.Is(err, syscall.ENXIO) {
+			break // only a hole after offset
+		}
+		if err != nil {
+			if offset == 0 {
+				return []region{{0, size}}, nil // not supported
+			}
+			return nil, err
+		}
+		end, err := f.Seek(start, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		end = min(end, size)
+		regions = append(regions, region{start, end})
+		offset = end
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return regions, err
+}
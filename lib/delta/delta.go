@@ -0,0 +1,225 @@
+// Package delta implements the rsync algorithm: a signature of the old version of a file
+// (weak rolling and strong checksums of fixed-size blocks) is used to describe the new
+// version as a sequence of block references and literal data.
+package delta
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	DefaultBlockSize = 16 * 1024
+	maxLiteral       = 256 * 1024 // flush literal data in chunks of this size
+	modAdler         = 1 << 16
+)
+
+// Block is the signature of one block of the old file
+type Block struct {
+	Weak   uint32
+	Strong [sha256.Size]byte
+	Len    int
+}
+
+// Signature describes the old version of a file, block by block. Its exported fields are
+// all that is needed to use it, so it can be encoded and sent to the other end of a transfer.
+type Signature struct {
+	BlockSize int
+	Blocks    []Block
+	lookup    map[uint32][]int
+}
+
+// Op is one instruction to reconstruct the new file: either copy block 'Block'
+// of the old file (Data is nil), or write literal 'Data' (Block is -1).
+type Op struct {
+	Block int
+	Data  []byte
+}
+
+// weakSum is the rsync rolling checksum
+type weakSum struct {
+	a, b uint32
+	n    uint32
+}
+
+func (w *weakSum) init(p []byte) {
+	w.a, w.b, w.n = 0, 0, uint32(len(p))
+	for i, c := range p {
+		w.a += uint32(c)
+		w.b += uint32(len(p)-i) * uint32(c)
+	}
+	w.a %= modAdler
+	w.b %= modAdler
+}
+
+// roll removes byte 'out' from the front of the window and appends byte 'in'
+func (w *weakSum) roll(out, in byte) {
+	w.a = (w.a - uint32(out) + uint32(in)) % modAdler
+	w.b = (w.b - w.n*uint32(out) + w.a) % modAdler
+}
+
+// shrink removes byte 'out' from the front of the window
+func (w *weakSum) shrink(out byte) {
+	w.a = (w.a - uint32(out)) % modAdler
+	w.b = (w.b - w.n*uint32(out)) % modAdler
+	w.n--
+}
+
+func (w *weakSum) sum() uint32 { return w.a | w.b<<16 }
+
+// Sign computes the signature of r, which is the old version of a file.
+func Sign(r io.Reader, blockSize int) (*Signature, error) {
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("invalid block size %v", blockSize)
+	}
+	sig := &Signature{BlockSize: blockSize, lookup: make(map[uint32][]int)}
+	buf := make([]byte, blockSize)
+	var w weakSum
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			w.init(buf[:n])
+			b := Block{Weak: w.sum(), Strong: sha256.Sum256(buf[:n]), Len: n}
+			sig.lookup[b.Weak] = append(sig.lookup[b.Weak], len(sig.Blocks))
+			sig.Blocks = append(sig.Blocks, b)
+		}
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return sig, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// match returns the index of the block equal to window, or -1
+func (sig *Signature) match(weak uint32, window []byte) int {
+	if sig.lookup == nil {
+		// decoded signature; build the index of weak sums
+		sig.lookup = make(map[uint32][]int, len(sig.Blocks))
+		for i, b := range sig.Blocks {
+			sig.lookup[b.Weak] = append(sig.lookup[b.Weak], i)
+		}
+	}
+	candidates, ok := sig.lookup[weak]
+	if !ok {
+		return -1
+	}
+	strong := sha256.Sum256(window)
+	for _, i := range candidates {
+		if sig.Blocks[i].Len == len(window) && sig.Blocks[i].Strong == strong {
+			return i
+		}
+	}
+	return -1
+}
+
+// Diff reads the new version of a file from r and calls emit for each Op needed to
+// reconstruct it from the old version described by sig. The Data of an Op is only
+// valid during the call to emit.
+func Diff(sig *Signature, r io.Reader, emit func(Op) error) error {
+	br := bufio.NewReaderSize(r, 4*sig.BlockSize)
+	bs := sig.BlockSize
+
+	window := make([]byte, 0, 2*bs)
+	literal := make([]byte, 0, maxLiteral)
+
+	flush := func() error {
+		if len(literal) == 0 {
+			return nil
+		}
+		err := emit(Op{Block: -1, Data: literal})
+		literal = literal[:0]
+		return err
+	}
+
+	// fill reads up to one block into the (empty) window
+	fill := func() error {
+		window = window[:bs]
+		n, err := io.ReadFull(br, window)
+		window = window[:n]
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		return err
+	}
+
+	if err := fill(); err != nil {
+		return err
+	}
+	var w weakSum
+	w.init(window)
+	eof := false
+
+	for len(window) > 0 {
+		if idx := sig.match(w.sum(), window); idx >= 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			if err := emit(Op{Block: idx}); err != nil {
+				return err
+			}
+			window = window[:0]
+			if !eof {
+				if err := fill(); err != nil {
+					return err
+				}
+				eof = len(window) < bs
+			}
+			w.init(window)
+			continue
+		}
+
+		out := window[0]
+		literal = append(literal, out)
+		if len(literal) == maxLiteral {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		if !eof {
+			in, err := br.ReadByte()
+			if err == nil {
+				w.roll(out, in)
+				window = append(window[1:], in)
+				if cap(window) == len(window) {
+					// move the window back to the start of its buffer
+					window = append(make([]byte, 0, 2*bs), window...)
+				}
+				continue
+			}
+			if err != io.EOF {
+				return err
+			}
+			eof = true
+		}
+		w.shrink(out)
+		window = window[1:]
+	}
+	return flush()
+}
+
+// Patch returns an emit function for Diff that writes the new file to w,
+// reading matched blocks from the old version 'base'.
+func Patch(base io.ReaderAt, sig *Signature, w io.Writer) func(Op) error {
+	buf := make([]byte, sig.BlockSize)
+	return func(op Op) error {
+		if op.Block < 0 {
+			_, err := w.Write(op.Data)
+			return err
+		}
+		if op.Block >= len(sig.Blocks) {
+			return fmt.Errorf("invalid block index %v", op.Block)
+		}
+		b := buf[:sig.Blocks[op.Block].Len]
+		if _, err := base.ReadAt(b, int64(op.Block)*int64(sig.BlockSize)); err != nil && err != io.EOF {
+			return err
+		}
+		_, err := w.Write(b)
+		return err
+	}
+}
@@ -0,0 +1,61 @@
+package delta_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/FObersteiner/gosyncit/lib/delta"
+)
+
+func TestDiffPatch(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	old := make([]byte, 300*1024+123)
+	rng.Read(old)
+
+	insert := func(b []byte, at int, p []byte) []byte {
+		return append(append(append([]byte{}, b[:at]...), p...), b[at:]...)
+	}
+
+	changed := append([]byte{}, old...)
+	changed[1000] ^= 0xff
+
+	for name, tc := range map[string]struct {
+		new        []byte
+		maxLiteral int
+	}{
+		"identical": {old, 0},
+		"modified":  {changed, 1024},
+		"inserted":  {insert(old, 5000, []byte("inserted")), 1024 + 8},
+		"deleted":   {append(append([]byte{}, old[:7000]...), old[7100:]...), 1024},
+		"appended":  {append(append([]byte{}, old...), []byte("appended")...), 123 + 8},
+		"truncated": {old[:100*1024+7], 7},
+		"empty":     {[]byte{}, 0},
+		"new":       {[]byte("completely different"), 20},
+	} {
+		sig, err := delta.Sign(bytes.NewReader(old), 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		var nLiteral int
+		patch := delta.Patch(bytes.NewReader(old), sig, &out)
+		err = delta.Diff(sig, bytes.NewReader(tc.new), func(op delta.Op) error {
+			nLiteral += len(op.Data)
+			return patch(op)
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if !bytes.Equal(out.Bytes(), tc.new) {
+			t.Logf("%s: reconstructed file differs", name)
+			t.Fail()
+		}
+		if nLiteral > tc.maxLiteral {
+			t.Logf("%s: expected at most %v literal bytes, got %v", name, tc.maxLiteral, nLiteral)
+			t.Fail()
+		}
+	}
+}
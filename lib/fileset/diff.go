@@ -0,0 +1,98 @@
+package fileset
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+// Reasons why a path that exists in both filesets differs, see Diff
+const (
+	DiffType    = "type" // file in one, directory in the other
+	DiffSize    = "size"
+	DiffMtime   = "mtime"
+	DiffContent = "content"
+)
+
+// Difference of a path that exists in both filesets
+type Difference struct {
+	Path   string
+	Reason string
+}
+
+// DiffResult of comparing two filesets a and b
+type DiffResult struct {
+	OnlyA     []string
+	OnlyB     []string
+	Differ    []Difference
+	Identical int
+}
+
+// Equal reports whether the compared filesets are identical
+func (r DiffResult) Equal() bool {
+	return len(r.OnlyA) == 0 && len(r.OnlyB) == 0 && len(r.Differ) == 0
+}
+
+// Diff compares filesets a and b. Directories only differ if the path is a file in the other set.
+// Files are compared by size, then by mtime, using mtimeEqual (e.g. compare.Options.MtimeEqual).
+// If 'content' is not nil, it is called for files of equal size instead of comparing mtimes;
+// a file with equal content but different mtime is still reported as a mtime difference.
+// All lists in the result are sorted by path.
+func Diff(a, b *Fileset, mtimeEqual func(a, b time.Time) bool, content func(name string) (bool, error)) (DiffResult, error) {
+	var r DiffResult
+	for name, infoA := range a.Paths {
+		if name == "" {
+			continue // basepath
+		}
+		infoB, ok := b.Paths[name]
+		if !ok {
+			r.OnlyA = append(r.OnlyA, name)
+			continue
+		}
+		reason, err := diffReason(name, infoA, infoB, mtimeEqual, content)
+		if err != nil {
+			return r, err
+		}
+		if reason == "" {
+			r.Identical++
+			continue
+		}
+		r.Differ = append(r.Differ, Difference{name, reason})
+	}
+	for name := range b.Paths {
+		if _, ok := a.Paths[name]; !ok && name != "" {
+			r.OnlyB = append(r.OnlyB, name)
+		}
+	}
+
+	sort.Strings(r.OnlyA)
+	sort.Strings(r.OnlyB)
+	sort.Slice(r.Differ, func(i, j int) bool { return r.Differ[i].Path < r.Differ[j].Path })
+	return r, nil
+}
+
+// diffReason returns why a path differs, or "" if it does not
+func diffReason(name string, infoA, infoB os.FileInfo, mtimeEqual func(a, b time.Time) bool, content func(name string) (bool, error)) (string, error) {
+	if infoA.IsDir() || infoB.IsDir() {
+		if infoA.IsDir() != infoB.IsDir() {
+			return DiffType, nil
+		}
+		return "", nil
+	}
+	if infoA.Size() != infoB.Size() {
+		return DiffSize, nil
+	}
+	if content != nil {
+		equal, err := content(name)
+		if err != nil {
+			return "", err
+		}
+		if !equal {
+			return DiffContent, nil
+		}
+	}
+	if !mtimeEqual(infoA.ModTime(), infoB.ModTime()) {
+		return DiffMtime, nil
+	}
+	return "", nil
+}
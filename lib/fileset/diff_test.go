@@ -0,0 +1,119 @@
+package fileset_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/FObersteiner/gosyncit/lib/compare"
+	fm "github.com/FObersteiner/gosyncit/lib/fileset"
+)
+
+func TestDiff(t *testing.T) {
+	dirA, err := os.MkdirTemp("", "dirA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirA)
+
+	dirB, err := os.MkdirTemp("", "dirB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirB)
+
+	mtime := time.Date(2006, time.March, 1, 3, 4, 5, 0, time.UTC)
+	write := func(path, content string, mtime time.Time) {
+		_ = os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(filepath.Join(dirA, "same", "file"), "content", mtime)
+	write(filepath.Join(dirB, "same", "file"), "content", mtime)
+	write(filepath.Join(dirA, "only-a"), "content", mtime)
+	write(filepath.Join(dirB, "only-b", "file"), "content", mtime)
+	write(filepath.Join(dirA, "size"), "content", mtime)
+	write(filepath.Join(dirB, "size"), "content changed", mtime)
+	write(filepath.Join(dirA, "mtime"), "content", mtime)
+	write(filepath.Join(dirB, "mtime"), "content", mtime.Add(time.Hour))
+	write(filepath.Join(dirA, "content"), "content", mtime)
+	write(filepath.Join(dirB, "content"), "CONTENT", mtime)
+	write(filepath.Join(dirA, "type"), "content", mtime)
+	write(filepath.Join(dirB, "type", "file"), "content", mtime)
+	// sub-second difference, below granularity
+	write(filepath.Join(dirA, "granularity"), "content", mtime)
+	write(filepath.Join(dirB, "granularity"), "content", mtime.Add(time.Millisecond))
+
+	fsA, _ := fm.New(dirA)
+	_ = fsA.Populate()
+	fsB, _ := fm.New(dirB)
+	_ = fsB.Populate()
+
+	mtimeEqual := compare.Options{Granularity: time.Second}.MtimeEqual
+
+	// without content comparison
+	r, err := fm.Diff(fsA, fsB, mtimeEqual, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Equal() {
+		t.Fatal("filesets must not be equal")
+	}
+	if want := []string{"only-a"}; !reflect.DeepEqual(r.OnlyA, want) {
+		t.Logf("only in A: want %v, have %v", want, r.OnlyA)
+		t.Fail()
+	}
+	if want := []string{"only-b", "only-b/file", "type/file"}; !reflect.DeepEqual(r.OnlyB, want) {
+		t.Logf("only in B: want %v, have %v", want, r.OnlyB)
+		t.Fail()
+	}
+	want := []fm.Difference{{"mtime", fm.DiffMtime}, {"size", fm.DiffSize}, {"type", fm.DiffType}}
+	if !reflect.DeepEqual(r.Differ, want) {
+		t.Logf("differ: want %v, have %v", want, r.Differ)
+		t.Fail()
+	}
+	if r.Identical != 4 { // same, same/file, content, granularity
+		t.Logf("identical: want 4, have %v", r.Identical)
+		t.Fail()
+	}
+
+	// with content comparison
+	content := func(name string) (bool, error) {
+		a, err := os.ReadFile(filepath.Join(dirA, name))
+		if err != nil {
+			return false, err
+		}
+		b, err := os.ReadFile(filepath.Join(dirB, name))
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(a, b), nil
+	}
+	r, err = fm.Diff(fsA, fsB, mtimeEqual, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []fm.Difference{{"content", fm.DiffContent}, {"mtime", fm.DiffMtime}, {"size", fm.DiffSize}, {"type", fm.DiffType}}
+	if !reflect.DeepEqual(r.Differ, want) {
+		t.Logf("differ: want %v, have %v", want, r.Differ)
+		t.Fail()
+	}
+
+	// identical
+	r, err = fm.Diff(fsA, fsA, mtimeEqual, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Equal() || r.Identical != 8 {
+		t.Logf("fileset must be equal to itself, have %+v", r)
+		t.Fail()
+	}
+}
@@ -16,6 +16,14 @@ var ErrInvalidBasepath = errors.New("basepath must be an existing directory")
 type Fileset struct {
 	Paths    map[string]os.FileInfo
 	Basepath string
+	// Links maps paths of hard-linked files to the first path (in walk order) of the same
+	// device and inode. Only set by Populate, on platforms that support it.
+	Links map[string]string
+}
+
+// inode identifies a file on a local file system
+type inode struct {
+	dev, ino uint64
 }
 
 // New returns a new Fileset with only the basepath specified
@@ -37,14 +45,25 @@ func New(basepath string) (*Fileset, error) {
 	return &Fileset{Basepath: basepath, Paths: m}, nil
 }
 
-// Populate walks the basepath of the Fileset to populate the paths map
+// Populate walks the basepath of the Fileset to populate the paths map.
+// Regular files sharing the same device and inode are recorded in Links.
 func (fs *Fileset) Populate() error {
+	fs.Links = make(map[string]string)
+	seen := make(map[inode]string)
 	err := filepath.Walk(fs.Basepath,
 		func(path string, finfo os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
-			fs.Paths[strings.TrimPrefix(path, fs.Basepath)] = finfo
+			p := strings.TrimPrefix(path, fs.Basepath)
+			fs.Paths[p] = finfo
+			if id, nlink, ok := fileID(finfo); ok && nlink > 1 && finfo.Mode().IsRegular() {
+				if first, ok := seen[id]; ok {
+					fs.Links[p] = first
+				} else {
+					seen[id] = p
+				}
+			}
 			return nil
 		})
 	if err != nil {
@@ -3,6 +3,7 @@ package fileset_test
 import (
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 
 	fm "github.com/FObersteiner/gosyncit/lib/fileset"
@@ -74,3 +75,38 @@ func BenchmarkFileset(b *testing.B) {
 	}
 	_ = m.Populate()
 }
+
+func TestHardLinks(t *testing.T) {
+	dirA, err := os.MkdirTemp("", "dirA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirA)
+
+	file := filepath.Join(dirA, "a")
+	if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dirA, "c"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(file, filepath.Join(dirA, "b")); err != nil {
+		t.Skip("hard links not supported:", err)
+	}
+
+	m, err := fm.New(dirA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Populate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if runtime.GOOS == "windows" {
+		return // no inode information
+	}
+	if len(m.Links) != 1 || m.Links["b"] != "a" {
+		t.Logf("expected 'b' to be linked to 'a', got %v", m.Links)
+		t.Fail()
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package fileset
+
+import "os"
+
+// fileID is not implemented on this platform; hard links are not detected.
+func fileID(info os.FileInfo) (id inode, nlink uint64, ok bool) {
+	return id, 0, false
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package fileset
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID returns device and inode of a file, and its number of hard links.
+// ok is false if the information is not available.
+func fileID(info os.FileInfo) (id inode, nlink uint64, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return id, 0, false
+	}
+	return inode{dev: uint64(st.Dev), ino: uint64(st.Ino)}, uint64(st.Nlink), true
+}
@@ -0,0 +1,207 @@
+package fileset
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Rename describes a path of the destination that can be moved to a new path,
+// instead of copying the new path and deleting the old one.
+type Rename struct {
+	From, To string
+}
+
+// DetectRenames matches entries that only exist in dst with entries that only exist in src.
+// Directories match if their complete content (relative paths, sizes and mtimes to the second)
+// is equal; their content is then not considered individually.
+// Regular files match if equal returns true.
+// If several files of dst match the same file of src, the match is ambiguous and skipped.
+// If verify is not nil, it is called with the full paths of each candidate pair of files,
+// also of each file in a pair of directories, and must confirm that the content is equal.
+// Empty files are never matched.
+func DetectRenames(src, dst *Fileset, equal func(srcInfo, dstInfo os.FileInfo) bool, verify func(srcPath, dstPath string) bool) []Rename {
+	var onlySrc, onlyDst []string
+	for p := range src.Paths {
+		if !dst.Contains(p) {
+			onlySrc = append(onlySrc, p)
+		}
+	}
+	for p := range dst.Paths {
+		if !src.Contains(p) {
+			onlyDst = append(onlyDst, p)
+		}
+	}
+	sort.Strings(onlySrc) // parents before children
+	sort.Strings(onlyDst)
+
+	var renames []Rename
+	handledSrc := make(map[string]bool)
+	handledDst := make(map[string]bool)
+
+	// directories, indexed by signature
+	srcContents, dstContents := src.dirContents(onlySrc), dst.dirContents(onlyDst)
+	dstDirs := make(map[string][]string)
+	for _, p := range onlyDst {
+		if dst.Paths[p].IsDir() {
+			if sig := dst.dirSignature(dstContents[p]); sig != "" {
+				dstDirs[sig] = append(dstDirs[sig], p)
+			}
+		}
+	}
+	for _, p := range onlySrc {
+		if !src.Paths[p].IsDir() || underAny(p, handledSrc) {
+			continue
+		}
+		sig := src.dirSignature(srcContents[p])
+		if sig == "" {
+			continue
+		}
+		for i, candidate := range dstDirs[sig] {
+			if underAny(candidate, handledDst) {
+				continue
+			}
+			if verify != nil && !verifyDir(src, dst, p, candidate, srcContents[p], verify) {
+				continue
+			}
+			renames = append(renames, Rename{From: candidate, To: p})
+			handledSrc[p] = true
+			handledDst[candidate] = true
+			dstDirs[sig] = append(dstDirs[sig][:i], dstDirs[sig][i+1:]...)
+			break
+		}
+	}
+
+	// files; bucket dst candidates by size
+	bySize := make(map[int64][]string)
+	for _, p := range onlyDst {
+		info := dst.Paths[p]
+		if info.Mode().IsRegular() && info.Size() > 0 && !underAny(p, handledDst) {
+			bySize[info.Size()] = append(bySize[info.Size()], p)
+		}
+	}
+	for _, p := range onlySrc {
+		info := src.Paths[p]
+		if !info.Mode().IsRegular() || info.Size() == 0 || underAny(p, handledSrc) {
+			continue
+		}
+		var match []string
+		for _, candidate := range bySize[info.Size()] {
+			if handledDst[candidate] || !equal(info, dst.Paths[candidate]) {
+				continue
+			}
+			if verify != nil && !verify(filepath.Join(src.Basepath, p), filepath.Join(dst.Basepath, candidate)) {
+				continue
+			}
+			match = append(match, candidate)
+		}
+		if len(match) != 1 {
+			continue // nothing found, or ambiguous
+		}
+		renames = append(renames, Rename{From: match[0], To: p})
+		handledSrc[p] = true
+		handledDst[match[0]] = true
+	}
+
+	return renames
+}
+
+// Move updates the paths of the fileset after entry 'from', including its content if it is
+// a directory, was moved to 'to'.
+func (fs *Fileset) Move(from, to string) {
+	prefix := from + string(os.PathSeparator)
+	for p, info := range fs.Paths {
+		if p == from {
+			delete(fs.Paths, p)
+			fs.Paths[to] = info
+		} else if strings.HasPrefix(p, prefix) {
+			delete(fs.Paths, p)
+			fs.Paths[filepath.Join(to, strings.TrimPrefix(p, prefix))] = info
+		}
+	}
+}
+
+// Filter returns a new Fileset with the same basepath, containing the paths for which keep returns true
+func (fs *Fileset) Filter(keep func(path string) bool) *Fileset {
+	m := make(map[string]os.FileInfo)
+	for p, info := range fs.Paths {
+		if keep(p) {
+			m[p] = info
+		}
+	}
+	return &Fileset{Basepath: fs.Basepath, Paths: m}
+}
+
+// dirContents returns the paths below each of the directories among 'paths', keyed by directory.
+// Each path of the fileset is assigned to its parents, so this is linear in the size of the fileset.
+func (fs *Fileset) dirContents(paths []string) map[string][]string {
+	contents := make(map[string][]string)
+	for _, p := range paths {
+		if info, ok := fs.Paths[p]; ok && info.IsDir() {
+			contents[p] = nil
+		}
+	}
+	for p := range fs.Paths {
+		for dir := filepath.Dir(p); dir != "." && dir != string(os.PathSeparator); dir = filepath.Dir(dir) {
+			if c, ok := contents[dir]; ok {
+				contents[dir] = append(c, p)
+			}
+		}
+	}
+	return contents
+}
+
+// dirSignature describes the content of a directory, given as the paths below it (see
+// dirContents). Empty directories have no signature.
+func (fs *Fileset) dirSignature(content []string) string {
+	if len(content) == 0 {
+		return ""
+	}
+	dir := filepath.Dir(content[0])
+	for _, p := range content[1:] {
+		if d := filepath.Dir(p); len(d) < len(dir) {
+			dir = d // the shallowest entry is a direct child
+		}
+	}
+	prefix := dir + string(os.PathSeparator)
+	entries := make([]string, 0, len(content))
+	for _, p := range content {
+		info := fs.Paths[p]
+		rel := strings.TrimPrefix(p, prefix)
+		if info.IsDir() {
+			entries = append(entries, rel+string(os.PathSeparator))
+		} else {
+			entries = append(entries, fmt.Sprintf("%s %d %d", rel, info.Size(), info.ModTime().Unix()))
+		}
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, "\n")
+}
+
+// verifyDir calls verify for each regular file below directory 'srcDir' of src, with 'content'
+// (see dirContents), and its counterpart below 'dstDir' of dst. Returns true if all are confirmed.
+func verifyDir(src, dst *Fileset, srcDir, dstDir string, content []string, verify func(srcPath, dstPath string) bool) bool {
+	prefix := srcDir + string(os.PathSeparator)
+	for _, p := range content {
+		if !src.Paths[p].Mode().IsRegular() {
+			continue
+		}
+		rel := strings.TrimPrefix(p, prefix)
+		if !verify(filepath.Join(src.Basepath, p), filepath.Join(dst.Basepath, dstDir, rel)) {
+			return false
+		}
+	}
+	return true
+}
+
+// underAny returns true if p or one of its parents is in set
+func underAny(p string, set map[string]bool) bool {
+	for ; p != "." && p != string(os.PathSeparator) && p != ""; p = filepath.Dir(p) {
+		if set[p] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package fileset_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	fm "github.com/FObersteiner/gosyncit/lib/fileset"
+)
+
+func TestDetectRenames(t *testing.T) {
+	src, err := os.MkdirTemp("", "src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+
+	dst, err := os.MkdirTemp("", "dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	mtime := time.Date(2006, time.March, 1, 3, 4, 5, 0, time.UTC)
+	write := func(path, content string) {
+		_ = os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// moved directory
+	write(filepath.Join(dst, "old", "dir", "a"), "content a")
+	write(filepath.Join(dst, "old", "dir", "sub", "b"), "content bb")
+	write(filepath.Join(src, "new", "dir", "a"), "content a")
+	write(filepath.Join(src, "new", "dir", "sub", "b"), "content bb")
+	// renamed file
+	write(filepath.Join(dst, "file"), "content file")
+	write(filepath.Join(src, "renamed"), "content file")
+	// ambiguous: two candidates
+	write(filepath.Join(dst, "x1"), "xxx")
+	write(filepath.Join(dst, "x2"), "xxx")
+	write(filepath.Join(src, "x3"), "xxx")
+	// same size and mtime, different content
+	write(filepath.Join(dst, "y1"), "yyyy")
+	write(filepath.Join(src, "y2"), "zzzz")
+	// directory with the same signature, different content
+	write(filepath.Join(dst, "d1", "f"), "dddd")
+	write(filepath.Join(src, "d2", "f"), "eeee")
+
+	fsSrc, _ := fm.New(src)
+	_ = fsSrc.Populate()
+	fsDst, _ := fm.New(dst)
+	_ = fsDst.Populate()
+
+	equal := func(a, b os.FileInfo) bool {
+		return a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+	}
+	verify := func(a, b string) bool {
+		ca, _ := os.ReadFile(a)
+		cb, _ := os.ReadFile(b)
+		return string(ca) == string(cb)
+	}
+
+	renames := fm.DetectRenames(fsSrc, fsDst, equal, verify)
+	want := map[string]string{
+		"old":  "new",
+		"file": "renamed",
+	}
+	if len(renames) != len(want) {
+		t.Fatalf("expected %v renames, got %v", len(want), renames)
+	}
+	for _, r := range renames {
+		if want[r.From] != r.To {
+			t.Logf("unexpected rename %v", r)
+			t.Fail()
+		}
+	}
+
+	// without verification, y1 --> y2 and d1 --> d2 are taken as renames
+	renames = fm.DetectRenames(fsSrc, fsDst, equal, nil)
+	if len(renames) != 4 {
+		t.Logf("expected 4 renames without verification, got %v", renames)
+		t.Fail()
+	}
+
+	fsDst.Move("old", "new")
+	if !fsDst.Contains(filepath.Join("new", "dir", "sub", "b")) || fsDst.Contains(filepath.Join("old", "dir", "a")) {
+		t.Logf("Move must update the paths of the directory content, have %v", fsDst)
+		t.Fail()
+	}
+}
@@ -0,0 +1,10 @@
+//go:build darwin || freebsd || netbsd
+
+package hashcache
+
+import "syscall"
+
+// changeTime returns the ctime of a file in unix nanoseconds
+func changeTime(st *syscall.Stat_t) int64 {
+	return st.Ctimespec.Nano()
+}
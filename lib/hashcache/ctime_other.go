@@ -0,0 +1,10 @@
+//go:build unix && !(linux || openbsd || solaris || darwin || freebsd || netbsd)
+
+package hashcache
+
+import "syscall"
+
+// changeTime is not implemented on this platform; entries are only keyed by the other fields.
+func changeTime(st *syscall.Stat_t) int64 {
+	return 0
+}
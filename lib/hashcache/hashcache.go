@@ -0,0 +1,312 @@
+// Package hashcache stores SHA-256 hashes of local files, keyed by device, inode, size, mtime
+// and ctime, so that unchanged files do not need to be hashed again. Any change of these
+// invalidates the entry; the ctime catches files rewritten in place with their mtime restored.
+// The cache is a plain text file; optionally, hashes are also stored in an extended attribute
+// of each file (user.gosyncit.sha256), so they survive moving the cache file.
+package hashcache
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FObersteiner/gosyncit/lib/manifest"
+)
+
+// header is the first line of a cache file
+const header = "gosyncit hashcache v2"
+
+// headerV1 is the first line of cache files without ctime; their entries are discarded
+const headerV1 = "gosyncit hashcache v1"
+
+// xattrSlack is how much later than the time it was written at an extended attribute may
+// change the ctime of a file. Writing the attribute changes the ctime itself, so the attribute
+// cannot record the final ctime; a change within this time after writing goes unnoticed.
+const xattrSlack = 10 * time.Millisecond
+
+// XattrName is the extended attribute hashes are stored in, see Cache.Xattr
+const XattrName = "user.gosyncit.sha256"
+
+// key identifies a version of a file
+type key struct {
+	dev, ino uint64
+	size     int64
+	mtime    int64 // unix nanoseconds
+	ctime    int64 // unix nanoseconds; 0 if not available
+}
+
+// entry of the cache; the path is kept for pruning
+type entry struct {
+	path string
+	sum  string
+}
+
+// Cache of file hashes. Use Open to create one, Save to write it back.
+type Cache struct {
+	Path  string // file the cache is stored in
+	Xattr bool   // also read and write hashes from / to extended attributes of the files
+
+	mu      sync.Mutex
+	entries map[key]entry
+	dirty   bool
+}
+
+// DefaultPath returns the default location of the cache file in the user's cache directory
+func DefaultPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gosyncit", "hashcache"), nil
+}
+
+// Open loads the cache from file 'path'. A missing file is an empty cache.
+func Open(path string) (*Cache, error) {
+	c := &Cache{Path: path, entries: make(map[key]entry)}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if scanner.Scan() && scanner.Text() == headerV1 {
+		c.dirty = true // rewritten in the current format by Save
+		return c, nil
+	}
+	if scanner.Text() != header {
+		return nil, fmt.Errorf("'%s' is not a hash cache file", path)
+	}
+	line := 1
+	for scanner.Scan() {
+		line++
+		k, e, err := parseLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("'%s' line %v: %v", path, line, err)
+		}
+		c.entries[k] = e
+	}
+	return c, scanner.Err()
+}
+
+// parseLine parses 'dev ino size mtime ctime sha256 "path"'
+func parseLine(s string) (key, entry, error) {
+	var k key
+	var e entry
+	fields := strings.SplitN(s, " ", 7)
+	if len(fields) != 7 {
+		return k, e, errors.New("invalid number of fields")
+	}
+	var err error
+	if k.dev, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+		return k, e, err
+	}
+	if k.ino, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+		return k, e, err
+	}
+	if k.size, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+		return k, e, err
+	}
+	if k.mtime, err = strconv.ParseInt(fields[3], 10, 64); err != nil {
+		return k, e, err
+	}
+	if k.ctime, err = strconv.ParseInt(fields[4], 10, 64); err != nil {
+		return k, e, err
+	}
+	e.sum = fields[5]
+	if e.path, err = strconv.Unquote(fields[6]); err != nil {
+		return k, e, err
+	}
+	return k, e, nil
+}
+
+// Len returns the number of entries in the cache
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Hash returns the hex-encoded SHA-256 hash of file 'path' with os.FileInfo 'info',
+// from the cache if the file did not change, by reading the file otherwise.
+func (c *Cache) Hash(path string, info os.FileInfo) (string, error) {
+	k, ok := keyOf(info)
+	if !ok { // no inode information on this platform
+		return manifest.HashFile(path)
+	}
+
+	c.mu.Lock()
+	e, ok := c.entries[k]
+	c.mu.Unlock()
+	if ok {
+		return e.sum, nil
+	}
+
+	if c.Xattr {
+		if sum, ok := readXattr(path, k); ok {
+			c.put(k, path, sum)
+			return sum, nil
+		}
+	}
+
+	sum, err := manifest.HashFile(path)
+	if err != nil {
+		return "", err
+	}
+	if c.Xattr && writeXattr(path, k, sum) == nil { // optional; the file system may not support it
+		// writing the attribute changed the ctime; record the file as it is now
+		if info, err := os.Stat(path); err == nil {
+			if current, ok := keyOf(info); ok && current.dev == k.dev && current.ino == k.ino &&
+				current.size == k.size && current.mtime == k.mtime {
+				k = current
+			}
+		}
+	}
+	c.put(k, path, sum)
+	return sum, nil
+}
+
+// Recorded returns the hash recorded for file 'path' with os.FileInfo 'info', from the cache or,
+// with Xattr, from the extended attribute, without reading the file. ok is false if there is
+// none for this version of the file.
+func (c *Cache) Recorded(path string, info os.FileInfo) (sum string, ok bool) {
+	k, ok := keyOf(info)
+	if !ok {
+		return "", false
+	}
+	c.mu.Lock()
+	e, ok := c.entries[k]
+	c.mu.Unlock()
+	if ok {
+		return e.sum, true
+	}
+	if c.Xattr {
+		return readXattr(path, k)
+	}
+	return "", false
+}
+
+// Files returns the absolute paths of the files below directory 'dir' that have an entry in the
+// cache, with the hash of their most recent version.
+func (c *Cache) Files(dir string) (map[string]string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	files := make(map[string]string)
+	latest := make(map[string]key)
+	for k, e := range c.entries {
+		rel, err := filepath.Rel(dir, e.path)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		l, ok := latest[e.path]
+		if !ok || k.ctime > l.ctime || (k.ctime == l.ctime && k.mtime > l.mtime) {
+			files[e.path], latest[e.path] = e.sum, k
+		}
+	}
+	return files, nil
+}
+
+func (c *Cache) put(k key, path, sum string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	c.mu.Lock()
+	c.entries[k] = entry{path, sum}
+	c.dirty = true
+	c.mu.Unlock()
+}
+
+// Prune removes the entries of files that no longer exist or changed since they were hashed.
+// Returns the number of removed entries.
+func (c *Cache) Prune() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for k, e := range c.entries {
+		info, err := os.Stat(e.path)
+		if err == nil {
+			if current, ok := keyOf(info); ok && current == k {
+				continue
+			}
+		}
+		delete(c.entries, k)
+		n++
+	}
+	if n > 0 {
+		c.dirty = true
+	}
+	return n
+}
+
+// Save writes the cache to its file, if it changed. The file is replaced atomically.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.Path), "."+filepath.Base(c.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails after successful rename; no problem
+
+	w := bufio.NewWriter(tmp)
+	fmt.Fprintln(w, header)
+	for k, e := range c.entries {
+		fmt.Fprintf(w, "%d %d %d %d %d %s %s\n", k.dev, k.ino, k.size, k.mtime, k.ctime, e.sum, strconv.Quote(e.path))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.Path); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// xattrValue encodes a hash with the size and mtime it is valid for, and the time the value
+// is written at, which becomes the ctime of the file (see xattrSlack)
+func xattrValue(k key, written time.Time, sum string) string {
+	return fmt.Sprintf("%d %d %d %s", k.size, k.mtime, written.UnixNano(), sum)
+}
+
+// parseXattr returns the hash from an extended attribute value, if it is valid for k
+func parseXattr(value string, k key) (string, bool) {
+	fields := strings.Fields(value)
+	if len(fields) != 4 || fields[0] != strconv.FormatInt(k.size, 10) || fields[1] != strconv.FormatInt(k.mtime, 10) {
+		return "", false
+	}
+	written, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || k.ctime > written+xattrSlack.Nanoseconds() || k.ctime < written-time.Second.Nanoseconds() {
+		return "", false // changed after the attribute was written (or before, on a file system with 1s ctime)
+	}
+	if _, err := hex.DecodeString(fields[3]); err != nil || len(fields[3]) != 2*sha256.Size {
+		return "", false
+	}
+	return fields[3], true
+}
@@ -0,0 +1,139 @@
+package hashcache_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/FObersteiner/gosyncit/lib/hashcache"
+)
+
+func sum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func TestCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no inode information")
+	}
+	dir, err := os.MkdirTemp("", "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cachefile := filepath.Join(dir, "cache", "hashes")
+	c, err := hashcache.Open(cachefile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mtime := time.Date(2006, time.March, 1, 3, 4, 5, 0, time.UTC)
+	write := func(path, content string) os.FileInfo {
+		time.Sleep(20 * time.Millisecond) // a distinct ctime for each version
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		info, _ := os.Stat(path)
+		return info
+	}
+	fileA := filepath.Join(dir, "a b") // space in name
+	infoA := write(fileA, "content a")
+	fileB := filepath.Join(dir, "b")
+	infoB := write(fileB, "content b")
+
+	for path, info := range map[string]os.FileInfo{fileA: infoA, fileB: infoB} {
+		h, err := c.Hash(path, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if content, _ := os.ReadFile(path); h != sum(string(content)) {
+			t.Logf("wrong hash for '%s'", path)
+			t.Fail()
+		}
+	}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// hashes come from the cache as long as the file is unchanged
+	c, err = hashcache.Open(cachefile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 2 {
+		t.Fatalf("want 2 entries, have %v", c.Len())
+	}
+	if h, ok := c.Recorded(fileA, infoA); !ok || h != sum("content a") {
+		t.Log("expected cached hash")
+		t.Fail()
+	}
+
+	// rewriting in place with the same size and mtime changes the ctime, which invalidates
+	infoA = write(fileA, "CONTENT a")
+	if h, _ := c.Hash(fileA, infoA); h != sum("CONTENT a") {
+		t.Log("expected new hash after rewrite with the same size and mtime")
+		t.Fail()
+	}
+
+	// changed mtime invalidates
+	mtime = mtime.Add(time.Second)
+	infoA = write(fileA, "CONTENT a")
+	if h, _ := c.Hash(fileA, infoA); h != sum("CONTENT a") {
+		t.Log("expected new hash after modification")
+		t.Fail()
+	}
+
+	// recorded hashes, without reading the files
+	if h, ok := c.Recorded(fileA, infoA); !ok || h != sum("CONTENT a") {
+		t.Log("expected recorded hash of current version")
+		t.Fail()
+	}
+	mtime = mtime.Add(time.Second)
+	infoA = write(fileA, "content A")
+	if _, ok := c.Recorded(fileA, infoA); ok {
+		t.Log("expected no recorded hash for unknown version")
+		t.Fail()
+	}
+	files, err := c.Files(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[fileA] != sum("CONTENT a") || files[fileB] != sum("content b") {
+		t.Logf("want latest hashes of both files, got %v", files)
+		t.Fail()
+	}
+	if files, _ := c.Files(filepath.Join(dir, "cache")); len(files) != 0 {
+		t.Logf("want no files outside of dir, got %v", files)
+		t.Fail()
+	}
+	if _, err := c.Hash(fileA, infoA); err != nil {
+		t.Fatal(err)
+	}
+
+	// old entries of 'a' and removed 'b'
+	_ = os.Remove(fileB)
+	if n := c.Prune(); n != 4 || c.Len() != 1 {
+		t.Logf("prune: want 4 removed and 1 left, have %v and %v", n, c.Len())
+		t.Fail()
+	}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	c, err = hashcache.Open(cachefile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 1 {
+		t.Logf("want 1 entry after prune, have %v", c.Len())
+		t.Fail()
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package hashcache
+
+import "os"
+
+// keyOf is not implemented on this platform; files are always hashed.
+func keyOf(info os.FileInfo) (key, bool) {
+	return key{}, false
+}
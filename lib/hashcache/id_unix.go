@@ -0,0 +1,23 @@
+//go:build unix
+
+package hashcache
+
+import (
+	"os"
+	"syscall"
+)
+
+// keyOf returns the cache key of a file; ok is false if it is not available
+func keyOf(info os.FileInfo) (key, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return key{}, false
+	}
+	return key{
+		dev:   uint64(st.Dev),
+		ino:   uint64(st.Ino),
+		size:  info.Size(),
+		mtime: info.ModTime().UnixNano(),
+		ctime: changeTime(st),
+	}, true
+}
@@ -0,0 +1,22 @@
+package hashcache
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattr returns the hash stored in the extended attribute of file 'path', if it is valid for k
+func readXattr(path string, k key) (string, bool) {
+	buf := make([]byte, 256)
+	n, err := unix.Getxattr(path, XattrName, buf)
+	if err != nil {
+		return "", false
+	}
+	return parseXattr(string(buf[:n]), k)
+}
+
+// writeXattr stores a hash in the extended attribute of file 'path'
+func writeXattr(path string, k key, sum string) error {
+	return unix.Setxattr(path, XattrName, []byte(xattrValue(k, time.Now(), sum)), 0)
+}
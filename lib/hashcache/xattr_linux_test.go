@@ -0,0 +1,67 @@
+package hashcache_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/FObersteiner/gosyncit/lib/hashcache"
+)
+
+func TestXattr(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mtime := time.Date(2006, time.March, 1, 3, 4, 5, 0, time.UTC)
+	path := filepath.Join(dir, "file")
+	write := func(content string) os.FileInfo {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		info, _ := os.Stat(path)
+		return info
+	}
+	info := write("content")
+	if err := unix.Setxattr(path, hashcache.XattrName, []byte("test"), 0); err != nil {
+		t.Skip("file system does not support user xattrs:", err)
+	}
+
+	c, _ := hashcache.Open(filepath.Join(dir, "cache1"))
+	c.Xattr = true
+	if _, err := c.Hash(path, info); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new cache finds the hash in the xattr, for the unchanged file
+	info, _ = os.Stat(path)
+	c, _ = hashcache.Open(filepath.Join(dir, "cache2"))
+	c.Xattr = true
+	if h, ok := c.Recorded(path, info); !ok || h != sum("content") {
+		t.Log("expected hash from xattr")
+		t.Fail()
+	}
+
+	// rewriting in place with the same size and mtime changes the ctime, which invalidates
+	time.Sleep(20 * time.Millisecond)
+	info = write("CONTENT")
+	if h, _ := c.Hash(path, info); h != sum("CONTENT") {
+		t.Log("expected new hash after rewrite with the same size and mtime")
+		t.Fail()
+	}
+
+	mtime = mtime.Add(time.Second)
+	info = write("CONTENT")
+	if h, _ := c.Hash(path, info); h != sum("CONTENT") {
+		t.Log("expected new hash after modification")
+		t.Fail()
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package hashcache
+
+import "errors"
+
+// readXattr is not implemented on this platform
+func readXattr(path string, k key) (string, bool) {
+	return "", false
+}
+
+// writeXattr is not implemented on this platform
+func writeXattr(path string, k key, sum string) error {
+	return errors.ErrUnsupported
+}
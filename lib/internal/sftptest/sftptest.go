@@ -0,0 +1,75 @@
+// Package sftptest provides SFTP clients connected to in-process servers for tests.
+package sftptest
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// Pipe returns an SFTP client connected to an in-process server
+// that operates on the local file system.
+func Pipe(t testing.TB, opts ...sftp.ClientOption) *sftp.Client {
+	return PipeLatency(t, 0, opts...)
+}
+
+// PipeLatency is Pipe with a delay of the data sent by the client,
+// to simulate a network connection with the given (one way) latency.
+func PipeLatency(t testing.TB, latency time.Duration, opts ...sftp.ClientOption) *sftp.Client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	var r io.Reader = sr
+	if latency > 0 {
+		r = delayed(sr, latency)
+	}
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{r, sw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = server.Serve() }()
+	sc, err := sftp.NewClientPipe(cr, cw, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close(); sc.Close() })
+	return sc
+}
+
+// delayed returns a reader that returns the data of r after a delay d.
+// Unlike a sleep per read, data that is in flight does not hold back later data.
+func delayed(r io.Reader, d time.Duration) io.Reader {
+	type chunk struct {
+		data []byte
+		at   time.Time
+	}
+	chunks := make(chan chunk, 1024)
+	go func() {
+		defer close(chunks)
+		for {
+			buf := make([]byte, 32*1024)
+			n, err := r.Read(buf)
+			if n > 0 {
+				chunks <- chunk{buf[:n], time.Now().Add(d)}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	pr, pw := io.Pipe()
+	go func() {
+		for c := range chunks {
+			time.Sleep(time.Until(c.at))
+			if _, err := pw.Write(c.data); err != nil {
+				return
+			}
+		}
+		pw.Close()
+	}()
+	return pr
+}
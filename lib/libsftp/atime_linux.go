@@ -0,0 +1,16 @@
+package libsftp
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// accessTime of a local file; the modification time if it is not available
+func accessTime(info os.FileInfo) time.Time {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime()
+	}
+	return time.Unix(st.Atim.Unix())
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package libsftp
+
+import (
+	"os"
+	"time"
+)
+
+// accessTime of a local file; not available on this platform, so the modification time
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
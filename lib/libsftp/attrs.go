@@ -0,0 +1,87 @@
+package libsftp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// Preserve selects the file attributes that are carried over by UploadFileWith and
+// DownloadFileWith, in addition to the modification time.
+type Preserve struct {
+	Atime bool // access time
+	Perms bool // permission bits
+}
+
+// ErrSetstat is returned if the SFTP server rejects setting file attributes (setstat).
+var ErrSetstat = errors.New("server rejected setting file attributes")
+
+// SetAttrs sets the modification time (and the attributes selected by 'p') of 'remoteFile'
+// on the SFTP server to those of a local or remote file with os.FileInfo 'info'.
+// Errors are wrapped in ErrSetstat.
+func SetAttrs(sc *sftp.Client, remoteFile string, info os.FileInfo, p Preserve) error {
+	mtime := info.ModTime()
+	atime := mtime
+	if st, ok := info.Sys().(*sftp.FileStat); ok && p.Atime {
+		atime = time.Unix(int64(st.Atime), 0)
+	} else if p.Atime {
+		atime = accessTime(info)
+	}
+	if err := sc.Chtimes(remoteFile, atime, mtime); err != nil {
+		return fmt.Errorf("%w: %v", ErrSetstat, err)
+	}
+	if p.Perms {
+		if err := sc.Chmod(remoteFile, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("%w: %v", ErrSetstat, err)
+		}
+	}
+	return nil
+}
+
+// CheckSetstat tests if the SFTP server sets modification times, with a probe file in directory 'dir'
+// that is removed afterwards. Some servers reject setstat, others silently ignore it;
+// in both cases, an error wrapping ErrSetstat is returned.
+func CheckSetstat(sc *sftp.Client, dir string) error {
+	name := filepath.Join(dir, fmt.Sprintf(".gosyncit-setstat-%d-%d", os.Getpid(), time.Now().UnixNano()))
+	f, err := sc.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return fmt.Errorf("unable to create probe file: %v", err)
+	}
+	defer sc.Remove(name)
+	if err := f.Close(); err != nil {
+		return err
+	}
+	want := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+	if err := sc.Chtimes(name, want, want); err != nil {
+		return fmt.Errorf("%w: %v", ErrSetstat, err)
+	}
+	info, err := sc.Stat(name)
+	if err != nil {
+		return err
+	}
+	if !info.ModTime().Equal(want) {
+		return fmt.Errorf("%w: modification time was not set", ErrSetstat)
+	}
+	return nil
+}
+
+// setLocalAttrs sets the modification time (and the attributes selected by 'p') of 'localFile'
+// to those of a remote file with os.FileInfo 'info'.
+func setLocalAttrs(localFile string, info os.FileInfo, p Preserve) error {
+	mtime := info.ModTime()
+	atime := mtime
+	if st, ok := info.Sys().(*sftp.FileStat); ok && p.Atime {
+		atime = time.Unix(int64(st.Atime), 0)
+	}
+	if err := os.Chtimes(localFile, atime, mtime); err != nil {
+		return err
+	}
+	if p.Perms {
+		return os.Chmod(localFile, info.Mode().Perm())
+	}
+	return nil
+}
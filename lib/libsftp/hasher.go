@@ -0,0 +1,221 @@
+package libsftp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// hashTool is a checksum program that might exist on the server
+type hashTool struct {
+	algo  string           // name of the hash algorithm
+	cmd   string           // command to run, file names are appended
+	empty string           // hash of no input, to check that the command works
+	new   func() hash.Hash // local implementation of the algorithm
+}
+
+// hashTools in order of preference. b3sum is not used: there is no local BLAKE3 implementation
+// among the dependencies. The SFTP 'check-file' extension is not supported by pkg/sftp's client.
+var hashTools = []hashTool{
+	{"sha256", "sha256sum", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", sha256.New},
+	{"sha256", "shasum -a 256", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", sha256.New},
+	{"md5", "md5sum", "d41d8cd98f00b204e9800998ecf8427e", md5.New},
+}
+
+// maxArgs is the maximum length of the file names passed to one run of a checksum program
+const maxArgs = 32 * 1024
+
+// Hasher computes hashes of files on an SFTP server. If the server allows running commands,
+// a checksum program is run via SSH exec, for all files of a directory that were announced with
+// Want at once. Otherwise, or for files the program fails on, the file is read via SFTP and
+// hashed locally (sha256). Use LocalHash to hash local files with the same algorithm.
+type Hasher struct {
+	sc   *sftp.Client
+	conn *ssh.Client
+	tool *hashTool // nil: stream via SFTP
+
+	mu     sync.Mutex
+	sums   map[string]string   // hashes by path
+	wanted map[string][]string // file names to hash with the next batch, by directory
+}
+
+// NewHasher returns a Hasher for the server of 'sc'. Available checksum programs are probed
+// via 'conn'; if conn is nil or the server does not allow running commands, files are streamed.
+func NewHasher(sc *sftp.Client, conn *ssh.Client) *Hasher {
+	h := &Hasher{sc: sc, conn: conn, sums: make(map[string]string), wanted: make(map[string][]string)}
+	if conn == nil {
+		return h
+	}
+	for i, t := range hashTools {
+		out, err := h.run(t.cmd)
+		if err == nil && strings.HasPrefix(string(out), t.empty) {
+			h.tool = &hashTools[i]
+			break
+		}
+	}
+	return h
+}
+
+// Algo returns the hash algorithm, e.g. "sha256"
+func (h *Hasher) Algo() string {
+	if h.tool == nil {
+		return "sha256"
+	}
+	return h.tool.algo
+}
+
+// Exec returns the checksum program run on the server, or "" if files are streamed
+func (h *Hasher) Exec() string {
+	if h.tool == nil {
+		return ""
+	}
+	return h.tool.cmd
+}
+
+// LocalHash returns the hex-encoded hash of local file 'name', with the algorithm of the Hasher
+func (h *Hasher) LocalHash(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return h.sum(f)
+}
+
+func (h *Hasher) sum(r io.Reader) (string, error) {
+	hh := sha256.New()
+	if h.tool != nil {
+		hh = h.tool.new()
+	}
+	if _, err := io.Copy(hh, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hh.Sum(nil)), nil
+}
+
+// Want announces that file 'name' on the server will be hashed. Hash then runs the checksum
+// program once for all announced files of a directory, instead of once per file.
+func (h *Hasher) Want(name string) {
+	if h.tool == nil {
+		return
+	}
+	dir, base := path.Split(name)
+	h.mu.Lock()
+	h.wanted[dir] = append(h.wanted[dir], base)
+	h.mu.Unlock()
+}
+
+// Hash returns the hex-encoded hash of file 'name' on the server. The files of the same directory
+// announced with Want are hashed along with it.
+func (h *Hasher) Hash(name string) (string, error) {
+	if h.tool != nil {
+		dir, base := path.Split(name)
+		h.mu.Lock()
+		sum, ok := h.sums[name]
+		names := h.wanted[dir]
+		delete(h.wanted, dir)
+		h.mu.Unlock()
+		if ok {
+			return sum, nil
+		}
+		sums := h.hashFiles(dir, append(names, base))
+		h.mu.Lock()
+		for base, sum := range sums {
+			h.sums[path.Join(dir, base)] = sum
+		}
+		h.mu.Unlock()
+		if sum, ok := sums[base]; ok {
+			return sum, nil
+		}
+	}
+
+	f, err := h.sc.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return h.sum(f)
+}
+
+// hashFiles runs the checksum program for files 'names' in 'dir' on the server.
+// Files that fail are missing from the result.
+func (h *Hasher) hashFiles(dir string, names []string) map[string]string {
+	sums := make(map[string]string)
+	var args []string
+	n := 0
+	flush := func() {
+		if len(args) == 0 {
+			return
+		}
+		// the program exits with an error if any file fails; use what it printed anyway
+		out, _ := h.run(fmt.Sprintf("cd %s && %s -- %s", quote(path.Clean(dir)), h.tool.cmd, strings.Join(args, " ")))
+		for name, sum := range parseSums(out) {
+			sums[name] = sum
+		}
+		args, n = nil, 0
+	}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		q := quote(name)
+		if n+len(q) > maxArgs {
+			flush()
+		}
+		args = append(args, q)
+		n += len(q) + 1
+	}
+	flush()
+	return sums
+}
+
+// run a command on the server, returning its output
+func (h *Hasher) run(cmd string) ([]byte, error) {
+	session, err := h.conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	return session.Output(cmd)
+}
+
+// quote a string for a POSIX shell
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// parseSums parses the output of sha256sum and the like: 'hash  name' or 'hash *name' per line.
+// A line starting with a backslash has escaped newlines and backslashes in the name.
+func parseSums(out []byte) map[string]string {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		escaped := strings.HasPrefix(line, `\`)
+		line = strings.TrimPrefix(line, `\`)
+		sum, name, ok := strings.Cut(line, " ")
+		if !ok || len(name) == 0 {
+			continue
+		}
+		name = name[1:] // ' ' for text mode, '*' for binary mode
+		if escaped {
+			name = strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(name)
+		}
+		sums[name] = sum
+	}
+	return sums
+}
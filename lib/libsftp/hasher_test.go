@@ -0,0 +1,209 @@
+package libsftp_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/FObersteiner/gosyncit/lib/libsftp"
+)
+
+// sshServer starts an SSH server on a local port, serving the sftp subsystem and,
+// if allowExec is set, running exec requests with 'sh -c'. Returns a connected client.
+func sshServer(t testing.TB, allowExec bool) *ssh.Client {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(nc, config, allowExec)
+		}
+	}()
+
+	conn, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// execs records the commands run by the servers of sshServer
+var execs struct {
+	sync.Mutex
+	cmds []string
+}
+
+func serveSSH(nc net.Conn, config *ssh.ServerConfig, allowExec bool) {
+	_, chans, reqs, err := ssh.NewServerConn(nc, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			_ = newCh.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			for req := range chReqs {
+				var payload struct{ Value string }
+				_ = ssh.Unmarshal(req.Payload, &payload)
+				switch {
+				case req.Type == "subsystem" && payload.Value == "sftp":
+					_ = req.Reply(true, nil)
+					server, err := sftp.NewServer(ch)
+					if err == nil {
+						_ = server.Serve()
+					}
+					return
+				case req.Type == "exec" && allowExec:
+					_ = req.Reply(true, nil)
+					execs.Lock()
+					execs.cmds = append(execs.cmds, payload.Value)
+					execs.Unlock()
+					cmd := exec.Command("sh", "-c", payload.Value)
+					cmd.Stdin, cmd.Stdout, cmd.Stderr = ch, ch, ch.Stderr()
+					status := 0
+					if err := cmd.Run(); err != nil {
+						status = 1
+					}
+					_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+					return
+				default:
+					_ = req.Reply(false, nil)
+				}
+			}
+		}()
+	}
+}
+
+func TestHasher(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"a": "content a", "it's b": "content b", "c\nd": "content c"}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, allowExec := range []bool{true, false} {
+		conn := sshServer(t, allowExec)
+		sc, err := sftp.NewClient(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sc.Close()
+
+		h := libsftp.NewHasher(sc, conn)
+		if allowExec && h.Exec() == "" {
+			if _, err := exec.LookPath("sha256sum"); err == nil {
+				t.Log("expected sha256sum to be found")
+				t.Fail()
+			}
+		}
+		if !allowExec && h.Exec() != "" {
+			t.Logf("exec not allowed, but got %q", h.Exec())
+			t.Fail()
+		}
+		if h.Algo() != "sha256" && h.Algo() != "md5" {
+			t.Fatalf("unexpected algorithm %q", h.Algo())
+		}
+
+		for name, content := range files {
+			got, err := h.Hash(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			local, err := h.LocalHash(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != local {
+				t.Logf("exec %v, %q: remote hash %v, local hash %v", allowExec, name, got, local)
+				t.Fail()
+			}
+			if h.Algo() == "sha256" {
+				sum := sha256.Sum256([]byte(content))
+				if got != hex.EncodeToString(sum[:]) {
+					t.Logf("exec %v, %q: wrong hash %v", allowExec, name, got)
+					t.Fail()
+				}
+			}
+		}
+	}
+}
+
+func TestHasherWant(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("content "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn := sshServer(t, true)
+	sc, err := sftp.NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	h := libsftp.NewHasher(sc, conn)
+	if h.Exec() == "" {
+		t.Skip("no checksum program")
+	}
+
+	// a and b are hashed together, c is not
+	h.Want(filepath.Join(dir, "a"))
+	h.Want(filepath.Join(dir, "b"))
+	execs.Lock()
+	n := len(execs.cmds)
+	execs.Unlock()
+	for _, name := range []string{"b", "a"} {
+		if _, err := h.Hash(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	execs.Lock()
+	cmds := execs.cmds[n:]
+	execs.Unlock()
+	if len(cmds) != 1 || !strings.Contains(cmds[0], "'a'") || !strings.Contains(cmds[0], "'b'") || strings.Contains(cmds[0], "'c'") {
+		t.Logf("want one run for a and b, got %q", cmds)
+		t.Fail()
+	}
+}
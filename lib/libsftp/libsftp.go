@@ -2,6 +2,9 @@ package libsftp
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -18,17 +21,38 @@ import (
 
 // Credentials for SSH auth
 type Credentials struct {
-	Usr        string
-	Host       string
-	AgentSock  string
-	Port       int
-	SSHtimeout time.Duration
+	Usr       string
+	Host      string
+	AgentSock string
+	Port      int
+	SSH       SSHOptions
 }
 
 func (c *Credentials) String() string {
 	return fmt.Sprintf("User: %v, on: %v:%v", c.Usr, c.Host, c.Port)
 }
 
+// ParseURL splits a remote location of the form [user@]host:path. ok is false if 's' is
+// a local path, i.e. it has no colon, a slash before the first colon, or a single-letter
+// host like a Windows drive letter.
+func ParseURL(s string) (usr, host, path string, ok bool) {
+	i := strings.Index(s, ":")
+	if i < 0 || strings.Contains(s[:i], "/") {
+		return "", "", "", false
+	}
+	host, path = s[:i], s[i+1:]
+	if j := strings.LastIndex(host, "@"); j >= 0 {
+		usr, host = host[:j], host[j+1:]
+	}
+	if len(host) < 2 {
+		return "", "", "", false
+	}
+	if path == "" {
+		path = "."
+	}
+	return usr, host, path, true
+}
+
 // GetSSHconn tries to establish an SSH connection with given Credentials
 func GetSSHconn(creds Credentials) (*ssh.Client, error) {
 	// access keyring to unlock SSH key later on.
@@ -58,15 +82,12 @@ func GetSSHconn(creds Credentials) (*ssh.Client, error) {
 		Auth: []ssh.AuthMethod{
 			ssh.PublicKeys(signers...),
 		},
-		// the default host key is ssh-rsa:
-		HostKeyAlgorithms: []string{"ssh-rsa"},
 		// HostKeyCallback:   ssh.InsecureIgnoreHostKey(),
 		HostKeyCallback: ssh.FixedHostKey(hostKey),
-		Timeout:         creds.SSHtimeout,
 	}
 
 	// Connect to server via SSH
-	return ssh.Dial("tcp", fmt.Sprintf("%v:%v", creds.Host, creds.Port), sshConfig)
+	return creds.SSH.Dial(fmt.Sprintf("%v:%v", creds.Host, creds.Port), sshConfig)
 }
 
 // GetHostKey from local known hosts
@@ -115,59 +136,221 @@ func ListDirsFiles(sc *sftp.Client, remoteDir string) (fsinfo []fs.FileInfo, err
 	return fsinfo, err
 }
 
-// UploadFile to SFTP server. The directory path on the remote must exist.
+// UploadFile to SFTP server and set its modification time, see UploadFileWith.
 func UploadFile(sc *sftp.Client, localFile, remoteFile string) (n int64, err error) {
+	return UploadFileWith(sc, localFile, remoteFile, Preserve{})
+}
+
+// UploadFileWith uploads a file to the SFTP server. The directory path on the remote must exist.
+// The modification time and the attributes selected by 'p' are set on the remote file;
+// if the server rejects that, an error wrapping ErrSetstat is returned, after the content was uploaded.
+func UploadFileWith(sc *sftp.Client, localFile, remoteFile string, p Preserve) (n int64, err error) {
 	srcFile, err := os.Open(localFile)
 	if err != nil {
 		return 0, fmt.Errorf("unable to open local file: %v", err)
 	}
 	defer srcFile.Close()
 
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("unable to get local file stats: %v", err)
+	}
+
 	dstFile, err := sc.OpenFile(remoteFile, (os.O_WRONLY | os.O_CREATE | os.O_TRUNC))
 	if err != nil {
 		return 0, fmt.Errorf("unable to open remote file: %v", err)
 	}
-	defer dstFile.Close()
 
 	n, err = io.Copy(dstFile, srcFile)
 	if err != nil {
+		dstFile.Close()
 		return 0, fmt.Errorf("unable to upload local file: %v", err)
 	}
-  
-	// srcInfo, err := os.Stat(localFile)
-	// if err != nil {
-	// 	return n, fmt.Errorf("unable to get local file stats: %v", err)
-	// }
+	// close first, the server might update the mtime on close
+	if err := dstFile.Close(); err != nil {
+		return n, fmt.Errorf("unable to close remote file: %v", err)
+	}
 
-	// mtime := srcInfo.ModTime()
-	// err = sc.Chtimes(remoteFile, mtime, mtime)
-	// if err != nil {
-	// 	return n, fmt.Errorf("unable to set local file timestamp to remote file: %v", err)
-	// }
+	return n, SetAttrs(sc, remoteFile, srcInfo, p)
+}
+
+// UploadFileDelta updates an existing file on the SFTP server in place: the remote file is
+// read back chunk by chunk, and only chunks that differ from the local file are
+// written, using WriteAt. Finally, the remote file is truncated to the size of the local file.
+// n is the number of bytes written. If the remote file does not exist or cannot be opened for
+// reading and writing, this falls back to UploadFile.
+// Since the remote file is modified in place, it is inconsistent while the upload is in progress.
+func UploadFileDelta(sc *sftp.Client, localFile, remoteFile string, chunkSize int) (n int64, err error) {
+	srcFile, err := os.Open(localFile)
+	if err != nil {
+		return 0, fmt.Errorf("unable to open local file: %v", err)
+	}
+	defer srcFile.Close()
+
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("unable to get local file stats: %v", err)
+	}
+
+	dstFile, err := sc.OpenFile(remoteFile, os.O_RDWR)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return UploadFile(sc, localFile, remoteFile)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to open remote file: %v", err)
+	}
+	defer dstFile.Close()
+
+	bufSrc := make([]byte, chunkSize)
+	bufDst := make([]byte, chunkSize)
+	for off := int64(0); off < srcInfo.Size(); off += int64(chunkSize) {
+		nSrc, err := srcFile.ReadAt(bufSrc, off)
+		if err != nil && err != io.EOF {
+			return n, fmt.Errorf("unable to read local file: %v", err)
+		}
+		nDst, err := dstFile.ReadAt(bufDst, off)
+		if err != nil && err != io.EOF {
+			return n, fmt.Errorf("unable to read remote file: %v", err)
+		}
+		// a longer remote file is truncated below; only compare what is needed
+		if nDst >= nSrc && bytes.Equal(bufSrc[:nSrc], bufDst[:nSrc]) {
+			continue
+		}
+		written, err := dstFile.WriteAt(bufSrc[:nSrc], off)
+		n += int64(written)
+		if err != nil {
+			return n, fmt.Errorf("unable to patch remote file: %v", err)
+		}
+	}
+
+	if err := dstFile.Truncate(srcInfo.Size()); err != nil {
+		return n, fmt.Errorf("unable to truncate remote file: %v", err)
+	}
 
 	return n, nil
 }
 
-// DownloadFile from SFTP server
+// DownloadFile from SFTP server and set its modification time, see DownloadFileWith.
 func DownloadFile(sc *sftp.Client, remoteFile, localFile string) (n int64, err error) {
+	return DownloadFileWith(sc, remoteFile, localFile, Preserve{})
+}
+
+// DownloadFileWith downloads a file from the SFTP server. The modification time and
+// the attributes selected by 'p' are set on the local file.
+func DownloadFileWith(sc *sftp.Client, remoteFile, localFile string, p Preserve) (n int64, err error) {
 	srcFile, err := sc.OpenFile(remoteFile, (os.O_RDONLY))
 	if err != nil {
 		return 0, fmt.Errorf("unable to open remote file: %v", err)
 	}
 	defer srcFile.Close()
 
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("unable to get remote file stats: %v", err)
+	}
+
 	dstFile, err := os.Create(localFile)
 	if err != nil {
 		return 0, fmt.Errorf("unable to open local file: %v", err)
 	}
-	defer dstFile.Close()
 
 	n, err = io.Copy(dstFile, srcFile)
 	if err != nil {
+		dstFile.Close()
 		return 0, fmt.Errorf("unable to download remote file: %v", err)
 	}
+	if err := dstFile.Close(); err != nil {
+		return n, err
+	}
+
+	if err := setLocalAttrs(localFile, srcInfo, p); err != nil {
+		return n, fmt.Errorf("unable to set local file attributes: %v", err)
+	}
+	return n, nil
+}
+
+// DeepEqual returns true if the content of 'localFile' and 'remoteFile' on the SFTP server is equal.
+// The remote file is read completely, unless the sizes differ.
+func DeepEqual(sc *sftp.Client, localFile, remoteFile string) (bool, error) {
+	srcFile, err := os.Open(localFile)
+	if err != nil {
+		return false, fmt.Errorf("unable to open local file: %v", err)
+	}
+	defer srcFile.Close()
+
+	dstFile, err := sc.Open(remoteFile)
+	if err != nil {
+		return false, fmt.Errorf("unable to open remote file: %v", err)
+	}
+	defer dstFile.Close()
+
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return false, err
+	}
+	dstInfo, err := dstFile.Stat()
+	if err != nil {
+		return false, err
+	}
+	if srcInfo.Size() != dstInfo.Size() {
+		return false, nil
+	}
+
+	hSrc, hDst := sha256.New(), sha256.New()
+	if _, err := io.Copy(hSrc, srcFile); err != nil {
+		return false, err
+	}
+	if _, err := dstFile.WriteTo(hDst); err != nil {
+		return false, err
+	}
+	return bytes.Equal(hSrc.Sum(nil), hDst.Sum(nil)), nil
+}
+
+// ErrNoHardlinks is returned by Link if the SFTP server does not support hard links.
+var ErrNoHardlinks = errors.New("server does not support the hardlink extension")
+
+// Link creates 'newname' on the SFTP server as a hard link to 'oldname', using the
+// hardlink@openssh.com extension. An existing file 'newname' is replaced.
+func Link(sc *sftp.Client, oldname, newname string) error {
+	if _, ok := sc.HasExtension("hardlink@openssh.com"); !ok {
+		return ErrNoHardlinks
+	}
+	if err := sc.Remove(newname); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return sc.Link(oldname, newname)
+}
+
+// Rename 'oldname' to 'newname' on the SFTP server. The posix-rename extension is used if the server
+// supports it, since a plain SFTP rename fails if 'newname' exists.
+func Rename(sc *sftp.Client, oldname, newname string) error {
+	if _, ok := sc.HasExtension("posix-rename@openssh.com"); ok {
+		return sc.PosixRename(oldname, newname)
+	}
+	return sc.Rename(oldname, newname)
+}
 
-	return n, err
+// ClockOffset estimates how far the clock of the SFTP server is ahead of the local clock, by creating
+// a probe file in directory 'dir' on the server and reading back its mtime. The probe file is removed.
+// SFTP only transfers whole seconds, so the offset is rounded to seconds and accurate to about one second.
+func ClockOffset(sc *sftp.Client, dir string) (time.Duration, error) {
+	name := filepath.Join(dir, fmt.Sprintf(".gosyncit-clock-%d-%d", os.Getpid(), time.Now().UnixNano()))
+	before := time.Now()
+	f, err := sc.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return 0, fmt.Errorf("unable to create probe file: %v", err)
+	}
+	defer sc.Remove(name)
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	info, err := sc.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+	mid := before.Add(time.Since(before) / 2)
+	// the server truncates to seconds, so its clock was half a second ahead of the mtime on average
+	return info.ModTime().Add(time.Second / 2).Sub(mid).Round(time.Second), nil
 }
 
 // DeleteFile from SFTP server.
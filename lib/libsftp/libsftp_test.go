@@ -0,0 +1,271 @@
+package libsftp_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"github.com/FObersteiner/gosyncit/lib/internal/sftptest"
+	"github.com/FObersteiner/gosyncit/lib/libsftp"
+)
+
+func TestUploadFileDelta(t *testing.T) {
+	sc := sftptest.Pipe(t)
+
+	dirA, err := os.MkdirTemp("", "dirA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirA)
+
+	dirB, err := os.MkdirTemp("", "dirB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirB)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	local := filepath.Join(dirA, "file")
+	if err := os.WriteFile(local, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	remote := filepath.Join(dirB, "file")
+	n, err := libsftp.UploadFileDelta(sc, local, remote, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(content)) {
+		t.Logf("new remote file: expected %v bytes written, got %v", len(content), n)
+		t.Fail()
+	}
+
+	// change one byte and truncate
+	content = content[:len(content)-100]
+	content[5000] = 'x'
+	if err := os.WriteFile(local, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	n, err = libsftp.UploadFileDelta(sc, local, remote, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1024 {
+		t.Logf("expected one chunk to be written, got %v bytes", n)
+		t.Fail()
+	}
+
+	have, _ := os.ReadFile(remote)
+	if !bytes.Equal(have, content) {
+		t.Log("remote content differs from local after delta upload")
+		t.Fail()
+	}
+
+	// a remote file that cannot be read gets a full upload
+	if os.Getuid() == 0 {
+		return // root can read anything
+	}
+	if err := os.Chmod(remote, 0200); err != nil {
+		t.Fatal(err)
+	}
+	content[0] = 'x'
+	if err := os.WriteFile(local, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	n, err = libsftp.UploadFileDelta(sc, local, remote, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(content)) {
+		t.Logf("write-only remote file: expected %v bytes written, got %v", len(content), n)
+		t.Fail()
+	}
+}
+
+func TestLink(t *testing.T) {
+	sc := sftptest.Pipe(t)
+
+	dir, err := os.MkdirTemp("", "dirA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "a")
+	if err := os.WriteFile(target, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "b")
+	if err := os.WriteFile(name, []byte("other content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// existing file 'b' is replaced by the link
+	if err := libsftp.Link(sc, target, name); err != nil {
+		t.Fatal(err)
+	}
+	fstA, _ := os.Stat(target)
+	fstB, _ := os.Stat(name)
+	if !os.SameFile(fstA, fstB) {
+		t.Log("expected 'b' to be a hard link to 'a'")
+		t.Fail()
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	for _, tc := range []struct {
+		s, usr, host, path string
+		ok                 bool
+	}{
+		{"user@host:/data", "user", "host", "/data", true},
+		{"host:data/sub", "", "host", "data/sub", true},
+		{"user@host:", "user", "host", ".", true},
+		{"/local/path", "", "", "", false},
+		{"./dir:with:colons", "", "", "", false},
+		{`C:\data`, "", "", "", false},
+		{"relative", "", "", "", false},
+	} {
+		usr, host, path, ok := libsftp.ParseURL(tc.s)
+		if usr != tc.usr || host != tc.host || path != tc.path || ok != tc.ok {
+			t.Logf("ParseURL(%q): want %q %q %q %v, have %q %q %q %v",
+				tc.s, tc.usr, tc.host, tc.path, tc.ok, usr, host, path, ok)
+			t.Fail()
+		}
+	}
+}
+
+func TestClockOffset(t *testing.T) {
+	sc := sftptest.Pipe(t)
+
+	dir, err := os.MkdirTemp("", "clock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the in-process server uses the local clock
+	offset, err := libsftp.ClockOffset(sc, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset.Abs() > time.Second {
+		t.Logf("expected offset of at most 1s, got %v", offset)
+		t.Fail()
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Log("probe file was not removed")
+		t.Fail()
+	}
+
+	if _, err := libsftp.ClockOffset(sc, filepath.Join(dir, "does-not-exist")); err == nil {
+		t.Log("expected error for missing directory")
+		t.Fail()
+	}
+}
+
+func TestPreserveAttrs(t *testing.T) {
+	sc := sftptest.Pipe(t)
+	dir := t.TempDir()
+
+	local := filepath.Join(dir, "local")
+	if err := os.WriteFile(local, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+	atime := mtime.Add(time.Hour)
+	if err := os.Chtimes(local, atime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	remote := filepath.Join(dir, "remote")
+	if _, err := libsftp.UploadFileWith(sc, local, remote, libsftp.Preserve{Perms: true}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Logf("upload: expected mtime %v, got %v", mtime, info.ModTime())
+		t.Fail()
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Logf("upload: expected mode 0600, got %v", info.Mode().Perm())
+		t.Fail()
+	}
+
+	back := filepath.Join(dir, "back")
+	if _, err := libsftp.DownloadFileWith(sc, remote, back, libsftp.Preserve{Perms: true}); err != nil {
+		t.Fatal(err)
+	}
+	info, err = os.Stat(back)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Logf("download: expected mtime %v, got %v", mtime, info.ModTime())
+		t.Fail()
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Logf("download: expected mode 0600, got %v", info.Mode().Perm())
+		t.Fail()
+	}
+
+	if err := libsftp.CheckSetstat(sc, dir); err != nil {
+		t.Logf("in-process server supports setstat, got %v", err)
+		t.Fail()
+	}
+}
+
+// BenchmarkUpload shows the throughput of uploads with different client options and
+// number of connections, to an in-process server with a simulated latency.
+func BenchmarkUpload(b *testing.B) {
+	const size = 4 << 20
+	dir := b.TempDir()
+	local := filepath.Join(dir, "local")
+	if err := os.WriteFile(local, bytes.Repeat([]byte("0123456789abcdef"), size/16), 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bc := range []struct {
+		name        string
+		opts        libsftp.ClientOptions
+		connections int
+	}{
+		{"default", libsftp.ClientOptions{}, 1},
+		{"packet=128KiB", libsftp.ClientOptions{MaxPacket: 128 << 10}, 1},
+		{"requests=8", libsftp.ClientOptions{MaxConcurrentRequests: 8}, 1},
+		{"concurrent-writes", libsftp.ClientOptions{ConcurrentWrites: true}, 1},
+		{"connections=4", libsftp.ClientOptions{}, 4},
+		{"concurrent-writes,connections=4", libsftp.ClientOptions{ConcurrentWrites: true}, 4},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			clients := make([]*sftp.Client, bc.connections)
+			for i := range clients {
+				clients[i] = sftptest.PipeLatency(b, 5*time.Millisecond, bc.opts.Options()...)
+			}
+			b.SetBytes(size * int64(bc.connections))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				errs := make(chan error, len(clients))
+				for j, sc := range clients {
+					go func(sc *sftp.Client, j int) {
+						_, err := libsftp.UploadFile(sc, local, filepath.Join(dir, fmt.Sprintf("remote%d", j)))
+						errs <- err
+					}(sc, j)
+				}
+				for range clients {
+					if err := <-errs; err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package libsftp
+
+import (
+	"errors"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// ClientOptions tune the throughput of an SFTP client; zero values keep the pkg/sftp defaults.
+type ClientOptions struct {
+	// MaxPacket is the maximum size of the payload of a packet, 32768 by default.
+	// Larger packets are not supported by all servers (OpenSSH accepts up to 256 KiB).
+	MaxPacket int
+	// MaxConcurrentRequests is the number of requests in flight per file, 64 by default
+	MaxConcurrentRequests int
+	// ConcurrentWrites writes a file with concurrent requests, which might leave holes in
+	// the remote file if the upload fails
+	ConcurrentWrites bool
+	// SequentialReads disables concurrent read requests when downloading a file
+	SequentialReads bool
+}
+
+// Options returns the options to pass to sftp.NewClient
+func (o ClientOptions) Options() []sftp.ClientOption {
+	var opts []sftp.ClientOption
+	if o.MaxPacket > 0 {
+		opts = append(opts, sftp.MaxPacketUnchecked(o.MaxPacket))
+	}
+	if o.MaxConcurrentRequests > 0 {
+		opts = append(opts, sftp.MaxConcurrentRequestsPerFile(o.MaxConcurrentRequests))
+	}
+	opts = append(opts,
+		sftp.UseConcurrentWrites(o.ConcurrentWrites),
+		sftp.UseConcurrentReads(!o.SequentialReads),
+	)
+	return opts
+}
+
+// Pool of SFTP clients, each with its own SSH connection, to transfer several files at once.
+// The first client is used for everything that is not a file transfer.
+type Pool struct {
+	Clients []*sftp.Client
+	conns   []*ssh.Client
+}
+
+// NewPool establishes 'n' SSH connections with the given Credentials (at least one),
+// each with an SFTP client with options 'opts'.
+func NewPool(creds Credentials, n int, opts ClientOptions) (*Pool, error) {
+	p := &Pool{}
+	for i := 0; i < max(n, 1); i++ {
+		conn, err := GetSSHconn(creds)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.conns = append(p.conns, conn)
+		sc, err := sftp.NewClient(conn, opts.Options()...)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.Clients = append(p.Clients, sc)
+	}
+	return p, nil
+}
+
+// Close all clients and connections of the pool
+func (p *Pool) Close() error {
+	var errs []error
+	for _, sc := range p.Clients {
+		errs = append(errs, sc.Close())
+	}
+	for _, conn := range p.conns {
+		errs = append(errs, conn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Conn returns the SSH connection of the first client, e.g. to run commands on the server
+func (p *Pool) Conn() *ssh.Client {
+	return p.conns[0]
+}
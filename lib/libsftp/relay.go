@@ -0,0 +1,82 @@
+package libsftp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/sftp"
+)
+
+// RelayFile copies 'srcFile' on the SFTP server of 'src' to 'dstFile' on the server of 'dst'.
+// The content is streamed from one connection to the other, without a local copy.
+// The directory path on dst must exist. Attributes are set like UploadFileWith does.
+func RelayFile(src, dst *sftp.Client, srcFile, dstFile string, p Preserve) (n int64, err error) {
+	srcF, err := src.Open(srcFile)
+	if err != nil {
+		return 0, fmt.Errorf("unable to open source file: %v", err)
+	}
+	defer srcF.Close()
+
+	srcInfo, err := srcF.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("unable to get source file stats: %v", err)
+	}
+
+	dstF, err := dst.OpenFile(dstFile, (os.O_WRONLY | os.O_CREATE | os.O_TRUNC))
+	if err != nil {
+		return 0, fmt.Errorf("unable to open destination file: %v", err)
+	}
+
+	// sftp.File.WriteTo reads with concurrent requests
+	n, err = io.Copy(dstF, srcF)
+	if err != nil {
+		dstF.Close()
+		return 0, fmt.Errorf("unable to relay file: %v", err)
+	}
+	// close first, the server might update the mtime on close
+	if err := dstF.Close(); err != nil {
+		return n, fmt.Errorf("unable to close destination file: %v", err)
+	}
+
+	return n, SetAttrs(dst, dstFile, srcInfo, p)
+}
+
+// RelayEqual returns true if the content of 'srcFile' on the SFTP server of 'src' and 'dstFile'
+// on the server of 'dst' is equal, like DeepEqual. Both files are read completely, unless the sizes differ.
+func RelayEqual(src, dst *sftp.Client, srcFile, dstFile string) (bool, error) {
+	srcF, err := src.Open(srcFile)
+	if err != nil {
+		return false, fmt.Errorf("unable to open source file: %v", err)
+	}
+	defer srcF.Close()
+
+	dstF, err := dst.Open(dstFile)
+	if err != nil {
+		return false, fmt.Errorf("unable to open destination file: %v", err)
+	}
+	defer dstF.Close()
+
+	srcInfo, err := srcF.Stat()
+	if err != nil {
+		return false, err
+	}
+	dstInfo, err := dstF.Stat()
+	if err != nil {
+		return false, err
+	}
+	if srcInfo.Size() != dstInfo.Size() {
+		return false, nil
+	}
+
+	hSrc, hDst := sha256.New(), sha256.New()
+	if _, err := srcF.WriteTo(hSrc); err != nil {
+		return false, err
+	}
+	if _, err := dstF.WriteTo(hDst); err != nil {
+		return false, err
+	}
+	return bytes.Equal(hSrc.Sum(nil), hDst.Sum(nil)), nil
+}
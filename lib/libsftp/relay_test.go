@@ -0,0 +1,73 @@
+package libsftp_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FObersteiner/gosyncit/lib/internal/sftptest"
+	"github.com/FObersteiner/gosyncit/lib/libsftp"
+)
+
+func TestRelayFile(t *testing.T) {
+	src, dst := sftptest.Pipe(t), sftptest.Pipe(t)
+	dirSrc, dirDst := t.TempDir(), t.TempDir()
+
+	content := make([]byte, 1<<20+17) // more than one request
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	srcFile := filepath.Join(dirSrc, "file")
+	if err := os.WriteFile(srcFile, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+	atime := mtime.Add(time.Hour)
+	if err := os.Chtimes(srcFile, atime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	dstFile := filepath.Join(dirDst, "file")
+	n, err := libsftp.RelayFile(src, dst, srcFile, dstFile, libsftp.Preserve{Atime: true, Perms: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(content)) {
+		t.Logf("expected %v bytes relayed, got %v", len(content), n)
+		t.Fail()
+	}
+	got, err := os.ReadFile(dstFile)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Logf("relayed file differs (%v)", err)
+		t.Fail()
+	}
+	info, err := os.Stat(dstFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Logf("expected mtime %v, got %v", mtime, info.ModTime())
+		t.Fail()
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Logf("expected mode 0600, got %v", info.Mode().Perm())
+		t.Fail()
+	}
+
+	equal, err := libsftp.RelayEqual(src, dst, srcFile, dstFile)
+	if err != nil || !equal {
+		t.Logf("expected relayed file to be equal (%v)", err)
+		t.Fail()
+	}
+	content[1<<20] ^= 0xff
+	if err := os.WriteFile(dstFile, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if equal, err := libsftp.RelayEqual(src, dst, srcFile, dstFile); err != nil || equal {
+		t.Logf("expected modified file to differ (%v)", err)
+		t.Fail()
+	}
+}
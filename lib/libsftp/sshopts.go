@@ -0,0 +1,134 @@
+package libsftp
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// keepaliveCountMax is the number of unanswered keepalive requests after which the
+// connection is closed, like ServerAliveCountMax of OpenSSH
+const keepaliveCountMax = 3
+
+// SSHOptions of an SSH connection; zero values keep the defaults of golang.org/x/crypto/ssh.
+// SSH-level compression is not available, as that package implements no compression method.
+type SSHOptions struct {
+	// ConnectTimeout limits establishing the TCP connection and the SSH handshake (0: no limit)
+	ConnectTimeout time.Duration
+	// Keepalive is the interval of keepalive requests to the server, so that NAT routers and
+	// firewalls do not drop an idle connection (0: none). The connection is closed if the
+	// server does not answer keepaliveCountMax requests in a row.
+	Keepalive time.Duration
+	// Timeout closes the connection if the server sends nothing for this long (0: no limit).
+	// Use it with a shorter Keepalive, otherwise an idle connection is closed as well.
+	Timeout time.Duration
+	// algorithms in order of preference
+	Ciphers           []string
+	KeyExchanges      []string
+	MACs              []string
+	HostKeyAlgorithms []string // ssh-rsa if empty
+}
+
+// Dial connects to 'addr' with 'config', after applying the options to it
+func (o SSHOptions) Dial(addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	config.Ciphers, config.KeyExchanges, config.MACs = o.Ciphers, o.KeyExchanges, o.MACs
+	config.HostKeyAlgorithms = o.HostKeyAlgorithms
+	if len(config.HostKeyAlgorithms) == 0 {
+		// the default host key is ssh-rsa:
+		config.HostKeyAlgorithms = []string{"ssh-rsa"}
+	}
+
+	nc, err := net.DialTimeout("tcp", addr, o.ConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn = nc
+	if o.Timeout > 0 {
+		conn = &idleConn{Conn: nc, timeout: o.Timeout}
+	}
+
+	// the deadlines of the connection belong to Timeout, so end a stuck handshake by closing it
+	timedOut := func() bool { return false }
+	if o.ConnectTimeout > 0 {
+		timer := time.AfterFunc(o.ConnectTimeout, func() { nc.Close() })
+		timedOut = func() bool { return !timer.Stop() }
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if timedOut() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, fmt.Errorf("ssh handshake with %s: no response within %v", addr, o.ConnectTimeout)
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	if o.Keepalive > 0 {
+		go keepalive(client, o.Keepalive)
+	}
+	return client, nil
+}
+
+// keepalive sends a keepalive request to the server every 'interval', until the connection is
+// closed. It closes the connection if keepaliveCountMax requests in a row are not answered.
+func keepalive(c *ssh.Client, interval time.Duration) {
+	closed := make(chan struct{})
+	go func() {
+		_ = c.Wait()
+		close(closed)
+	}()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	replies := make(chan error, 1)
+	pending, missed := false, 0
+	for {
+		select {
+		case <-closed:
+			return
+		case err := <-replies:
+			if err != nil {
+				return
+			}
+			pending, missed = false, 0
+		case <-t.C:
+			if pending {
+				if missed++; missed >= keepaliveCountMax {
+					c.Close()
+					return
+				}
+				continue
+			}
+			pending = true
+			go func() {
+				// any reply counts, servers answer unknown requests with a failure
+				_, _, err := c.SendRequest("keepalive@openssh.com", true, nil)
+				replies <- err
+			}()
+		}
+	}
+}
+
+// idleConn fails reads and writes that do not make progress within 'timeout'
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,184 @@
+package libsftp_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/FObersteiner/gosyncit/lib/libsftp"
+)
+
+// sshListener starts an SSH server on a local port that handles the global requests of each
+// connection with 'global'. Returns its address.
+func sshListener(t *testing.T, config *ssh.ServerConfig, global func(reqs <-chan *ssh.Request)) string {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.NoClientAuth = true
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn, chans, reqs, err := ssh.NewServerConn(nc, config)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				go func() {
+					for newCh := range chans {
+						_ = newCh.Reject(ssh.Prohibited, "no channels")
+					}
+				}()
+				global(reqs)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func clientConfig() *ssh.ClientConfig {
+	return &ssh.ClientConfig{User: "test", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+}
+
+// closedWithin reports if connection 'c' is closed within 'd'
+func closedWithin(c *ssh.Client, d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		_ = c.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func TestSSHOptionsAlgorithms(t *testing.T) {
+	config := &ssh.ServerConfig{}
+	config.Ciphers = []string{"aes256-ctr"}
+	addr := sshListener(t, config, ssh.DiscardRequests)
+
+	o := libsftp.SSHOptions{Ciphers: []string{"aes128-ctr"}, HostKeyAlgorithms: []string{"ssh-ed25519"}}
+	if c, err := o.Dial(addr, clientConfig()); err == nil {
+		c.Close()
+		t.Log("expected no common cipher")
+		t.Fail()
+	}
+
+	o.Ciphers = append(o.Ciphers, "aes256-ctr")
+	c, err := o.Dial(addr, clientConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	// ssh-rsa by default, which the server does not have
+	if c, err := (libsftp.SSHOptions{}).Dial(addr, clientConfig()); err == nil {
+		c.Close()
+		t.Log("expected no common host key algorithm")
+		t.Fail()
+	}
+}
+
+func TestSSHOptionsConnectTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		// accept, but never answer
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer nc.Close()
+		}
+	}()
+
+	t0 := time.Now()
+	o := libsftp.SSHOptions{ConnectTimeout: 100 * time.Millisecond}
+	if _, err := o.Dial(l.Addr().String(), clientConfig()); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if dt := time.Since(t0); dt > 5*time.Second {
+		t.Logf("handshake timed out after %v", dt)
+		t.Fail()
+	}
+}
+
+func TestSSHOptionsKeepalive(t *testing.T) {
+	var n atomic.Int32
+	addr := sshListener(t, &ssh.ServerConfig{}, func(reqs <-chan *ssh.Request) {
+		for req := range reqs {
+			if req.Type == "keepalive@openssh.com" {
+				n.Add(1)
+			}
+			_ = req.Reply(false, nil)
+		}
+	})
+
+	// the server only sends keepalive replies, which keep the connection open
+	o := libsftp.SSHOptions{Keepalive: 20 * time.Millisecond, Timeout: 200 * time.Millisecond, HostKeyAlgorithms: []string{"ssh-ed25519"}}
+	c, err := o.Dial(addr, clientConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if closedWithin(c, time.Second) {
+		t.Log("connection closed despite keepalive")
+		t.Fail()
+	}
+	if n.Load() < 2 {
+		t.Logf("want several keepalive requests, got %v", n.Load())
+		t.Fail()
+	}
+}
+
+func TestSSHOptionsTimeout(t *testing.T) {
+	// the server answers nothing
+	silent := func(reqs <-chan *ssh.Request) {
+		for range reqs {
+		}
+	}
+	addr := sshListener(t, &ssh.ServerConfig{}, silent)
+
+	for _, o := range []libsftp.SSHOptions{
+		{Timeout: 100 * time.Millisecond},
+		{Keepalive: 20 * time.Millisecond},
+	} {
+		o.HostKeyAlgorithms = []string{"ssh-ed25519"}
+		c, err := o.Dial(addr, clientConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !closedWithin(c, 5*time.Second) {
+			t.Logf("%+v: connection to a silent server not closed", o)
+			t.Fail()
+		}
+		c.Close()
+	}
+}
@@ -0,0 +1,278 @@
+// Package manifest creates and verifies checksum manifests of directories.
+// A manifest lists path, size, mtime and SHA-256 hash of each file. It can be written
+// in sha256sum-compatible format (hash and path only), as JSON or as CSV.
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FObersteiner/gosyncit/lib/fileset"
+)
+
+// Manifest formats
+const (
+	FormatSha256sum = "sha256sum"
+	FormatJSON      = "json"
+	FormatCSV       = "csv"
+)
+
+// csvHeader is the first line of a manifest in CSV format
+var csvHeader = []string{"path", "size", "mtime", "sha256"}
+
+// Entry of a manifest. Size is -1 and Mtime is zero if unknown (sha256sum format).
+type Entry struct {
+	Path   string    `json:"path"`
+	Size   int64     `json:"size"`
+	Mtime  time.Time `json:"mtime"`
+	Sha256 string    `json:"sha256"`
+}
+
+// FormatFromName returns the manifest format for a file name: JSON for '.json',
+// CSV for '.csv' and sha256sum otherwise.
+func FormatFromName(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return FormatJSON
+	case ".csv":
+		return FormatCSV
+	}
+	return FormatSha256sum
+}
+
+// HashFile returns the hex-encoded SHA-256 hash of a file
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Create a manifest of the regular files in a populated fileset, sorted by path.
+// Files for which 'skip' returns true are left out; skip may be nil.
+// Hashes are taken from 'prev' for files whose size and mtime did not change.
+func Create(fs *fileset.Fileset, prev []Entry, skip func(name string) bool) ([]Entry, error) {
+	known := make(map[string]Entry, len(prev))
+	for _, e := range prev {
+		known[e.Path] = e
+	}
+
+	var entries []Entry
+	for name, info := range fs.Paths {
+		if !info.Mode().IsRegular() || (skip != nil && skip(name)) {
+			continue
+		}
+		e := Entry{
+			Path:  filepath.ToSlash(name),
+			Size:  info.Size(),
+			Mtime: info.ModTime().UTC(),
+		}
+		if p, ok := known[e.Path]; ok && p.Size == e.Size && p.Mtime.Equal(e.Mtime) {
+			e.Sha256 = p.Sha256
+		} else {
+			h, err := HashFile(filepath.Join(fs.Basepath, name))
+			if err != nil {
+				return nil, err
+			}
+			e.Sha256 = h
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+// Write entries to w in the given format
+func Write(w io.Writer, entries []Entry, format string) error {
+	switch format {
+	case FormatSha256sum:
+		bw := bufio.NewWriter(w)
+		for _, e := range entries {
+			if _, err := fmt.Fprintf(bw, "%s  %s\n", e.Sha256, e.Path); err != nil {
+				return err
+			}
+		}
+		return bw.Flush()
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if entries == nil {
+			entries = []Entry{}
+		}
+		return enc.Encode(entries)
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			rec := []string{e.Path, strconv.FormatInt(e.Size, 10), e.Mtime.Format(time.RFC3339Nano), e.Sha256}
+			if err := cw.Write(rec); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown manifest format '%s'", format)
+}
+
+// WriteFile writes entries to file 'name', in the format given by its extension (see FormatFromName).
+// The file is replaced atomically.
+func WriteFile(name string, entries []Entry) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails after successful rename; no problem
+	if err := Write(tmp, entries, FormatFromName(name)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// Read a manifest in any of the formats; the format is detected from the content.
+func Read(r io.Reader) ([]Entry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		var entries []Entry
+		err := json.Unmarshal(trimmed, &entries)
+		return entries, err
+	case bytes.HasPrefix(trimmed, []byte(strings.Join(csvHeader, ","))):
+		return readCSV(bytes.NewReader(data))
+	}
+	return readSha256sum(bytes.NewReader(data))
+}
+
+// ReadFile reads a manifest from file 'name', see Read
+func ReadFile(name string) ([]Entry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+func readCSV(r io.Reader) ([]Entry, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(records))
+	for i, rec := range records[1:] { // skip header
+		if len(rec) != len(csvHeader) {
+			return nil, fmt.Errorf("line %v: want %v fields, have %v", i+2, len(csvHeader), len(rec))
+		}
+		size, err := strconv.ParseInt(rec[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", i+2, err)
+		}
+		mtime, err := time.Parse(time.RFC3339Nano, rec[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", i+2, err)
+		}
+		entries = append(entries, Entry{Path: rec[0], Size: size, Mtime: mtime, Sha256: rec[3]})
+	}
+	return entries, nil
+}
+
+// readSha256sum reads lines of the form '<hash>  <path>' (text mode) or '<hash> *<path>' (binary mode)
+func readSha256sum(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		hash, path, ok := strings.Cut(text, " ")
+		if !ok || len(hash) != 2*sha256.Size || len(path) < 2 || (path[0] != ' ' && path[0] != '*') {
+			return nil, fmt.Errorf("line %v: not in sha256sum format", line)
+		}
+		entries = append(entries, Entry{Path: path[1:], Size: -1, Sha256: strings.ToLower(hash)})
+	}
+	return entries, scanner.Err()
+}
+
+// Result of verifying a directory against a manifest
+type Result struct {
+	Missing   []string // in the manifest, but not in the directory
+	Extra     []string // in the directory, but not in the manifest
+	Corrupted []string // size or hash differ from the manifest
+	Ok        int
+}
+
+// Equal reports whether the directory matches the manifest
+func (r Result) Equal() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.Corrupted) == 0
+}
+
+// Verify the regular files of a populated fileset against manifest entries.
+// Files for which 'skip' returns true are not reported as extra; skip may be nil.
+func Verify(fs *fileset.Fileset, entries []Entry, skip func(name string) bool) (Result, error) {
+	var r Result
+	listed := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		listed[e.Path] = true
+		info, ok := fs.Paths[filepath.FromSlash(e.Path)]
+		if !ok || !info.Mode().IsRegular() {
+			r.Missing = append(r.Missing, e.Path)
+			continue
+		}
+		if e.Size >= 0 && e.Size != info.Size() {
+			r.Corrupted = append(r.Corrupted, e.Path)
+			continue
+		}
+		h, err := HashFile(filepath.Join(fs.Basepath, filepath.FromSlash(e.Path)))
+		if err != nil {
+			return r, err
+		}
+		if h != e.Sha256 {
+			r.Corrupted = append(r.Corrupted, e.Path)
+			continue
+		}
+		r.Ok++
+	}
+	for name, info := range fs.Paths {
+		if info.Mode().IsRegular() && !listed[filepath.ToSlash(name)] && (skip == nil || !skip(name)) {
+			r.Extra = append(r.Extra, filepath.ToSlash(name))
+		}
+	}
+	sort.Strings(r.Missing)
+	sort.Strings(r.Extra)
+	sort.Strings(r.Corrupted)
+	return r, nil
+}
@@ -0,0 +1,165 @@
+package manifest_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/FObersteiner/gosyncit/lib/fileset"
+	"github.com/FObersteiner/gosyncit/lib/manifest"
+)
+
+func TestManifest(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mtime := time.Date(2006, time.March, 1, 3, 4, 5, 6000, time.UTC)
+	write := func(path, content string) {
+		_ = os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(dir, "a"), "content a")
+	write(filepath.Join(dir, "sub", "b"), "content b")
+	write(filepath.Join(dir, "sub", "c"), "content c")
+
+	fs, _ := fileset.New(dir)
+	_ = fs.Populate()
+	entries, err := manifest.Create(fs, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[1].Path != "sub/b" || entries[1].Size != 9 || !entries[1].Mtime.Equal(mtime) {
+		t.Fatalf("unexpected manifest %+v", entries)
+	}
+	if sum := sha256.Sum256([]byte("content a")); entries[0].Sha256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected hash %v", entries[0].Sha256)
+	}
+
+	// all formats can be read back
+	for _, format := range []string{manifest.FormatSha256sum, manifest.FormatJSON, manifest.FormatCSV} {
+		var buf bytes.Buffer
+		if err := manifest.Write(&buf, entries, format); err != nil {
+			t.Fatal(err)
+		}
+		have, err := manifest.Read(&buf)
+		if err != nil {
+			t.Fatalf("%v: %v", format, err)
+		}
+		want := entries
+		if format == manifest.FormatSha256sum {
+			want = nil
+			for _, e := range entries {
+				want = append(want, manifest.Entry{Path: e.Path, Size: -1, Sha256: e.Sha256})
+			}
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Logf("%v: want %+v, have %+v", format, want, have)
+			t.Fail()
+		}
+	}
+
+	// hashes of unchanged files are reused
+	prev := []manifest.Entry{{Path: "a", Size: 9, Mtime: mtime, Sha256: "reused"}}
+	updated, err := manifest.Create(fs, prev, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated[0].Sha256 != "reused" {
+		t.Log("expected hash of unchanged file to be reused")
+		t.Fail()
+	}
+
+	// verify
+	mf := filepath.Join(dir, "MANIFEST.csv")
+	if err := manifest.WriteFile(mf, entries); err != nil {
+		t.Fatal(err)
+	}
+	skip := func(name string) bool { return name == "MANIFEST.csv" }
+	write(filepath.Join(dir, "sub", "b"), "CONTENT b") // same size and mtime
+	_ = os.Remove(filepath.Join(dir, "sub", "c"))
+	write(filepath.Join(dir, "d"), "content d")
+
+	entries, err = manifest.ReadFile(mf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, _ = fileset.New(dir)
+	_ = fs.Populate()
+	r, err := manifest.Verify(fs, entries, skip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := manifest.Result{Missing: []string{"sub/c"}, Extra: []string{"d"}, Corrupted: []string{"sub/b"}, Ok: 1}
+	if !reflect.DeepEqual(r, want) || r.Equal() {
+		t.Logf("verify: want %+v, have %+v", want, r)
+		t.Fail()
+	}
+}
+
+func TestScrub(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mtime := time.Date(2006, time.March, 1, 3, 4, 5, 6000, time.UTC)
+	write := func(name, content string, mtime time.Time) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"ok", "missing", "modified", "corrupted", "changed"} {
+		write(name, "content", mtime)
+	}
+
+	fs, _ := fileset.New(dir)
+	_ = fs.Populate()
+	entries, err := manifest.Create(fs, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range entries {
+		if entries[i].Path == "changed" { // as read from sha256sum format
+			entries[i].Size, entries[i].Mtime = -1, time.Time{}
+		}
+	}
+
+	_ = os.Remove(filepath.Join(dir, "missing"))
+	write("modified", "CONTENT", mtime.Add(time.Hour))
+	write("corrupted", "CONTENT", mtime)
+	write("changed", "CONTENT", mtime.Add(time.Hour))
+
+	fs, _ = fileset.New(dir)
+	_ = fs.Populate()
+	findings, err := manifest.Scrub(fs, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != len(entries) {
+		t.Fatalf("want %v findings, have %v", len(entries), len(findings))
+	}
+	for _, f := range findings {
+		if f.Status != f.Path {
+			t.Logf("'%v': have status %v", f.Path, f.Status)
+			t.Fail()
+		}
+	}
+}
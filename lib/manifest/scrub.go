@@ -0,0 +1,52 @@
+package manifest
+
+import (
+	"path/filepath"
+
+	"github.com/FObersteiner/gosyncit/lib/fileset"
+)
+
+// Status of a file checked by Scrub
+const (
+	StatusOk        = "ok"
+	StatusMissing   = "missing"
+	StatusModified  = "modified"  // size or mtime changed; a legitimate modification
+	StatusCorrupted = "corrupted" // size and mtime unchanged, but the hash differs
+	StatusChanged   = "changed"   // hash differs, but no size and mtime were recorded to tell why
+)
+
+// Finding of Scrub for one manifest entry
+type Finding struct {
+	Entry
+	Status string
+}
+
+// Scrub re-hashes the files of a populated fileset that are listed in the manifest entries
+// and compares them to the recorded hashes. Files whose size or mtime changed are reported
+// as modified without hashing them. Files not listed in the manifest are ignored.
+func Scrub(fs *fileset.Fileset, entries []Entry) ([]Finding, error) {
+	findings := make([]Finding, 0, len(entries))
+	for _, e := range entries {
+		f := Finding{Entry: e, Status: StatusOk}
+		info, ok := fs.Paths[filepath.FromSlash(e.Path)]
+		switch {
+		case !ok || !info.Mode().IsRegular():
+			f.Status = StatusMissing
+		case e.Size >= 0 && (e.Size != info.Size() || !e.Mtime.Equal(info.ModTime())):
+			f.Status = StatusModified
+		default:
+			h, err := HashFile(filepath.Join(fs.Basepath, filepath.FromSlash(e.Path)))
+			if err != nil {
+				return findings, err
+			}
+			if h != e.Sha256 {
+				f.Status = StatusCorrupted
+				if e.Size < 0 {
+					f.Status = StatusChanged
+				}
+			}
+		}
+		findings = append(findings, f)
+	}
+	return findings, nil
+}
@@ -0,0 +1,66 @@
+package safety
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+var (
+	ErrEmptySrc = errors.New("source is empty; refusing to delete the content of the destination")
+	ErrAborted  = errors.New("deletion aborted by user")
+)
+
+// Limits guard the clean step of a mirror against deleting more than intended,
+// e.g. if the source is an unmounted mount point.
+type Limits struct {
+	MaxDelete        int     // maximum number of deletions; 0 means no limit
+	MaxDeletePercent float64 // maximum percentage of destination entries to delete; 0 means no limit
+	AllowEmptySrc    bool    // allow deleting from dst even though src is empty
+}
+
+// Check returns an error if deleting nDelete of nDst destination entries
+// with nSrc source entries violates the limits.
+func (l Limits) Check(nDelete, nSrc, nDst int) error {
+	if nDelete == 0 {
+		return nil
+	}
+	if nSrc == 0 && !l.AllowEmptySrc {
+		return ErrEmptySrc
+	}
+	if l.MaxDelete > 0 && nDelete > l.MaxDelete {
+		return fmt.Errorf("%v deletions exceed the limit of %v", nDelete, l.MaxDelete)
+	}
+	if l.MaxDeletePercent > 0 && nDst > 0 {
+		if p := 100 * float64(nDelete) / float64(nDst); p > l.MaxDeletePercent {
+			return fmt.Errorf("deleting %.1f %% of the destination exceeds the limit of %.1f %%", p, l.MaxDeletePercent)
+		}
+	}
+	return nil
+}
+
+// Confirm lists 'items' on w and asks the user to confirm their deletion.
+// Only an answer starting with 'y' or 'Y' counts as confirmation.
+func Confirm(r io.Reader, w io.Writer, items []string) (bool, error) {
+	for _, item := range items {
+		fmt.Fprintf(w, "  %s\n", item)
+	}
+	fmt.Fprintf(w, "delete %v files / dirs listed above? [y/N] ", len(items))
+
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	answer = strings.TrimSpace(answer)
+	return strings.HasPrefix(answer, "y") || strings.HasPrefix(answer, "Y"), nil
+}
+
+// IsTerminal returns true if f is an interactive terminal
+func IsTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
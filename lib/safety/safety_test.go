@@ -0,0 +1,52 @@
+package safety_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/FObersteiner/gosyncit/lib/safety"
+)
+
+func TestCheck(t *testing.T) {
+	for _, tc := range []struct {
+		limits              safety.Limits
+		nDelete, nSrc, nDst int
+		wantErr             bool
+	}{
+		{safety.Limits{}, 0, 0, 10, false},
+		{safety.Limits{}, 10, 0, 10, true},
+		{safety.Limits{AllowEmptySrc: true}, 10, 0, 10, false},
+		{safety.Limits{MaxDelete: 5}, 5, 1, 10, false},
+		{safety.Limits{MaxDelete: 5}, 6, 1, 10, true},
+		{safety.Limits{MaxDeletePercent: 50}, 5, 1, 10, false},
+		{safety.Limits{MaxDeletePercent: 50}, 6, 1, 10, true},
+	} {
+		err := tc.limits.Check(tc.nDelete, tc.nSrc, tc.nDst)
+		if (err != nil) != tc.wantErr {
+			t.Logf("%+v, delete %v (src %v, dst %v): want error %v, got %v",
+				tc.limits, tc.nDelete, tc.nSrc, tc.nDst, tc.wantErr, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	var out bytes.Buffer
+	ok, err := safety.Confirm(strings.NewReader("y\n"), &out, []string{"a", "b"})
+	if err != nil || !ok {
+		t.Fatalf("expected confirmation, got %v, %v", ok, err)
+	}
+	if !strings.Contains(out.String(), "  a\n  b\n") {
+		t.Logf("items must be listed, got %q", out.String())
+		t.Fail()
+	}
+
+	for _, answer := range []string{"", "\n", "n\n", "no"} {
+		ok, err = safety.Confirm(strings.NewReader(answer), &out, []string{"a"})
+		if err != nil || ok {
+			t.Logf("answer %q must not confirm, got %v, %v", answer, ok, err)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package sftpd
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"github.com/FObersteiner/gosyncit/lib/space"
+)
+
+// rootFS handles sftp requests on the files below a root directory. Request paths are
+// interpreted relative to the root, and paths that leave it, also via symbolic links, are rejected.
+type rootFS struct {
+	root     string // as given
+	real     string // root with symbolic links resolved
+	readOnly bool
+}
+
+func newRootFS(root string, readOnly bool) (*rootFS, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New("root must be a directory")
+	}
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	return &rootFS{root: root, real: real, readOnly: readOnly}, nil
+}
+
+func (r *rootFS) handlers() sftp.Handlers {
+	return sftp.Handlers{FileGet: r, FilePut: r, FileCmd: r, FileList: r}
+}
+
+// resolve maps request path 'p' to the local file system. If follow is set, a symbolic
+// link at 'p' itself must point inside the root, too; otherwise only its parents are checked.
+func (r *rootFS) resolve(p string, follow bool) (string, error) {
+	rel := path.Clean("/" + p)
+	full := filepath.Join(r.root, filepath.FromSlash(rel))
+	check := full
+	if !follow && rel != "/" {
+		check = filepath.Dir(full)
+	}
+	// the part of the path that does not exist yet cannot contain links
+	for {
+		real, err := filepath.EvalSymlinks(check)
+		if err == nil {
+			if !within(r.real, real) {
+				return "", sftp.ErrSSHFxPermissionDenied
+			}
+			return full, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) || check == r.root {
+			return "", err
+		}
+		check = filepath.Dir(check)
+	}
+}
+
+// within reports if 'p' is 'dir' or below it
+func within(dir, p string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, string(os.PathSeparator))+string(os.PathSeparator))
+}
+
+// Fileread opens a file for reading
+func (r *rootFS) Fileread(req *sftp.Request) (io.ReaderAt, error) {
+	name, err := r.resolve(req.Filepath, true)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(name)
+}
+
+// Filewrite opens a file for writing
+func (r *rootFS) Filewrite(req *sftp.Request) (io.WriterAt, error) {
+	return r.openFile(req, os.O_WRONLY)
+}
+
+// OpenFile opens a file for reading and writing
+func (r *rootFS) OpenFile(req *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	return r.openFile(req, os.O_RDWR)
+}
+
+func (r *rootFS) openFile(req *sftp.Request, flag int) (*os.File, error) {
+	if r.readOnly {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	name, err := r.resolve(req.Filepath, true)
+	if err != nil {
+		return nil, err
+	}
+	pflags := req.Pflags()
+	if pflags.Append {
+		flag |= os.O_APPEND
+	}
+	if pflags.Creat {
+		flag |= os.O_CREATE
+	}
+	if pflags.Trunc {
+		flag |= os.O_TRUNC
+	}
+	if pflags.Excl {
+		flag |= os.O_EXCL
+	}
+	perm := os.FileMode(0644)
+	if req.AttrFlags().Permissions {
+		perm = req.Attributes().FileMode().Perm()
+	}
+	return os.OpenFile(name, flag, perm)
+}
+
+// Filecmd runs requests that modify the file system
+func (r *rootFS) Filecmd(req *sftp.Request) error {
+	if r.readOnly {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	switch req.Method {
+	case "Setstat":
+		name, err := r.resolve(req.Filepath, true)
+		if err != nil {
+			return err
+		}
+		return setstat(name, req)
+	case "Rename", "PosixRename":
+		return r.twoPaths(req, os.Rename)
+	case "Link":
+		return r.twoPaths(req, os.Link)
+	case "Symlink":
+		// Filepath is the target of the link, as given by the client
+		name, err := r.resolve(req.Target, false)
+		if err != nil {
+			return err
+		}
+		target := filepath.FromSlash(req.Filepath)
+		if filepath.IsAbs(target) || !within(r.root, filepath.Join(filepath.Dir(name), target)) {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		return os.Symlink(target, name)
+	case "Mkdir":
+		name, err := r.resolve(req.Filepath, false)
+		if err != nil {
+			return err
+		}
+		return os.Mkdir(name, 0755)
+	case "Rmdir", "Remove":
+		name, err := r.resolve(req.Filepath, false)
+		if err != nil {
+			return err
+		}
+		if name == r.root {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		return os.Remove(name)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// PosixRename renames a file, replacing the target if it exists
+func (r *rootFS) PosixRename(req *sftp.Request) error {
+	return r.Filecmd(req)
+}
+
+// StatVFS reports the free space of the file system of the root
+func (r *rootFS) StatVFS(req *sftp.Request) (*sftp.StatVFS, error) {
+	name, err := r.resolve(req.Filepath, true)
+	if err != nil {
+		return nil, err
+	}
+	free, err := space.Free(name)
+	if err != nil {
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+	// sizes are given in units of 1 byte, only the free space is known
+	return &sftp.StatVFS{Bsize: 1, Frsize: 1, Bfree: free, Bavail: free, Namemax: 255}, nil
+}
+
+func (r *rootFS) twoPaths(req *sftp.Request, op func(oldname, newname string) error) error {
+	from, err := r.resolve(req.Filepath, false)
+	if err != nil {
+		return err
+	}
+	to, err := r.resolve(req.Target, false)
+	if err != nil {
+		return err
+	}
+	if from == r.root || to == r.root {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return op(from, to)
+}
+
+func setstat(name string, req *sftp.Request) error {
+	flags, attrs := req.AttrFlags(), req.Attributes()
+	if flags.Size {
+		if err := os.Truncate(name, int64(attrs.Size)); err != nil {
+			return err
+		}
+	}
+	if flags.Permissions {
+		if err := os.Chmod(name, attrs.FileMode().Perm()); err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		if err := os.Chtimes(name, time.Unix(int64(attrs.Atime), 0), time.Unix(int64(attrs.Mtime), 0)); err != nil {
+			return err
+		}
+	}
+	if flags.UidGid {
+		if err := os.Chown(name, int(attrs.UID), int(attrs.GID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Filelist lists directories and stats files
+func (r *rootFS) Filelist(req *sftp.Request) (sftp.ListerAt, error) {
+	switch req.Method {
+	case "List":
+		name, err := r.resolve(req.Filepath, true)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		infos := make([]os.FileInfo, 0, len(entries))
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil {
+				continue // removed in the meantime
+			}
+			infos = append(infos, info)
+		}
+		return lister(infos), nil
+	case "Stat":
+		name, err := r.resolve(req.Filepath, true)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		return lister{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// Lstat stats files without following a symbolic link
+func (r *rootFS) Lstat(req *sftp.Request) (sftp.ListerAt, error) {
+	name, err := r.resolve(req.Filepath, false)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	return lister{info}, nil
+}
+
+// Readlink returns the target of a symbolic link
+func (r *rootFS) Readlink(p string) (string, error) {
+	name, err := r.resolve(p, false)
+	if err != nil {
+		return "", err
+	}
+	target, err := os.Readlink(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(target), nil
+}
+
+type lister []os.FileInfo
+
+// ListAt copies the entries starting at 'offset' to 'ls'
+func (l lister) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,197 @@
+// Package sftpd implements an SFTP server that exposes a single directory to clients
+// authenticated by public key.
+package sftpd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Config of a Server
+type Config struct {
+	Root           string          // directory that clients see as '/'
+	ReadOnly       bool            // reject all requests that would modify Root
+	AuthorizedKeys []ssh.PublicKey // public keys of the clients allowed to log in
+	HostKey        ssh.Signer      // see LoadHostKey
+	// Logf is called for connections, logins and failed requests if it is not nil
+	Logf func(format string, v ...any)
+}
+
+// Server serves the sftp subsystem over SSH
+type Server struct {
+	cfg  Config
+	fs   *rootFS
+	conf *ssh.ServerConfig
+}
+
+// New returns a Server for 'cfg'. Root must be an existing directory.
+func New(cfg Config) (*Server, error) {
+	if cfg.HostKey == nil {
+		return nil, errors.New("no host key")
+	}
+	fs, err := newRootFS(cfg.Root, cfg.ReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{cfg: cfg, fs: fs}
+	s.conf = &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, k := range cfg.AuthorizedKeys {
+				if bytes.Equal(k.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, fmt.Errorf("unknown public key for %q", meta.User())
+		},
+	}
+	s.conf.AddHostKey(cfg.HostKey)
+	return s, nil
+}
+
+// Serve accepts connections on 'l' until it is closed
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			if err := s.ServeConn(nc); err != nil {
+				s.logf("%v: %v\n", nc.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// ServeConn runs the SSH handshake on 'nc' and serves its sessions until the client disconnects
+func (s *Server) ServeConn(nc net.Conn) error {
+	defer nc.Close()
+	conn, chans, reqs, err := ssh.NewServerConn(nc, s.conf)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	s.logf("%v: login as %q\n", conn.RemoteAddr(), conn.User())
+	go ssh.DiscardRequests(reqs)
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			_ = newCh.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			return err
+		}
+		go s.session(ch, chReqs)
+	}
+	s.logf("%v: disconnected\n", conn.RemoteAddr())
+	return nil
+}
+
+// session serves the sftp subsystem on 'ch'; shells and commands are rejected
+func (s *Server) session(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		var payload struct{ Value string }
+		_ = ssh.Unmarshal(req.Payload, &payload)
+		if req.Type != "subsystem" || payload.Value != "sftp" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		_ = req.Reply(true, nil)
+		server := sftp.NewRequestServer(ch, s.fs.handlers())
+		if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+			s.logf("sftp session ended: %v\n", err)
+		}
+		server.Close()
+		return
+	}
+}
+
+func (s *Server) logf(format string, v ...any) {
+	if s.cfg.Logf != nil {
+		s.cfg.Logf(format, v...)
+	}
+}
+
+// DefaultHostKeyPath returns the default location of the host key in the user's config directory
+func DefaultHostKeyPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gosyncit", "ssh_host_rsa_key"), nil
+}
+
+// LoadHostKey reads the private host key from file 'path'. If the file does not exist,
+// a new RSA key is generated and written to it. RSA is used since the sftpmirror client
+// only accepts ssh-rsa host keys.
+func LoadHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return ssh.ParsePrivateKey(data)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 3072)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+// LoadAuthorizedKeys parses the public keys in file 'path', in the format of OpenSSH's
+// authorized_keys. Entries with options (e.g. command=, from= or restrict) are skipped, since
+// the server cannot enforce them; their number is returned as 'skipped'.
+func LoadAuthorizedKeys(path string) (keys []ssh.PublicKey, skipped int, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, _, options, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error parsing '%s': %v", path, err)
+		}
+		if len(options) > 0 {
+			skipped++
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, skipped, fmt.Errorf("no keys without options in '%s'", path)
+	}
+	return keys, skipped, nil
+}
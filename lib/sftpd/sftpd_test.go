@@ -0,0 +1,238 @@
+package sftpd_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/FObersteiner/gosyncit/lib/libsftp"
+	"github.com/FObersteiner/gosyncit/lib/sftpd"
+)
+
+func newKey(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// serve starts a server for 'root' that accepts 'authorized' and returns its address
+func serve(t *testing.T, root string, readOnly bool, authorized ssh.PublicKey) string {
+	hostKey, err := sftpd.LoadHostKey(filepath.Join(t.TempDir(), "host_key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := sftpd.New(sftpd.Config{
+		Root:           root,
+		ReadOnly:       readOnly,
+		AuthorizedKeys: []ssh.PublicKey{authorized},
+		HostKey:        hostKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go s.Serve(l)
+	return l.Addr().String()
+}
+
+func connect(t *testing.T, addr string, key ssh.Signer) (*sftp.Client, error) {
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:              "test",
+		Auth:              []ssh.AuthMethod{ssh.PublicKeys(key)},
+		HostKeyAlgorithms: []string{"ssh-rsa"}, // like libsftp.GetSSHconn
+		HostKeyCallback:   ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { conn.Close() })
+	return sftp.NewClient(conn)
+}
+
+func TestServer(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	key := newKey(t)
+	addr := serve(t, root, false, key.PublicKey())
+
+	if _, err := connect(t, addr, newKey(t)); err == nil {
+		t.Log("expected login with an unknown key to fail")
+		t.Fail()
+	}
+
+	sc, err := connect(t, addr, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+
+	if err := sc.MkdirAll("/dir/sub"); err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(local, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := libsftp.UploadFile(sc, local, "/dir/sub/file"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(root, "dir", "sub", "file"))
+	if err != nil || string(got) != "content" {
+		t.Logf("uploaded file: want 'content', got '%s' (%v)", got, err)
+		t.Fail()
+	}
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := sc.Chtimes("/dir/sub/file", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	info, err := sc.Stat("/dir/sub/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Logf("mtime not set: want %v, got %v", mtime, info.ModTime())
+		t.Fail()
+	}
+	if err := libsftp.Rename(sc, "/dir/sub/file", "/dir/renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := libsftp.Link(sc, "/dir/renamed", "/dir/linked"); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := sc.StatVFS("/"); err != nil || st.Bavail == 0 {
+		t.Logf("statvfs: %+v (%v)", st, err)
+		t.Fail()
+	}
+
+	// paths are confined to the root
+	if _, err := sc.Stat("/../../" + filepath.Base(outside) + "/secret"); err == nil {
+		t.Log("expected path outside root to fail")
+		t.Fail()
+	}
+	if _, err := sc.Open("/escape/secret"); err == nil {
+		t.Log("expected reading via a link that leaves the root to fail")
+		t.Fail()
+	}
+	if _, err := sc.Create("/escape/new"); err == nil {
+		t.Log("expected writing via a link that leaves the root to fail")
+		t.Fail()
+	}
+	if err := sc.Symlink("../../etc", "/dir/etc"); err == nil {
+		t.Log("expected creating a link that leaves the root to fail")
+		t.Fail()
+	}
+	if _, err := sc.Lstat("/escape"); err != nil {
+		t.Logf("lstat of the link itself: %v", err)
+		t.Fail()
+	}
+	if err := sc.Remove("/escape"); err != nil {
+		t.Logf("removing the link itself: %v", err)
+		t.Fail()
+	}
+	if _, err := os.Stat(filepath.Join(outside, "secret")); err != nil {
+		t.Log("file outside root was touched")
+		t.Fail()
+	}
+}
+
+func TestServerReadOnly(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "file"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	key := newKey(t)
+	sc, err := connect(t, serve(t, root, true, key.PublicKey()), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+
+	f, err := sc.Open("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || string(got) != "content" {
+		t.Logf("want 'content', got '%s' (%v)", got, err)
+		t.Fail()
+	}
+	if _, err := sc.Create("/new"); err == nil {
+		t.Log("expected create to fail in read-only mode")
+		t.Fail()
+	}
+	if err := sc.Remove("/file"); err == nil {
+		t.Log("expected remove to fail in read-only mode")
+		t.Fail()
+	}
+	if err := sc.Mkdir("/dir"); err == nil {
+		t.Log("expected mkdir to fail in read-only mode")
+		t.Fail()
+	}
+}
+
+func TestHostKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gosyncit", "host_key")
+	a, err := sftpd.LoadHostKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := sftpd.LoadHostKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ssh.FingerprintSHA256(a.PublicKey()) != ssh.FingerprintSHA256(b.PublicKey()) {
+		t.Log("expected the generated host key to be reused")
+		t.Fail()
+	}
+	if a.PublicKey().Type() != ssh.KeyAlgoRSA {
+		t.Logf("want RSA host key, got %s", a.PublicKey().Type())
+		t.Fail()
+	}
+}
+
+func TestLoadAuthorizedKeys(t *testing.T) {
+	a, b := newKey(t), newKey(t)
+	content := "# comment\n\n" + string(ssh.MarshalAuthorizedKey(a.PublicKey())) +
+		`no-pty,command="true" ` + string(ssh.MarshalAuthorizedKey(b.PublicKey()))
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keys, skipped, err := sftpd.LoadAuthorizedKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the restricted key must not get access
+	if len(keys) != 1 || skipped != 1 || !bytes.Equal(keys[0].Marshal(), a.PublicKey().Marshal()) {
+		t.Logf("want 1 key and 1 skipped, got %v and %v", len(keys), skipped)
+		t.Fail()
+	}
+}
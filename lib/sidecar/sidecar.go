@@ -0,0 +1,217 @@
+// Package sidecar records the modification times of files on an SFTP server that does not
+// allow setting them (setstat). For each uploaded file, the store keeps the mtime of the source,
+// along with the size and mtime the server assigned, so that a later change on the server
+// invalidates the entry. The store is a plain text file on the server.
+package sidecar
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"github.com/FObersteiner/gosyncit/lib/fileset"
+	"github.com/FObersteiner/gosyncit/lib/libsftp"
+)
+
+// Name of the store file, in the remote directory
+const Name = ".gosyncit-mtimes"
+
+// tmpName is the file the store is written to before it is renamed to Name
+const tmpName = "." + Name + ".tmp"
+
+// IsStore returns true if 'name', relative to the remote directory, is the store file or its temporary file
+func IsStore(name string) bool {
+	return name == Name || name == tmpName
+}
+
+// header is the first line of a store file
+const header = "gosyncit sidecar v1"
+
+// entry for one remote file
+type entry struct {
+	mtime  int64 // unix nanoseconds, of the source
+	remote int64 // unix seconds, assigned by the server
+	size   int64
+}
+
+// Store of modification times of the files in a remote directory, by path relative to that directory.
+// Use Load to create one, Save to write it back. A nil *Store is valid; it has no entries.
+type Store struct {
+	Dir  string // remote directory
+	Path string // file the store is kept in, on the server
+
+	entries map[string]entry
+	dirty   bool
+}
+
+// Load the store of directory 'dir' on the SFTP server. A missing file is an empty store.
+func Load(sc *sftp.Client, dir string) (*Store, error) {
+	name := path.Join(dir, Name)
+	s := &Store{Dir: dir, Path: name, entries: make(map[string]entry)}
+	f, err := sc.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !scanner.Scan() || scanner.Text() != header {
+		return nil, fmt.Errorf("'%s' is not a sidecar file", name)
+	}
+	line := 1
+	for scanner.Scan() {
+		line++
+		p, e, err := parseLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("'%s' line %v: %v", name, line, err)
+		}
+		s.entries[p] = e
+	}
+	return s, scanner.Err()
+}
+
+// parseLine parses 'mtime remote size "path"'
+func parseLine(s string) (string, entry, error) {
+	var e entry
+	fields := strings.SplitN(s, " ", 4)
+	if len(fields) != 4 {
+		return "", e, errors.New("invalid number of fields")
+	}
+	var err error
+	if e.mtime, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return "", e, err
+	}
+	if e.remote, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+		return "", e, err
+	}
+	if e.size, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+		return "", e, err
+	}
+	p, err := strconv.Unquote(fields[3])
+	return p, e, err
+}
+
+// Len returns the number of entries in the store
+func (s *Store) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.entries)
+}
+
+// Set records modification time 'mtime' for file 'name',
+// which has os.FileInfo 'remote' on the server.
+func (s *Store) Set(name string, mtime time.Time, remote os.FileInfo) {
+	s.entries[name] = entry{mtime.UnixNano(), remote.ModTime().Unix(), remote.Size()}
+	s.dirty = true
+}
+
+// Remove the entry of file 'name'
+func (s *Store) Remove(name string) {
+	if s == nil {
+		return
+	}
+	if _, ok := s.entries[name]; ok {
+		delete(s.entries, name)
+		s.dirty = true
+	}
+}
+
+// Move the entries of 'from' and everything below it to 'to', like fileset.Fileset.Move
+func (s *Store) Move(from, to string) {
+	if s == nil {
+		return
+	}
+	moved := make(map[string]entry)
+	for p, e := range s.entries {
+		if p == from || strings.HasPrefix(p, from+"/") {
+			delete(s.entries, p)
+			moved[to+strings.TrimPrefix(p, from)] = e
+		}
+	}
+	for p, e := range moved {
+		s.entries[p] = e
+		s.dirty = true
+	}
+}
+
+// Stat returns os.FileInfo 'info' of remote file 'name', with the recorded modification time
+// if there is an entry and the file did not change on the server since it was recorded.
+func (s *Store) Stat(name string, info os.FileInfo) os.FileInfo {
+	if s == nil {
+		return info
+	}
+	e, ok := s.entries[name]
+	if !ok || info.IsDir() || e.size != info.Size() || e.remote != info.ModTime().Unix() {
+		return info
+	}
+	return fileInfo{info, time.Unix(0, e.mtime)}
+}
+
+// Apply replaces the file infos of 'fs' with the recorded modification times, see Stat.
+// Entries of files that no longer exist are removed.
+func (s *Store) Apply(fs *fileset.Fileset) {
+	if s == nil {
+		return
+	}
+	for p := range s.entries {
+		if _, ok := fs.Paths[p]; !ok {
+			s.Remove(p)
+		}
+	}
+	for p, info := range fs.Paths {
+		fs.Paths[p] = s.Stat(p, info)
+	}
+}
+
+// Save writes the store to its file on the SFTP server, if it changed.
+// The file is written to a temporary file first, then renamed.
+func (s *Store) Save(sc *sftp.Client) error {
+	if s == nil || !s.dirty {
+		return nil
+	}
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, header)
+	for p, e := range s.entries {
+		fmt.Fprintf(&buf, "%d %d %d %s\n", e.mtime, e.remote, e.size, strconv.Quote(p))
+	}
+
+	tmp := path.Join(path.Dir(s.Path), tmpName)
+	f, err := sc.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, &buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := libsftp.Rename(sc, tmp, s.Path); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// fileInfo overrides the modification time of an os.FileInfo
+type fileInfo struct {
+	os.FileInfo
+	mtime time.Time
+}
+
+func (fi fileInfo) ModTime() time.Time { return fi.mtime }
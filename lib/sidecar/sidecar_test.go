@@ -0,0 +1,89 @@
+package sidecar_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FObersteiner/gosyncit/lib/fileset"
+	"github.com/FObersteiner/gosyncit/lib/internal/sftptest"
+	"github.com/FObersteiner/gosyncit/lib/sidecar"
+)
+
+func TestStore(t *testing.T) {
+	sc := sftptest.Pipe(t)
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "sub/c"} {
+		_ = os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st, err := sidecar.Load(sc, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Len() != 0 {
+		t.Fatal("new store must be empty")
+	}
+
+	fs := &fileset.Fileset{Basepath: dir + "/", Paths: make(map[string]os.FileInfo)}
+	if err := fs.SftpPopulate(sc); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2006, time.January, 2, 15, 4, 5, 123456789, time.UTC)
+	for _, name := range []string{"a", "b", "sub/c"} {
+		st.Set(name, mtime, fs.Paths[name])
+	}
+	st.Move("sub", "moved")
+	if err := os.Rename(filepath.Join(dir, "sub"), filepath.Join(dir, "moved")); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Save(sc); err != nil {
+		t.Fatal(err)
+	}
+
+	// change 'b' on the server; its entry is no longer valid
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "b"), later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err = sidecar.Load(sc, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Len() != 3 {
+		t.Fatalf("expected 3 entries, got %v", st.Len())
+	}
+	fs = &fileset.Fileset{Basepath: dir + "/", Paths: make(map[string]os.FileInfo)}
+	if err := fs.SftpPopulate(sc); err != nil {
+		t.Fatal(err)
+	}
+	st.Apply(fs)
+	if got := fs.Paths["a"].ModTime(); !got.Equal(mtime) {
+		t.Logf("a: expected recorded mtime, got %v", got)
+		t.Fail()
+	}
+	if got := fs.Paths["moved/c"].ModTime(); !got.Equal(mtime) {
+		t.Logf("moved/c: expected recorded mtime, got %v", got)
+		t.Fail()
+	}
+	if got := fs.Paths["b"].ModTime(); got.Equal(mtime) {
+		t.Log("b: changed on the server, must not use the recorded mtime")
+		t.Fail()
+	}
+
+	if !sidecar.IsStore(sidecar.Name) || sidecar.IsStore("a") {
+		t.Log("IsStore: unexpected result")
+		t.Fail()
+	}
+
+	var nilStore *sidecar.Store
+	if info := nilStore.Stat("a", fs.Paths["a"]); info != fs.Paths["a"] {
+		t.Log("nil store must return info unchanged")
+		t.Fail()
+	}
+}
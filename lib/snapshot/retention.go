@@ -0,0 +1,77 @@
+package snapshot
+
+import (
+	"fmt"
+	"time"
+)
+
+// Retention describes which snapshots to keep. A snapshot is kept if any of the rules applies.
+// The newest snapshot is always kept.
+type Retention struct {
+	Last    int // keep the last n snapshots
+	Daily   int // keep the newest snapshot of each day, for n days
+	Weekly  int // keep the newest snapshot of each (ISO) week, for n weeks
+	Monthly int // keep the newest snapshot of each month, for n months
+}
+
+// IsZero returns true if no rule is set
+func (r Retention) IsZero() bool {
+	return r.Last <= 0 && r.Daily <= 0 && r.Weekly <= 0 && r.Monthly <= 0
+}
+
+func (r Retention) String() string {
+	return fmt.Sprintf("last: %v, daily: %v, weekly: %v, monthly: %v", r.Last, r.Daily, r.Weekly, r.Monthly)
+}
+
+// Select splits snaps into those to keep and those to remove, relative to time 'now'.
+// snaps must be sorted from oldest to newest, as returned by List.
+func (r Retention) Select(snaps []Snapshot, now time.Time) (keep, remove []Snapshot) {
+	if len(snaps) == 0 {
+		return nil, nil
+	}
+
+	keepIdx := make(map[int]bool)
+	keepIdx[len(snaps)-1] = true // never remove the newest
+
+	for i := len(snaps) - 1; i >= 0 && i >= len(snaps)-r.Last; i-- {
+		keepIdx[i] = true
+	}
+
+	// bucket rules; iterate newest to oldest so that the newest of each bucket is kept.
+	rules := []struct {
+		since  time.Time
+		bucket func(time.Time) string
+	}{
+		{now.AddDate(0, 0, -r.Daily), func(t time.Time) string { return t.Format("2006-01-02") }},
+		{now.AddDate(0, 0, -7*r.Weekly), func(t time.Time) string {
+			y, w := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", y, w)
+		}},
+		{now.AddDate(0, -r.Monthly, 0), func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	for _, rule := range rules {
+		if !rule.since.Before(now) {
+			continue // rule not set
+		}
+		seen := make(map[string]bool)
+		for i := len(snaps) - 1; i >= 0; i-- {
+			if snaps[i].Time.Before(rule.since) {
+				break
+			}
+			b := rule.bucket(snaps[i].Time)
+			if !seen[b] {
+				seen[b] = true
+				keepIdx[i] = true
+			}
+		}
+	}
+
+	for i, s := range snaps {
+		if keepIdx[i] {
+			keep = append(keep, s)
+		} else {
+			remove = append(remove, s)
+		}
+	}
+	return keep, remove
+}
@@ -0,0 +1,63 @@
+package snapshot_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/FObersteiner/gosyncit/lib/snapshot"
+)
+
+func TestRetention(t *testing.T) {
+	now := time.Date(2024, time.March, 31, 12, 0, 0, 0, time.Local)
+
+	// two snapshots a day for 100 days
+	var snaps []snapshot.Snapshot
+	for d := 99; d >= 0; d-- {
+		for _, h := range []int{-6, -1} {
+			s, _ := snapshot.Parse(snapshot.Name(now.AddDate(0, 0, -d).Add(time.Duration(h) * time.Hour)))
+			snaps = append(snaps, s)
+		}
+	}
+
+	keep, remove := snapshot.Retention{}.Select(snaps, now)
+	if len(keep) != 1 || keep[0] != snaps[len(snaps)-1] {
+		t.Fatalf("newest snapshot must always be kept, got %v", keep)
+	}
+	if len(remove) != len(snaps)-1 {
+		t.Fatalf("expected %v to remove, got %v", len(snaps)-1, len(remove))
+	}
+
+	keep, _ = snapshot.Retention{Last: 3}.Select(snaps, now)
+	if len(keep) != 3 {
+		t.Logf("keep last 3: got %v", len(keep))
+		t.Fail()
+	}
+
+	keep, _ = snapshot.Retention{Daily: 7}.Select(snaps, now)
+	if len(keep) != 7 {
+		t.Logf("keep daily for 7 days: expected 7, got %v", len(keep))
+		t.Fail()
+	}
+	for _, k := range keep {
+		if k.Time.Hour() != 11 {
+			t.Logf("daily rule must keep the newest snapshot of the day, got %v", k.Name)
+			t.Fail()
+		}
+	}
+
+	keep, _ = snapshot.Retention{Monthly: 12}.Select(snaps, now)
+	// 100 days back from end of March: December to March
+	if len(keep) != 4 {
+		t.Logf("keep monthly: expected 4, got %v", len(keep))
+		t.Fail()
+	}
+
+	keep, remove = snapshot.Retention{Last: 2, Daily: 3, Weekly: 2}.Select(snaps, now)
+	if len(keep)+len(remove) != len(snaps) {
+		t.Fatal("every snapshot must be either kept or removed")
+	}
+	if len(keep) != 5 { // last 2 (same day) + 2 more days + 1 more week
+		t.Logf("combined rules: expected 5, got %v", len(keep))
+		t.Fail()
+	}
+}
@@ -0,0 +1,99 @@
+package snapshot
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	TimeFormat = "2006-01-02T15-04-05" // name of a snapshot directory
+	LatestName = "latest"              // symlink to the newest snapshot
+)
+
+// Snapshot is a timestamped directory within a snapshot root
+type Snapshot struct {
+	Name string
+	Time time.Time
+}
+
+// Name returns the directory name for a snapshot taken at t
+func Name(t time.Time) string {
+	return t.Format(TimeFormat)
+}
+
+// Parse returns the snapshot a directory name stands for.
+// The second return value is false if name is not a snapshot name.
+func Parse(name string) (Snapshot, bool) {
+	t, err := time.ParseInLocation(TimeFormat, name, time.Local)
+	if err != nil {
+		return Snapshot{}, false
+	}
+	return Snapshot{Name: name, Time: t}, true
+}
+
+// FromDirEntries picks the snapshot directories from a directory listing,
+// sorted from oldest to newest.
+func FromDirEntries(entries []os.FileInfo) []Snapshot {
+	var snaps []Snapshot
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if s, ok := Parse(e.Name()); ok {
+			snaps = append(snaps, s)
+		}
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Time.Before(snaps[j].Time) })
+	return snaps
+}
+
+// List returns the snapshots in directory 'root', sorted from oldest to newest
+func List(root string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return FromDirEntries(infos), nil
+}
+
+// Latest returns the name of the newest snapshot in 'root', as pointed to by the 'latest' symlink.
+// If there is no such link, the newest snapshot directory is used. An empty string means
+// there is no snapshot yet.
+func Latest(root string) (string, error) {
+	if target, err := os.Readlink(filepath.Join(root, LatestName)); err == nil {
+		if info, err := os.Stat(filepath.Join(root, target)); err == nil && info.IsDir() {
+			return filepath.Base(target), nil
+		}
+	}
+	snaps, err := List(root)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	if len(snaps) == 0 {
+		return "", nil
+	}
+	return snaps[len(snaps)-1].Name, nil
+}
+
+// SetLatest points the 'latest' symlink in 'root' to snapshot 'name'.
+// The link is replaced atomically, so it is always valid.
+func SetLatest(root, name string) error {
+	tmp := filepath.Join(root, "."+LatestName+".tmp")
+	_ = os.Remove(tmp)
+	if err := os.Symlink(name, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(root, LatestName))
+}
@@ -0,0 +1,57 @@
+package snapshot_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FObersteiner/gosyncit/lib/snapshot"
+)
+
+func TestLatest(t *testing.T) {
+	root := t.TempDir()
+
+	latest, err := snapshot.Latest(filepath.Join(root, "not-there"))
+	if err != nil || latest != "" {
+		t.Fatalf("expected no snapshot and no error, got '%s', %v", latest, err)
+	}
+
+	t0 := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.Local)
+	names := []string{
+		snapshot.Name(t0.Add(48 * time.Hour)),
+		snapshot.Name(t0),
+		snapshot.Name(t0.Add(24 * time.Hour)),
+	}
+	for _, n := range names {
+		if err := os.Mkdir(filepath.Join(root, n), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = os.Mkdir(filepath.Join(root, "not-a-snapshot"), 0755)
+
+	snaps, err := snapshot.List(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 3 || snaps[0].Name != names[1] || snaps[2].Name != names[0] {
+		t.Fatalf("expected 3 snapshots sorted oldest to newest, got %v", snaps)
+	}
+
+	// no link: newest directory
+	latest, _ = snapshot.Latest(root)
+	if latest != names[0] {
+		t.Logf("expected latest '%s', got '%s'", names[0], latest)
+		t.Fail()
+	}
+
+	// link takes precedence
+	if err := snapshot.SetLatest(root, names[2]); err != nil {
+		t.Fatal(err)
+	}
+	latest, _ = snapshot.Latest(root)
+	if latest != names[2] {
+		t.Logf("expected latest '%s', got '%s'", names[2], latest)
+		t.Fail()
+	}
+}
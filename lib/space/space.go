@@ -0,0 +1,69 @@
+// Package space checks if there is enough free space on a destination before copying.
+package space
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+
+	"github.com/FObersteiner/gosyncit/lib/fileset"
+)
+
+var errUnsupported = errors.New("free space cannot be determined on this platform")
+
+// Free returns the number of bytes available to the user on the file system of local 'path'.
+// If 'path' does not exist (yet), its closest existing parent directory is used.
+func Free(path string) (uint64, error) {
+	path = filepath.Clean(path)
+	for {
+		_, err := os.Stat(path)
+		if err == nil || !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	return free(path)
+}
+
+// FreeSftp returns the number of bytes available to the user on the file system of 'path'
+// on the SFTP server. The server must support the statvfs@openssh.com extension.
+func FreeSftp(sc *sftp.Client, path string) (uint64, error) {
+	st, err := sc.StatVFS(path)
+	if err != nil {
+		return 0, err
+	}
+	return st.Bavail * st.Frsize, nil
+}
+
+// Needed returns the number of bytes that copying the files of 'src' to 'dst' writes:
+// the size of files missing in dst, and the growth of files that 'changed' reports as changed.
+// If keepOld is set, the old version of a changed file is kept (e.g. in a backup directory),
+// so its full size counts. Files that are hard links to a file in src (see fileset.Links) do not count.
+func Needed(src, dst *fileset.Fileset, changed func(srcInfo, dstInfo os.FileInfo) bool, keepOld bool) uint64 {
+	var n uint64
+	for name, srcInfo := range src.Paths {
+		if !srcInfo.Mode().IsRegular() {
+			continue
+		}
+		if _, ok := src.Links[name]; ok {
+			continue
+		}
+		dstInfo, ok := dst.Paths[name]
+		switch {
+		case !ok || !dstInfo.Mode().IsRegular():
+			n += uint64(srcInfo.Size())
+		case !changed(srcInfo, dstInfo):
+		case keepOld:
+			n += uint64(srcInfo.Size())
+		case srcInfo.Size() > dstInfo.Size():
+			n += uint64(srcInfo.Size() - dstInfo.Size())
+		}
+	}
+	return n
+}
@@ -0,0 +1,7 @@
+//go:build !unix && !windows
+
+package space
+
+func free(path string) (uint64, error) {
+	return 0, errUnsupported
+}
@@ -0,0 +1,78 @@
+package space_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/FObersteiner/gosyncit/lib/compare"
+	"github.com/FObersteiner/gosyncit/lib/fileset"
+	"github.com/FObersteiner/gosyncit/lib/internal/sftptest"
+	"github.com/FObersteiner/gosyncit/lib/space"
+)
+
+func TestFree(t *testing.T) {
+	dir := t.TempDir()
+	free, err := space.Free(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free == 0 {
+		t.Log("expected free space in temp dir")
+		t.Fail()
+	}
+
+	// a destination that does not exist yet
+	missing, err := space.Free(filepath.Join(dir, "does", "not", "exist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing == 0 {
+		t.Log("expected free space of parent dir")
+		t.Fail()
+	}
+
+	remote, err := space.FreeSftp(sftptest.Pipe(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote == 0 {
+		t.Log("expected free space via SFTP")
+		t.Fail()
+	}
+}
+
+func TestNeeded(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	write := func(path string, size int) {
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(src, "new"), 100)
+	write(filepath.Join(src, "same"), 10)
+	write(filepath.Join(dst, "same"), 10)
+	write(filepath.Join(src, "grown"), 50)
+	write(filepath.Join(dst, "grown"), 20)
+	write(filepath.Join(src, "shrunk"), 5)
+	write(filepath.Join(dst, "shrunk"), 40)
+
+	fsSrc, _ := fileset.New(src)
+	_ = fsSrc.Populate()
+	fsDst, _ := fileset.New(dst)
+	_ = fsDst.Populate()
+
+	changed := func(srcInfo, dstInfo os.FileInfo) bool { return srcInfo.Size() != dstInfo.Size() }
+	if n := space.Needed(fsSrc, fsDst, changed, false); n != 100+30 {
+		t.Logf("expected 130 bytes, got %v", n)
+		t.Fail()
+	}
+	if n := space.Needed(fsSrc, fsDst, changed, true); n != 100+50+5 {
+		t.Logf("keep old: expected 155 bytes, got %v", n)
+		t.Fail()
+	}
+	if n := space.Needed(fsSrc, fsDst, compare.BasicUnequal, false); n < 100 {
+		t.Logf("expected at least 100 bytes, got %v", n)
+		t.Fail()
+	}
+}
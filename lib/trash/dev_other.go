@@ -0,0 +1,10 @@
+//go:build !unix
+
+package trash
+
+import "os"
+
+// device is not implemented on this platform; the home trash is used for all files.
+func device(info os.FileInfo) (dev uint64, ok bool) {
+	return 0, false
+}
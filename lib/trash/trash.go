@@ -0,0 +1,396 @@
+package trash
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"github.com/FObersteiner/gosyncit/lib/copy"
+	"github.com/FObersteiner/gosyncit/lib/libsftp"
+)
+
+const (
+	DefaultSftpDir = ".gosyncit-trash" // default trash directory on SFTP targets, relative to the target
+	infoSuffix     = ".trashinfo"
+	dateFormat     = "2006-01-02T15:04:05" // DeletionDate, see XDG trash spec
+	markerKey      = "X-Gosyncit"          // marks the items put in the trash by gosyncit
+)
+
+var ErrNotFound = errors.New("item not found in trash")
+
+// Item is an entry of the trash
+type Item struct {
+	Name    string    // name within the trash 'files' directory
+	Path    string    // original path
+	Deleted time.Time // time of deletion
+	Own     bool      // put in the trash by gosyncit; only those are expired or emptied
+}
+
+// Trash follows the layout of the XDG trash specification: deleted items are moved to
+// 'Dir/files', and for each item, a '.trashinfo' file in 'Dir/info' records the
+// original path and the time of deletion.
+// A nil *Trash is valid; its Contains method returns false.
+type Trash struct {
+	Dir  string
+	fsys fsys
+}
+
+// fsys abstracts the file operations needed, so that the trash works locally and via SFTP
+type fsys interface {
+	Rename(oldname, newname string) error
+	MkdirAll(path string) error
+	Chmod(name string, mode os.FileMode) error
+	WriteFile(name string, data []byte) error
+	ReadFile(name string) ([]byte, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	RemoveAll(path string) error
+	Lstat(name string) (os.FileInfo, error)
+	Join(elem ...string) string
+}
+
+// Home returns the home trash of the current user; '$XDG_DATA_HOME/Trash',
+// defaulting to '~/.local/share/Trash'.
+func Home() (*Trash, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return Local(filepath.Join(dataHome, "Trash")), nil
+}
+
+// For returns the trash for local file or directory 'path': the home trash if 'path' is on the
+// same file system, otherwise the trash in the top directory of its file system, see
+// the XDG trash spec; '$topdir/.Trash/$uid' if the administrator created '$topdir/.Trash',
+// else '$topdir/.Trash-$uid'. Items are thus never copied to another file system.
+func For(path string) (*Trash, error) {
+	home, err := Home()
+	if err != nil {
+		return nil, err
+	}
+	dev, ok := existingDevice(path)
+	if homeDev, homeOk := existingDevice(home.Dir); !ok || !homeOk || dev == homeDev {
+		return home, nil
+	}
+
+	// the top directory is the last parent on the same device
+	topdir, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		parent := filepath.Dir(topdir)
+		if parent == topdir {
+			break
+		}
+		if d, ok := existingDevice(parent); !ok || d != dev {
+			break
+		}
+		topdir = parent
+	}
+
+	uid := strconv.Itoa(os.Getuid())
+	// the shared trash must be a real directory with the sticky bit set
+	if info, err := os.Lstat(filepath.Join(topdir, ".Trash")); err == nil &&
+		info.IsDir() && info.Mode()&os.ModeSticky != 0 {
+		return Local(filepath.Join(topdir, ".Trash", uid)), nil
+	}
+	return Local(filepath.Join(topdir, ".Trash-"+uid)), nil
+}
+
+// existingDevice returns the device of 'path', or of its nearest existing parent
+func existingDevice(path string) (uint64, bool) {
+	for {
+		if info, err := os.Stat(path); err == nil {
+			return device(info)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return 0, false
+		}
+		path = parent
+	}
+}
+
+// Local returns a trash in local directory 'dir'
+func Local(dir string) *Trash {
+	return &Trash{Dir: dir, fsys: localFS{}}
+}
+
+// Sftp returns a trash in directory 'dir' on an SFTP server
+func Sftp(sc *sftp.Client, dir string) *Trash {
+	return &Trash{Dir: filepath.ToSlash(dir), fsys: sftpFS{sc}}
+}
+
+func (t *Trash) filesDir() string { return t.fsys.Join(t.Dir, "files") }
+func (t *Trash) infoDir() string  { return t.fsys.Join(t.Dir, "info") }
+
+// Contains returns true if 'file' is the trash directory or is located within it.
+func (t *Trash) Contains(file string) bool {
+	if t == nil {
+		return false
+	}
+	rel, err := filepath.Rel(t.Dir, file)
+	return err == nil && !strings.HasPrefix(rel, "..")
+}
+
+// Put moves 'file' (or directory) to the trash. It is a no-op if dry is true
+// or 'file' does not exist.
+func (t *Trash) Put(file string, dry bool) error {
+	if dry {
+		return nil
+	}
+	if _, err := t.fsys.Lstat(file); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if _, err := t.fsys.Lstat(t.Dir); errors.Is(err, os.ErrNotExist) {
+		// a trash is private to its user, see the XDG trash spec
+		if err := t.fsys.MkdirAll(t.Dir); err != nil {
+			return err
+		}
+		if err := t.fsys.Chmod(t.Dir, 0700); err != nil {
+			return err
+		}
+	}
+	if err := t.fsys.MkdirAll(t.filesDir()); err != nil {
+		return err
+	}
+	if err := t.fsys.MkdirAll(t.infoDir()); err != nil {
+		return err
+	}
+
+	// find a free name; the info file is written first, to reserve the name.
+	base := path.Base(filepath.ToSlash(file))
+	name := base
+	for i := 2; ; i++ {
+		_, errInfo := t.fsys.Lstat(t.fsys.Join(t.infoDir(), name+infoSuffix))
+		_, errFile := t.fsys.Lstat(t.fsys.Join(t.filesDir(), name))
+		if errors.Is(errInfo, os.ErrNotExist) && errors.Is(errFile, os.ErrNotExist) {
+			break
+		}
+		name = base + "." + strconv.Itoa(i)
+	}
+
+	info := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n%s=true\n",
+		(&url.URL{Path: filepath.ToSlash(file)}).EscapedPath(),
+		time.Now().Format(dateFormat),
+		markerKey,
+	)
+	infoFile := t.fsys.Join(t.infoDir(), name+infoSuffix)
+	if err := t.fsys.WriteFile(infoFile, []byte(info)); err != nil {
+		return err
+	}
+	if err := t.fsys.Rename(file, t.fsys.Join(t.filesDir(), name)); err != nil {
+		_ = t.fsys.RemoveAll(infoFile)
+		return fmt.Errorf("failed to move '%s' to trash: %v", file, err)
+	}
+	return nil
+}
+
+// List returns the items in the trash, sorted by time of deletion
+func (t *Trash) List() ([]Item, error) {
+	entries, err := t.fsys.ReadDir(t.infoDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var items []Item
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), infoSuffix) {
+			continue
+		}
+		item, err := t.item(strings.TrimSuffix(e.Name(), infoSuffix))
+		if err != nil {
+			continue // not a valid info file; leave it alone
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Deleted.Before(items[j].Deleted) })
+	return items, nil
+}
+
+// item parses the info file of trash entry 'name'
+func (t *Trash) item(name string) (Item, error) {
+	data, err := t.fsys.ReadFile(t.fsys.Join(t.infoDir(), name+infoSuffix))
+	if err != nil {
+		return Item{}, err
+	}
+	item := Item{Name: name}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Path":
+			if item.Path, err = url.PathUnescape(value); err != nil {
+				return item, err
+			}
+		case "DeletionDate":
+			if item.Deleted, err = time.ParseInLocation(dateFormat, value, time.Local); err != nil {
+				return item, err
+			}
+		case markerKey:
+			item.Own = value == "true"
+		}
+	}
+	if item.Path == "" {
+		return item, fmt.Errorf("no path in trash info of '%s'", name)
+	}
+	return item, nil
+}
+
+// Restore moves item 'name' back to its original path. Existing files are not overwritten.
+func (t *Trash) Restore(name string) error {
+	item, err := t.item(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: '%s'", ErrNotFound, name)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := t.fsys.Lstat(item.Path); err == nil {
+		return fmt.Errorf("cannot restore '%s': '%s' exists", name, item.Path)
+	}
+	if err := t.fsys.MkdirAll(path.Dir(filepath.ToSlash(item.Path))); err != nil {
+		return err
+	}
+	if err := t.fsys.Rename(t.fsys.Join(t.filesDir(), name), item.Path); err != nil {
+		return err
+	}
+	return t.fsys.RemoveAll(t.fsys.Join(t.infoDir(), name+infoSuffix))
+}
+
+// Remove deletes item 'name' from the trash, permanently
+func (t *Trash) Remove(name string) error {
+	if err := t.fsys.RemoveAll(t.fsys.Join(t.filesDir(), name)); err != nil {
+		return err
+	}
+	return t.fsys.RemoveAll(t.fsys.Join(t.infoDir(), name+infoSuffix))
+}
+
+// Expire removes all items put in the trash by gosyncit that were deleted longer than maxAge
+// before 'now'. Other items, e.g. those of a desktop environment in the home trash, are kept.
+// The removed items are returned.
+func (t *Trash) Expire(maxAge time.Duration, now time.Time) ([]Item, error) {
+	items, err := t.List()
+	if err != nil {
+		return nil, err
+	}
+	var expired []Item
+	for _, item := range items {
+		if now.Sub(item.Deleted) <= maxAge {
+			break // sorted by deletion time
+		}
+		if !item.Own {
+			continue
+		}
+		if err := t.Remove(item.Name); err != nil {
+			return expired, err
+		}
+		expired = append(expired, item)
+	}
+	return expired, nil
+}
+
+// Empty removes all items put in the trash by gosyncit
+func (t *Trash) Empty() error {
+	items, err := t.List()
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if !item.Own {
+			continue
+		}
+		if err := t.Remove(item.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------
+
+type localFS struct{}
+
+func (localFS) Rename(oldname, newname string) error { return copy.Move(oldname, newname) }
+func (localFS) MkdirAll(path string) error           { return copy.CreateDir(path, false) }
+func (localFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+func (localFS) WriteFile(name string, data []byte) error {
+	return os.WriteFile(name, data, copy.DefaultModeFile)
+}
+func (localFS) ReadFile(name string) ([]byte, error) { return os.ReadFile(name) }
+func (localFS) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+func (localFS) RemoveAll(path string) error            { return os.RemoveAll(path) }
+func (localFS) Lstat(name string) (os.FileInfo, error) { return os.Lstat(name) }
+func (localFS) Join(elem ...string) string             { return filepath.Join(elem...) }
+
+type sftpFS struct{ sc *sftp.Client }
+
+func (s sftpFS) Rename(oldname, newname string) error { return libsftp.Rename(s.sc, oldname, newname) }
+func (s sftpFS) MkdirAll(path string) error           { return s.sc.MkdirAll(path) }
+func (s sftpFS) Chmod(name string, mode os.FileMode) error {
+	return s.sc.Chmod(name, mode)
+}
+func (s sftpFS) WriteFile(name string, data []byte) error {
+	f, err := s.sc.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+func (s sftpFS) ReadFile(name string) ([]byte, error) {
+	f, err := s.sc.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(f)
+	return buf.Bytes(), err
+}
+func (s sftpFS) ReadDir(name string) ([]os.FileInfo, error) { return s.sc.ReadDir(name) }
+func (s sftpFS) RemoveAll(path string) error {
+	if _, err := s.sc.Lstat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return s.sc.RemoveAll(path)
+}
+func (s sftpFS) Lstat(name string) (os.FileInfo, error) { return s.sc.Lstat(name) }
+func (s sftpFS) Join(elem ...string) string             { return s.sc.Join(elem...) }
@@ -0,0 +1,162 @@
+package trash_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FObersteiner/gosyncit/lib/internal/sftptest"
+	"github.com/FObersteiner/gosyncit/lib/trash"
+)
+
+func TestTrash(t *testing.T) {
+	dir := t.TempDir()
+	tr := trash.Local(filepath.Join(t.TempDir(), "Trash"))
+
+	file := filepath.Join(dir, "sub", "file")
+	_ = os.MkdirAll(filepath.Dir(file), 0755)
+	for i := 0; i < 2; i++ {
+		if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := tr.Put(file, false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Fatal("file must have been moved to trash")
+		}
+	}
+
+	items, err := tr.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Name == items[1].Name {
+		t.Fatalf("expected 2 uniquely named items, got %v", items)
+	}
+	if items[0].Path != file {
+		t.Logf("expected original path '%s', got '%s'", file, items[0].Path)
+		t.Fail()
+	}
+
+	if err := tr.Restore(items[0].Name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Fatal("file must have been restored")
+	}
+	if err := tr.Restore(items[1].Name); err == nil {
+		t.Log("restore must not overwrite existing files")
+		t.Fail()
+	}
+
+	expired, err := tr.Expire(time.Hour, time.Now())
+	if err != nil || len(expired) != 0 {
+		t.Fatalf("nothing must expire yet, got %v, %v", expired, err)
+	}
+	expired, err = tr.Expire(time.Hour, time.Now().Add(2*time.Hour))
+	if err != nil || len(expired) != 1 {
+		t.Fatalf("expected one item to expire, got %v, %v", expired, err)
+	}
+
+	items, _ = tr.List()
+	if len(items) != 0 {
+		t.Logf("expected empty trash, got %v", items)
+		t.Fail()
+	}
+	if err := tr.Empty(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSftpTrash(t *testing.T) {
+	sc := sftptest.Pipe(t)
+	dir := t.TempDir()
+	tr := trash.Sftp(sc, filepath.Join(dir, trash.DefaultSftpDir))
+
+	file := filepath.Join(dir, "sub", "file")
+	_ = os.MkdirAll(filepath.Dir(file), 0755)
+	if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Put(filepath.Dir(file), false); err != nil {
+		t.Fatal(err)
+	}
+	if !tr.Contains(filepath.Join(tr.Dir, "files", "sub")) {
+		t.Log("trash must contain its own files")
+		t.Fail()
+	}
+
+	items, err := tr.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Path != filepath.Dir(file) {
+		t.Fatalf("expected directory in trash, got %v", items)
+	}
+	if err := tr.Empty(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(tr.Dir, "files", "sub")); !os.IsNotExist(err) {
+		t.Log("trash must be empty")
+		t.Fail()
+	}
+}
+
+func TestForeignItems(t *testing.T) {
+	tr := trash.Local(filepath.Join(t.TempDir(), "Trash"))
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Put(file, false); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(tr.Dir); err != nil || info.Mode().Perm() != 0700 {
+		t.Logf("trash directory must be private, got %v", info.Mode())
+		t.Fail()
+	}
+
+	// an item put in the trash by another program, e.g. a file manager
+	foreign := "[Trash Info]\nPath=/home/user/photo.jpg\nDeletionDate=2001-02-03T04:05:06\n"
+	if err := os.WriteFile(filepath.Join(tr.Dir, "info", "photo.jpg.trashinfo"), []byte(foreign), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tr.Dir, "files", "photo.jpg"), []byte("photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := tr.Expire(time.Hour, time.Now().Add(2*time.Hour))
+	if err != nil || len(expired) != 1 || expired[0].Path != file {
+		t.Fatalf("expected only own item to expire, got %v, %v", expired, err)
+	}
+	if err := tr.Empty(); err != nil {
+		t.Fatal(err)
+	}
+	items, err := tr.List()
+	if err != nil || len(items) != 1 || items[0].Own {
+		t.Fatalf("expected foreign item to be kept, got %v, %v", items, err)
+	}
+	if _, err := os.Stat(filepath.Join(tr.Dir, "files", "photo.jpg")); err != nil {
+		t.Log("foreign item must not be removed")
+		t.Fail()
+	}
+}
+
+func TestFor(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	home, err := trash.Home()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// same file system as the home trash
+	tr, err := trash.For(filepath.Join(t.TempDir(), "not", "there"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Dir != home.Dir {
+		t.Logf("expected home trash '%s', got '%s'", home.Dir, tr.Dir)
+		t.Fail()
+	}
+}